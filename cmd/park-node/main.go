@@ -8,11 +8,15 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"p2p-park/internal/netx"
 	parknode "p2p-park/internal/park-node"
 )
 
+// shutdownTimeout bounds how long quitting waits to say goodbye to peers.
+const shutdownTimeout = 3 * time.Second
+
 func main() {
 	name := flag.String("name", "anon", "display name")
 	bind := flag.String("bind", ":0", "bind address (e.g. :0 for random port)")
@@ -20,6 +24,12 @@ func main() {
 	bootstrapStr := flag.String("bootstrap", "", "comma-separated bootstrap addresses host:port")
 	debug := flag.Bool("debug", false, "enable debug logs")
 	dataDir := flag.String("data", "", "data directory for persistent state (default: user config dir)")
+	portMap := flag.Bool("portmap", false, "ask the home router to forward the listen port (PCP / NAT-PMP)")
+	gateway := flag.String("gateway", "", "router address for -portmap (default: default route gateway)")
+	limitIn := flag.Int("limit-in", 0, "max download rate in KiB/s across all peers (0 = unlimited)")
+	limitOut := flag.Int("limit-out", 0, "max upload rate in KiB/s across all peers (0 = unlimited)")
+	peerLimit := flag.Int("peer-limit", 0, "max rate in KiB/s each way for any one peer (0 = unlimited)")
+	idDifficulty := flag.Int("id-difficulty", 0, "leading zero bits node keys must hash to; every node in the network must agree (0 = off)")
 	flag.Parse()
 
 	var bootstraps []netx.Addr
@@ -41,6 +51,13 @@ func main() {
 		IsSeed:     *seed,
 		Bootstraps: bootstraps,
 		Debug:      *debug,
+		PortMap:    *portMap,
+		Gateway:    *gateway,
+		LimitIn:    *limitIn * 1024,
+		LimitOut:   *limitOut * 1024,
+		PeerLimit:  *peerLimit * 1024,
+
+		IDDifficulty: *idDifficulty,
 	}, logger)
 	if err != nil {
 		log.Fatalf("create app: %v", err)
@@ -53,7 +70,14 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := app.Run(ctx); err != nil {
-		log.Fatalf("run app: %v", err)
+	runErr := app.Run(ctx)
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := app.StopAll(stopCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if runErr != nil {
+		log.Fatalf("run app: %v", runErr)
 	}
 }
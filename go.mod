@@ -6,6 +6,5 @@ require (
 	github.com/flynn/noise v1.1.0
 	go.etcd.io/bbolt v1.3.10
 	golang.org/x/crypto v0.45.0
+	golang.org/x/sys v0.38.0
 )
-
-require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,148 @@
+package dm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"p2p-park/internal/crypto/sealed"
+	"p2p-park/internal/p2p"
+	"p2p-park/internal/proto"
+)
+
+var (
+	ErrInvalidMessage = errors.New("dm: invalid message")
+	ErrBadSignature   = errors.New("dm: bad signature")
+	ErrNotForUs       = errors.New("dm: not addressed to us")
+)
+
+// maxClockSkew bounds how far in the future a message timestamp may be.
+const maxClockSkew = 5 * time.Minute
+
+// Message is a stored direct message, decrypted.
+type Message struct {
+	ID          string `json:"id"`
+	PeerUserID  string `json:"peer_user_id"` // the other side of the conversation
+	PeerName    string `json:"peer_name,omitempty"`
+	Outgoing    bool   `json:"outgoing"`
+	Text        string `json:"text"`
+	Timestamp   int64  `json:"ts"`
+	DeliveredAt int64  `json:"delivered_at,omitempty"` // outgoing only; 0 until acked
+}
+
+// Engine seals, opens and acknowledges direct messages for one identity.
+type Engine struct {
+	selfName string
+	priv     ed25519.PrivateKey
+	pub      ed25519.PublicKey
+}
+
+func NewEngine(selfName string, priv ed25519.PrivateKey, pub ed25519.PublicKey) *Engine {
+	return &Engine{
+		selfName: selfName,
+		priv:     priv,
+		pub:      pub,
+	}
+}
+
+// Compose encrypts text to toUserID and signs the result.
+func (e *Engine) Compose(toUserID, text string) (proto.DirectMessage, Message, error) {
+	toPub, err := hex.DecodeString(toUserID)
+	if err != nil || len(toPub) != ed25519.PublicKeySize {
+		return proto.DirectMessage{}, Message{}, ErrInvalidMessage
+	}
+
+	body, err := json.Marshal(proto.DirectBody{Name: e.selfName, Text: text})
+	if err != nil {
+		return proto.DirectMessage{}, Message{}, err
+	}
+
+	m := proto.DirectMessage{
+		ID:        p2p.NewMsgID(),
+		FromPub:   append([]byte(nil), e.pub...),
+		ToPub:     toPub,
+		Timestamp: time.Now().Unix(),
+	}
+	// Bind the ciphertext to sender, recipient and id.
+	eph, nonce, ct, err := sealed.Seal(ed25519.PublicKey(toPub), body, aad(m))
+	if err != nil {
+		return proto.DirectMessage{}, Message{}, err
+	}
+	m.EphemeralPub, m.Nonce, m.Ciphertext = eph, nonce, ct
+	m.Signature = ed25519.Sign(e.priv, proto.EncodeDirectMessageCanonical(m))
+
+	return m, Message{
+		ID:         m.ID,
+		PeerUserID: toUserID,
+		Outgoing:   true,
+		Text:       text,
+		Timestamp:  m.Timestamp,
+	}, nil
+}
+
+// Open verifies and decrypts an incoming message.
+// Deduplication by ID is left to the Store.
+func (e *Engine) Open(m proto.DirectMessage) (Message, error) {
+	if m.ID == "" || len(m.FromPub) != ed25519.PublicKeySize {
+		return Message{}, ErrInvalidMessage
+	}
+	if !bytes.Equal(m.ToPub, e.pub) {
+		return Message{}, ErrNotForUs
+	}
+	if m.Timestamp > time.Now().Add(maxClockSkew).Unix() {
+		return Message{}, ErrInvalidMessage
+	}
+	if !ed25519.Verify(ed25519.PublicKey(m.FromPub), proto.EncodeDirectMessageCanonical(m), m.Signature) {
+		return Message{}, ErrBadSignature
+	}
+
+	pt, err := sealed.Open(e.priv, m.EphemeralPub, m.Nonce, m.Ciphertext, aad(m))
+	if err != nil {
+		return Message{}, err
+	}
+	var body proto.DirectBody
+	if err := json.Unmarshal(pt, &body); err != nil {
+		return Message{}, ErrInvalidMessage
+	}
+
+	return Message{
+		ID:         m.ID,
+		PeerUserID: hex.EncodeToString(m.FromPub),
+		PeerName:   body.Name,
+		Text:       body.Text,
+		Timestamp:  m.Timestamp,
+	}, nil
+}
+
+// Ack builds a signed acknowledgement for a message we received.
+func (e *Engine) Ack(id string) proto.DirectAck {
+	a := proto.DirectAck{
+		ID:        id,
+		FromPub:   append([]byte(nil), e.pub...),
+		Timestamp: time.Now().Unix(),
+	}
+	a.Signature = ed25519.Sign(e.priv, proto.EncodeDirectAckCanonical(a))
+	return a
+}
+
+// VerifyAck checks an acknowledgement's signature and returns the acking userID.
+func VerifyAck(a proto.DirectAck) (string, error) {
+	if a.ID == "" || len(a.FromPub) != ed25519.PublicKeySize {
+		return "", ErrInvalidMessage
+	}
+	if !ed25519.Verify(ed25519.PublicKey(a.FromPub), proto.EncodeDirectAckCanonical(a), a.Signature) {
+		return "", ErrBadSignature
+	}
+	return hex.EncodeToString(a.FromPub), nil
+}
+
+func aad(m proto.DirectMessage) []byte {
+	out := make([]byte, 0, len(m.ID)+len(m.FromPub)+len(m.ToPub))
+	out = append(out, m.ID...)
+	out = append(out, m.FromPub...)
+	out = append(out, m.ToPub...)
+	return out
+}
@@ -0,0 +1,83 @@
+package dm
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"p2p-park/internal/crypto/sealed"
+	"p2p-park/internal/proto"
+)
+
+func newTestEngine(t *testing.T, name string) (*Engine, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return NewEngine(name, priv, pub), hex.EncodeToString(pub)
+}
+
+func TestComposeOpenRoundTrip(t *testing.T) {
+	alice, aliceID := newTestEngine(t, "alice")
+	bob, bobID := newTestEngine(t, "bob")
+
+	wire, sent, err := alice.Compose(bobID, "hi bob")
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if !sent.Outgoing || sent.PeerUserID != bobID {
+		t.Fatalf("bad outgoing record: %+v", sent)
+	}
+
+	got, err := bob.Open(wire)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got.Text != "hi bob" || got.PeerUserID != aliceID || got.PeerName != "alice" || got.ID != sent.ID {
+		t.Fatalf("bad received record: %+v", got)
+	}
+
+	from, err := VerifyAck(bob.Ack(got.ID))
+	if err != nil || from != bobID {
+		t.Fatalf("VerifyAck = %q, %v; want %q", from, err, bobID)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	alice, _ := newTestEngine(t, "alice")
+	bob, bobID := newTestEngine(t, "bob")
+	carol, _ := newTestEngine(t, "carol")
+
+	wire, _, err := alice.Compose(bobID, "secret")
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+
+	if _, err := carol.Open(wire); !errors.Is(err, ErrNotForUs) {
+		t.Fatalf("wrong recipient: got %v, want ErrNotForUs", err)
+	}
+
+	tampered := wire
+	tampered.Ciphertext = append([]byte(nil), wire.Ciphertext...)
+	tampered.Ciphertext[0] ^= 1
+	if _, err := bob.Open(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered ciphertext: got %v, want ErrBadSignature", err)
+	}
+
+	// Re-signing under another key changes FromPub, which is bound into
+	// the ciphertext's associated data.
+	forged := wire
+	forged.FromPub = carol.pub
+	forged.Signature = ed25519.Sign(carol.priv, proto.EncodeDirectMessageCanonical(forged))
+	if _, err := bob.Open(forged); !errors.Is(err, sealed.ErrDecrypt) {
+		t.Fatalf("re-signed message: got %v, want sealed.ErrDecrypt", err)
+	}
+
+	ack := bob.Ack(wire.ID)
+	ack.ID = "other"
+	if _, err := VerifyAck(ack); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered ack: got %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,25 @@
+package dm
+
+// Store persists direct messages, both received and sent.
+type Store interface {
+	// Close releases underlying resources.
+	Close() error
+
+	// Put stores m if its ID is not already present.
+	// Returns true if it was newly inserted.
+	Put(m Message) (bool, error)
+
+	// Get returns the message with the given id, if stored.
+	Get(id string) (Message, bool, error)
+
+	// Delete removes the message with the given id, if stored.
+	Delete(id string) error
+
+	// MarkDelivered records that the recipient acknowledged message id.
+	// Returns true if the message exists and was not already marked.
+	MarkDelivered(id string, at int64) (bool, error)
+
+	// List returns up to limit messages exchanged with peerUserID, oldest first.
+	// An empty peerUserID lists messages with everyone.
+	List(peerUserID string, limit int) ([]Message, error)
+}
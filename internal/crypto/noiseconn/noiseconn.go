@@ -72,6 +72,7 @@ func (c *SecureConn) Close() error {
 type HandshakeResult struct {
 	Conn          *SecureConn
 	RemotePayload []byte
+	RemoteStatic  []byte // remote's static Noise public key (its network ID)
 }
 
 // NewSecureClient runs a Noise_XX handshake as initiator and attaches localPayload
@@ -133,6 +134,7 @@ func NewSecureClient(
 			writeCS:    cs1, // sending
 		},
 		RemotePayload: nil, // XX here carries payload only from initiator -> responder
+		RemoteStatic:  hs.PeerStatic(),
 	}, nil
 }
 
@@ -194,5 +196,6 @@ func NewSecureServer(
 			writeCS:    cs2, // sending
 		},
 		RemotePayload: remotePayload,
+		RemoteStatic:  hs.PeerStatic(),
 	}, nil
 }
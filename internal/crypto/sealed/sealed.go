@@ -0,0 +1,146 @@
+package sealed
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrBadPublicKey = errors.New("sealed: bad public key")
+	ErrDecrypt      = errors.New("sealed: decryption failed")
+)
+
+const hkdfInfo = "p2p-park sealed v1"
+
+// curveP is the field prime 2^255 - 19 shared by edwards25519 and curve25519.
+var curveP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// X25519PublicFromEd25519 maps an ed25519 public key to its X25519
+// (Montgomery u-coordinate) equivalent: u = (1 + y) / (1 - y) mod p.
+func X25519PublicFromEd25519(pub ed25519.PublicKey) ([]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, ErrBadPublicKey
+	}
+
+	// y is little-endian with the sign of x in the top bit.
+	le := make([]byte, 32)
+	copy(le, pub)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curveP) >= 0 {
+		return nil, ErrBadPublicKey
+	}
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curveP)
+	if den.Sign() == 0 {
+		return nil, ErrBadPublicKey
+	}
+	u := num.Mul(num, den.ModInverse(den, curveP))
+	u.Mod(u, curveP)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverse(out), nil
+}
+
+// X25519PrivateFromEd25519 derives the X25519 scalar matching priv, so that
+// X25519(scalar, basepoint) == X25519PublicFromEd25519(priv.Public()).
+func X25519PrivateFromEd25519(priv ed25519.PrivateKey) []byte {
+	h := sha512.Sum512(priv.Seed())
+	s := make([]byte, 32)
+	copy(s, h[:32])
+	s[0] &= 248
+	s[31] &= 127
+	s[31] |= 64
+	return s
+}
+
+// Seal encrypts plaintext so only the holder of recipient's private key can
+// open it. A fresh ephemeral X25519 key is used per message; aad is
+// authenticated but not encrypted.
+func Seal(recipient ed25519.PublicKey, plaintext, aad []byte) (ephPub, nonce, ciphertext []byte, err error) {
+	rx, err := X25519PublicFromEd25519(recipient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ephPriv := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephPriv); err != nil {
+		return nil, nil, nil, err
+	}
+	ephPub, err = curve25519.X25519(ephPriv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	shared, err := curve25519.X25519(ephPriv, rx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	aead, err := newAEAD(shared, ephPub, rx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	return ephPub, nonce, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a message produced by Seal for priv's public key.
+func Open(priv ed25519.PrivateKey, ephPub, nonce, ciphertext, aad []byte) ([]byte, error) {
+	rx, err := X25519PublicFromEd25519(priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(X25519PrivateFromEd25519(priv), ephPub)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	aead, err := newAEAD(shared, ephPub, rx)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, ErrDecrypt
+	}
+	pt, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// newAEAD derives the message key as HKDF-SHA256(shared, salt = ephPub || recipientX).
+func newAEAD(shared, ephPub, recipientX []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 0, len(ephPub)+len(recipientX))
+	salt = append(salt, ephPub...)
+	salt = append(salt, recipientX...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(hkdfInfo)), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -2,6 +2,7 @@ package dht
 
 import (
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -30,12 +31,22 @@ type DHT struct {
 	ownedMu sync.Mutex
 	owned   map[[32]byte]ownedRec
 
+	providers *providerStore
+	provMu    sync.Mutex
+	providing map[[32]byte]providingRec
+
 	rlMu sync.Mutex
 	rl   map[string]*tokenBucket
 
 	inflightMu sync.Mutex
 	inflight   map[string]int
 
+	liveMu      sync.Mutex
+	unreachable map[NodeID]int // consecutive liveness checks that could not ping the node
+
+	latency LatencyFunc
+	records PeerRecords
+
 	metrics Metrics
 }
 
@@ -68,6 +79,8 @@ func New(selfIDHex string, opts ...Option) (*DHT, error) {
 		rs:        NewMemRecordStore(),
 		pending:   make(map[string]chan proto.DHTWire),
 		owned:     make(map[[32]byte]ownedRec),
+		providers: newProviderStore(),
+		providing: make(map[[32]byte]providingRec),
 		rl:        make(map[string]*tokenBucket),
 		inflight:  make(map[string]int),
 		metrics:   NoopMetrics{},
@@ -76,14 +89,54 @@ func New(selfIDHex string, opts ...Option) (*DHT, error) {
 	for _, opt := range opts {
 		opt(d)
 	}
+	if !SolvesPuzzle(selfNode, d.rt.IDDifficulty()) {
+		return nil, fmt.Errorf("dht: self: %w", ErrIDPuzzle)
+	}
+
+	if ps, ok := d.rs.(OwnedStore); ok {
+		owned, err := ps.LoadOwned()
+		if err != nil {
+			return nil, fmt.Errorf("dht: load owned records: %w", err)
+		}
+		for k, next := range owned {
+			d.owned[k] = ownedRec{nextRepublish: next}
+		}
+	}
 
 	return d, nil
 }
 
+// Close releases the record store if it holds resources, such as a database.
+func (d *DHT) Close() error {
+	if c, ok := d.rs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// setOwned marks key as ours, due for republishing at next.
+func (d *DHT) setOwned(key [32]byte, next time.Time) {
+	d.ownedMu.Lock()
+	d.owned[key] = ownedRec{nextRepublish: next}
+	d.ownedMu.Unlock()
+	if ps, ok := d.rs.(OwnedStore); ok {
+		_ = ps.PutOwned(key, next)
+	}
+}
+
+func (d *DHT) dropOwned(key [32]byte) {
+	d.ownedMu.Lock()
+	delete(d.owned, key)
+	d.ownedMu.Unlock()
+	if ps, ok := d.rs.(OwnedStore); ok {
+		_ = ps.DeleteOwned(key)
+	}
+}
+
 func (d *DHT) Routing() *RoutingTable { return d.rt }
 
 func (d *DHT) ObservePeer(n Sender, peerID, addr, name string) {
-	nodeID, err := NodeIDFromPeerID(peerID)
+	nodeID, err := CheckPeerID(peerID, d.rt.IDDifficulty())
 	if err != nil {
 		return
 	}
@@ -103,7 +156,7 @@ func (d *DHT) ObservePeer(n Sender, peerID, addr, name string) {
 }
 
 func (d *DHT) OnPeerSeen(peerIDHex, addr, name string) {
-	nodeID, err := NodeIDFromPeerID(peerIDHex)
+	nodeID, err := CheckPeerID(peerIDHex, d.rt.IDDifficulty())
 	if err != nil {
 		return
 	}
@@ -131,6 +184,32 @@ func WithMetrics(m Metrics) Option {
 	}
 }
 
+// LatencyFunc reports the measured RTT to a connected peer, if known.
+type LatencyFunc func(peerID string) (time.Duration, bool)
+
+// WithLatencyHint lets lookups prefer low-latency peers among equally useful candidates.
+func WithLatencyHint(fn LatencyFunc) Option {
+	return func(d *DHT) { d.latency = fn }
+}
+
+// fasterPeer reports whether a should be queried before b.
+// Peers with unknown RTT sort after measured ones.
+func (d *DHT) fasterPeer(a, b string) bool {
+	if d.latency == nil {
+		return false
+	}
+	ra, okA := d.latency(a)
+	rb, okB := d.latency(b)
+	switch {
+	case !okA:
+		return false
+	case !okB:
+		return true
+	default:
+		return ra < rb
+	}
+}
+
 func WithDiversityLimit(maxPerSubnet int) Option {
 	return func(d *DHT) {
 		d.rt.SetDiversityLimit(maxPerSubnet)
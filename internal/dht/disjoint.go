@@ -0,0 +1,407 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// DisjointLookup is the outcome of a lookup run over several disjoint
+// paths (S/Kademlia): each path walks towards the target on its own and
+// no node is queried by more than one path, so a Sybil cluster that
+// captures one path cannot steer the others.
+type DisjointLookup struct {
+	Nodes []proto.DHTNode // the K closest nodes across all paths
+	Paths int
+
+	// Agreement is the share of paths, 0 to 1, that reached the answer:
+	// for node lookups, whose own K closest include the closest node
+	// found; for value lookups, that returned the record picked.
+	Agreement float64
+}
+
+const (
+	wkUnqueried = iota
+	wkQuerying
+	wkDone
+	wkFailed
+	wkClaimed // queried, or being queried, by another path
+)
+
+type walkCand struct {
+	node  proto.DHTNode
+	dist  NodeID
+	state int
+}
+
+// walkStep queries one node for a path and returns the nodes it pointed
+// to; stop ends the path, e.g. once it has a value.
+type walkStep func(path int, peerID string) (nodes []proto.DHTNode, stop bool, err error)
+
+// walkDisjoint runs cfg.DisjointPaths lookups for target concurrently. The
+// routing table's closest nodes are dealt out to the paths round-robin,
+// and a node claimed by one path is ignored by the others. It returns
+// each path's candidates.
+func (d *DHT) walkDisjoint(ctx context.Context, n Sender, target NodeID, cfg LookupConfig, step walkStep) ([]map[string]*walkCand, int) {
+	paths := cfg.DisjointPaths
+
+	var claimMu sync.Mutex
+	claimed := map[string]int{} // NodeID hex -> path
+	claim := func(nodeID string, path int) bool {
+		claimMu.Lock()
+		defer claimMu.Unlock()
+		if p, ok := claimed[nodeID]; ok && p != path {
+			return false
+		}
+		claimed[nodeID] = path
+		return true
+	}
+
+	var traceMu sync.Mutex
+	trace := func(h LookupHop) {
+		if cfg.Trace == nil {
+			return
+		}
+		traceMu.Lock()
+		defer traceMu.Unlock()
+		cfg.Trace(h)
+	}
+
+	seeds := make([]map[string]*walkCand, paths)
+	for i := range seeds {
+		seeds[i] = map[string]*walkCand{}
+	}
+	for i, ni := range d.rt.Closest(target, cfg.K) {
+		nd := proto.DHTNode{NodeID: ni.NodeIDHex, PeerID: ni.PeerID, Addr: ni.Addr, Name: ni.Name}
+		seeds[i%paths][nd.NodeID] = &walkCand{node: nd, dist: Distance(ni.NodeID, target)}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		qMu     sync.Mutex
+		queries int
+	)
+	for p := 0; p < paths; p++ {
+		wg.Add(1)
+		go func(path int, seen map[string]*walkCand) {
+			defer wg.Done()
+			q := d.walkPath(ctx, n, target, cfg, path, seen, claim, trace, step)
+			qMu.Lock()
+			queries += q
+			qMu.Unlock()
+		}(p, seeds[p])
+	}
+	wg.Wait()
+	return seeds, queries
+}
+
+func (d *DHT) walkPath(ctx context.Context, n Sender, target NodeID, cfg LookupConfig, path int, seen map[string]*walkCand,
+	claim func(string, int) bool, trace func(LookupHop), step walkStep) (queries int) {
+
+	// sorted returns the candidates this path may still use, closest first.
+	sorted := func() []*walkCand {
+		out := make([]*walkCand, 0, len(seen))
+		for _, c := range seen {
+			if c.state != wkClaimed {
+				out = append(out, c)
+			}
+		}
+		sort.Slice(out, func(i, j int) bool {
+			return DistanceLess(out[i].dist, out[j].dist)
+		})
+		return out
+	}
+
+	for round := 0; round < cfg.MaxRounds; round++ {
+		if ctx.Err() != nil {
+			return queries
+		}
+
+		cands := sorted()
+		limit := len(cands)
+		if limit > cfg.K*2 {
+			limit = cfg.K * 2
+		}
+		toQuery := make([]*walkCand, 0, cfg.Alpha*2)
+		for i := 0; i < limit && len(toQuery) < cfg.Alpha*2; i++ {
+			c := cands[i]
+			if c.state != wkUnqueried {
+				continue
+			}
+			if !claim(c.node.NodeID, path) {
+				c.state = wkClaimed
+				continue
+			}
+			toQuery = append(toQuery, c)
+		}
+		sort.SliceStable(toQuery, func(i, j int) bool {
+			return d.fasterPeer(toQuery[i].node.PeerID, toQuery[j].node.PeerID)
+		})
+		if len(toQuery) > cfg.Alpha {
+			// Claims on the ones we do not query now stay ours.
+			toQuery = toQuery[:cfg.Alpha]
+		}
+		for _, c := range toQuery {
+			c.state = wkQuerying
+		}
+
+		if len(toQuery) == 0 {
+			cands = sorted()
+			if len(cands) > cfg.K {
+				cands = cands[:cfg.K]
+			}
+			left := false
+			for _, c := range cands {
+				if c.state == wkUnqueried {
+					left = true
+					break
+				}
+			}
+			if !left {
+				return queries
+			}
+			continue
+		}
+
+		type result struct {
+			c     *walkCand
+			nodes []proto.DHTNode
+			stop  bool
+			err   error
+			rtt   time.Duration
+		}
+		queries += len(toQuery)
+		resCh := make(chan result, len(toQuery))
+		for _, c := range toQuery {
+			go func(c *walkCand) {
+				sent := time.Now()
+				nodes, stop, err := step(path, c.node.PeerID)
+				resCh <- result{c: c, nodes: nodes, stop: stop, err: err, rtt: time.Since(sent)}
+			}(c)
+		}
+
+		stop := false
+		for i := 0; i < len(toQuery); i++ {
+			r := <-resCh
+			hop := LookupHop{Path: path, Round: round, PeerID: r.c.node.PeerID, Addr: r.c.node.Addr, Distance: r.c.dist, RTT: r.rtt, Err: r.err}
+			if r.err != nil {
+				r.c.state = wkFailed
+				trace(hop)
+				continue
+			}
+			r.c.state = wkDone
+			stop = stop || r.stop
+
+			nodes := r.nodes
+			if len(nodes) > cfg.K*2 {
+				nodes = nodes[:cfg.K*2]
+			}
+			hop.Returned = len(nodes)
+			before := len(seen)
+			for _, nd := range nodes {
+				if !d.acceptNode(nd) {
+					continue
+				}
+				if _, ok := seen[nd.NodeID]; ok {
+					continue
+				}
+				id, err := ParseNodeIDHex(nd.NodeID)
+				if err != nil {
+					continue
+				}
+
+				d.rt.UpsertWithEviction(id, nd.PeerID, nd.Addr, nd.Name, func(tail NodeInfo) bool {
+					resp, err := d.QueryPing(n, tail.PeerID, 800*time.Millisecond)
+					return err == nil && resp.Kind == "PONG"
+				})
+
+				seen[nd.NodeID] = &walkCand{node: nd, dist: Distance(id, target)}
+			}
+			hop.New = len(seen) - before
+			trace(hop)
+		}
+		if stop {
+			return queries
+		}
+
+		// Bound candidate set.
+		if len(seen) > cfg.K*8 {
+			all := make([]*walkCand, 0, len(seen))
+			for _, c := range seen {
+				all = append(all, c)
+			}
+			sort.Slice(all, func(i, j int) bool {
+				return DistanceLess(all[i].dist, all[j].dist)
+			})
+			for _, c := range all[cfg.K*8:] {
+				delete(seen, c.node.NodeID)
+			}
+		}
+	}
+	return queries
+}
+
+// mergeWalks returns the K closest nodes any path heard of that no path
+// failed to reach, and the share of paths whose own K closest include the
+// closest of those.
+func mergeWalks(walks []map[string]*walkCand, k int) ([]proto.DHTNode, float64) {
+	failed := map[string]bool{}
+	for _, seen := range walks {
+		for id, c := range seen {
+			if c.state == wkFailed {
+				failed[id] = true
+			}
+		}
+	}
+
+	closest := func(seen map[string]*walkCand) []*walkCand {
+		out := make([]*walkCand, 0, len(seen))
+		for id, c := range seen {
+			if !failed[id] {
+				out = append(out, c)
+			}
+		}
+		sort.Slice(out, func(i, j int) bool {
+			return DistanceLess(out[i].dist, out[j].dist)
+		})
+		if len(out) > k {
+			out = out[:k]
+		}
+		return out
+	}
+
+	union := map[string]*walkCand{}
+	for _, seen := range walks {
+		for id, c := range seen {
+			union[id] = c
+		}
+	}
+	merged := closest(union)
+	if len(merged) == 0 {
+		return nil, 0
+	}
+
+	nodes := make([]proto.DHTNode, 0, len(merged))
+	for _, c := range merged {
+		nodes = append(nodes, c.node)
+	}
+	// A path captured by a Sybil cluster never hears of the nodes the
+	// honest paths converge on.
+	agree := 0
+	for _, seen := range walks {
+		for _, c := range closest(seen) {
+			if c.node.NodeID == merged[0].node.NodeID {
+				agree++
+				break
+			}
+		}
+	}
+	return nodes, float64(agree) / float64(len(walks))
+}
+
+// DisjointFindNode is IterativeFindNode over cfg.DisjointPaths disjoint
+// paths (at least 2), with a report of how much they agreed.
+func (d *DHT) DisjointFindNode(n Sender, targetHex string, cfg LookupConfig) (DisjointLookup, error) {
+	cfg = cfg.withDefaults()
+	if cfg.DisjointPaths < 2 {
+		cfg.DisjointPaths = 2
+	}
+	target, err := ParseNodeIDHex(targetHex)
+	if err != nil {
+		return DisjointLookup{}, err
+	}
+
+	start := time.Now()
+	d.rt.MarkLookup(target, start)
+	queries := 0
+	ok := false
+	defer func() { d.metrics.ObserveLookup("FIND_NODE", queries, time.Since(start), ok) }()
+
+	walks, queries := d.walkDisjoint(context.Background(), n, target, cfg, func(_ int, pid string) ([]proto.DHTNode, bool, error) {
+		resp, err := d.QueryFindNode(n, pid, targetHex, cfg.RPCTimeout)
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.Kind != "NODES" {
+			return nil, false, fmt.Errorf("unexpected %s reply", resp.Kind)
+		}
+		return resp.Nodes, false, nil
+	})
+
+	nodes, agreement := mergeWalks(walks, cfg.K)
+	ok = true
+	return DisjointLookup{Nodes: nodes, Paths: cfg.DisjointPaths, Agreement: agreement}, nil
+}
+
+// DisjointFindValue is IterativeFindValue over cfg.DisjointPaths disjoint
+// paths (at least 2). Each path stops at the first valid record it gets;
+// the newest of those wins. Quorum and path caching do not apply: the
+// paths already give up to DisjointPaths independent copies.
+func (d *DHT) DisjointFindValue(ctx context.Context, n Sender, key [32]byte, cfg ValueLookupConfig) (*proto.DHTRecord, DisjointLookup, error) {
+	lcfg := LookupConfig{Alpha: cfg.Alpha, K: cfg.K, RPCTimeout: cfg.RPCTimeout, MaxRounds: cfg.MaxRounds, DisjointPaths: cfg.DisjointPaths}.withDefaults()
+	if lcfg.DisjointPaths < 2 {
+		lcfg.DisjointPaths = 2
+	}
+	target := NodeID(key)
+	keyHex := KeyHex(key)
+
+	start := time.Now()
+	d.rt.MarkLookup(target, start)
+	ok := false
+	queries := 0
+	defer func() { d.metrics.ObserveLookup("FIND_VALUE", queries, time.Since(start), ok) }()
+
+	var foundMu sync.Mutex
+	found := make([]*proto.DHTRecord, lcfg.DisjointPaths)
+	walks, queries := d.walkDisjoint(ctx, n, target, lcfg, func(path int, pid string) ([]proto.DHTNode, bool, error) {
+		resp, err := d.QueryFindValue(n, pid, keyHex, lcfg.RPCTimeout)
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.Kind != "VALUE" {
+			return nil, false, fmt.Errorf("unexpected %s reply", resp.Kind)
+		}
+		if resp.Record == nil {
+			return resp.Nodes, false, nil
+		}
+		if err := d.ValidateRecordAgainstKey(key, resp.Record); err != nil {
+			return nil, false, err
+		}
+		// The path stops after this round, keeping the newest copy it got.
+		foundMu.Lock()
+		if found[path] == nil || resp.Record.Seq > found[path].Seq {
+			found[path] = resp.Record
+		}
+		foundMu.Unlock()
+		return resp.Nodes, true, nil
+	})
+
+	nodes, _ := mergeWalks(walks, lcfg.K)
+	res := DisjointLookup{Nodes: nodes, Paths: lcfg.DisjointPaths}
+
+	var best *proto.DHTRecord
+	for _, rec := range found {
+		if rec != nil && (best == nil || rec.Seq > best.Seq) {
+			best = rec
+		}
+	}
+	if best == nil {
+		return nil, res, ctx.Err()
+	}
+	agree := 0
+	for _, rec := range found {
+		if rec != nil && rec.Seq == best.Seq && bytes.Equal(rec.Value, best.Value) {
+			agree++
+		}
+	}
+	res.Agreement = float64(agree) / float64(lcfg.DisjointPaths)
+
+	_ = d.rs.Put(key, best, time.Now())
+	ok = true
+	return best, res, nil
+}
@@ -13,6 +13,11 @@ func (d *DHT) HandleDHT(n Sender, fromPeerID string, fromAddr string, fromName s
 		n.Logf("dht: bad payload from %s: %v", fromPeerID, err)
 		return
 	}
+	if bits := d.rt.IDDifficulty(); bits > 0 {
+		if _, err := CheckPeerID(fromPeerID, bits); err != nil {
+			return
+		}
+	}
 
 	now := time.Now()
 	d.rlMu.Lock()
@@ -31,7 +36,7 @@ func (d *DHT) HandleDHT(n Sender, fromPeerID string, fromAddr string, fromName s
 	d.ObservePeer(n, fromPeerID, fromAddr, fromName)
 
 	// Deliver responses to pending RPC waiters.
-	if w.RPCID != "" && (w.Kind == "NODES" || w.Kind == "PONG" || w.Kind == "VALUE" || w.Kind == "STORE_RESULT") {
+	if w.RPCID != "" && (w.Kind == "NODES" || w.Kind == "PONG" || w.Kind == "VALUE" || w.Kind == "STORE_RESULT" || w.Kind == "PROVIDERS") {
 		d.pendingMu.Lock()
 		ch := d.pending[w.RPCID]
 		if ch != nil {
@@ -64,15 +69,7 @@ func (d *DHT) HandleDHT(n Sender, fromPeerID string, fromAddr string, fromName s
 		}
 
 		closest := d.rt.Closest(target, 20)
-		out := make([]proto.DHTNode, 0, len(closest))
-		for _, ni := range closest {
-			out = append(out, proto.DHTNode{
-				NodeID: ni.NodeIDHex,
-				PeerID: ni.PeerID,
-				Addr:   ni.Addr,
-				Name:   ni.Name,
-			})
-		}
+		out := d.wireNodes(closest)
 
 		reply := proto.DHTWire{Kind: "NODES", RPCID: w.RPCID, Target: w.Target, Nodes: out}
 		_ = n.SendToPeer(fromPeerID, proto.Envelope{
@@ -104,7 +101,25 @@ func (d *DHT) HandleDHT(n Sender, fromPeerID string, fromAddr string, fromName s
 			return
 		}
 
-		err = d.rs.Put(key, w.Record, time.Now())
+		now := time.Now()
+		if IsCached(w.Record) {
+			// A cached copy never displaces one we already hold, and lives
+			// at most DefaultPathCacheTTL whatever the sender asked for.
+			if _, have := d.rs.Get(key, now); have {
+				reply := proto.DHTWire{Kind: "STORE_RESULT", RPCID: w.RPCID, OK: false, Error: ErrCopyHeld.Error()}
+				_ = n.SendToPeer(fromPeerID, proto.Envelope{
+					Type:    proto.MsgDHT,
+					FromID:  n.ID(),
+					Payload: proto.MustMarshal(reply),
+				})
+				return
+			}
+			if limit := now.Add(DefaultPathCacheTTL).Unix(); w.Record.CachedUntilUnix > limit {
+				w.Record.CachedUntilUnix = limit
+			}
+		}
+
+		err = d.rs.Put(key, w.Record, now)
 		ok := err == nil
 		errMsg := ""
 		if err != nil {
@@ -124,32 +139,46 @@ func (d *DHT) HandleDHT(n Sender, fromPeerID string, fromAddr string, fromName s
 			return
 		}
 
-		if rec, ok := d.rs.Get(key, time.Now()); ok {
-			if d.ValidateRecordAgainstKey(key, rec) == nil {
-				reply := proto.DHTWire{Kind: "VALUE", RPCID: w.RPCID, Key: w.Key, Record: rec}
-				_ = n.SendToPeer(fromPeerID, proto.Envelope{
-					Type:    proto.MsgDHT,
-					FromID:  n.ID(),
-					Payload: proto.MustMarshal(reply),
-				})
-				return
-			}
-		}
-
-		// Not found => return closest nodes (Kademlia behavior)
+		// Closest nodes go along even with a value, so quorum reads can
+		// carry on towards more replicas.
 		target, _ := ParseNodeIDHex(w.Key)
 		closest := d.rt.Closest(target, 20)
-		out := make([]proto.DHTNode, 0, len(closest))
-		for _, ni := range closest {
-			out = append(out, proto.DHTNode{
-				NodeID: ni.NodeIDHex,
-				PeerID: ni.PeerID,
-				Addr:   ni.Addr,
-				Name:   ni.Name,
-			})
-		}
+		out := d.wireNodes(closest)
 
 		reply := proto.DHTWire{Kind: "VALUE", RPCID: w.RPCID, Key: w.Key, Nodes: out}
+		if rec, ok := d.rs.Get(key, time.Now()); ok && d.ValidateRecordAgainstKey(key, rec) == nil {
+			reply.Record = rec
+		}
+		_ = n.SendToPeer(fromPeerID, proto.Envelope{
+			Type:    proto.MsgDHT,
+			FromID:  n.ID(),
+			Payload: proto.MustMarshal(reply),
+		})
+
+	case "ADD_PROVIDER":
+		reply := proto.DHTWire{Kind: "STORE_RESULT", RPCID: w.RPCID, OK: true}
+		if err := d.handleAddProvider(fromPeerID, w); err != nil {
+			reply.OK = false
+			reply.Error = err.Error()
+		}
+		_ = n.SendToPeer(fromPeerID, proto.Envelope{
+			Type:    proto.MsgDHT,
+			FromID:  n.ID(),
+			Payload: proto.MustMarshal(reply),
+		})
+
+	case "GET_PROVIDERS":
+		key, err := ParseKeyHex(w.Key)
+		if err != nil {
+			return
+		}
+
+		// Providers we know of, plus closer nodes so the lookup can go on.
+		provs := d.providerNodes(key, time.Now())
+		closest := d.rt.Closest(NodeID(key), 20)
+		out := d.wireNodes(closest)
+
+		reply := proto.DHTWire{Kind: "PROVIDERS", RPCID: w.RPCID, Key: w.Key, Providers: provs, Nodes: out}
 		_ = n.SendToPeer(fromPeerID, proto.Envelope{
 			Type:    proto.MsgDHT,
 			FromID:  n.ID(),
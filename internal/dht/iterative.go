@@ -1,7 +1,7 @@
 package dht
 
 import (
-	"net"
+	"fmt"
 	"sort"
 	"time"
 
@@ -13,6 +13,25 @@ type LookupConfig struct {
 	K          int
 	RPCTimeout time.Duration
 	MaxRounds  int
+
+	// DisjointPaths, if above 1, splits the lookup into that many paths
+	// that never query the same node; see DisjointFindNode.
+	DisjointPaths int
+
+	Trace func(LookupHop) // if set, called after every query the lookup makes
+}
+
+// LookupHop describes one FIND_NODE query made during a lookup.
+type LookupHop struct {
+	Path     int // disjoint path that made the query; 0 for single-path lookups
+	Round    int
+	PeerID   string
+	Addr     string
+	Distance NodeID        // queried node's XOR distance to the target
+	RTT      time.Duration // time to the reply or failure
+	Returned int           // nodes in the reply
+	New      int           // of those, nodes the lookup had not seen
+	Err      error         // nil if the node answered
 }
 
 func DefaultLookupConfig() LookupConfig {
@@ -24,8 +43,7 @@ func DefaultLookupConfig() LookupConfig {
 	}
 }
 
-// IterativeFindNode performs a Kademlia-style iterative lookup.
-func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([]proto.DHTNode, error) {
+func (cfg LookupConfig) withDefaults() LookupConfig {
 	if cfg.Alpha <= 0 {
 		cfg.Alpha = 3
 	}
@@ -38,6 +56,16 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 	if cfg.MaxRounds <= 0 {
 		cfg.MaxRounds = 32
 	}
+	return cfg
+}
+
+// IterativeFindNode performs a Kademlia-style iterative lookup.
+func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([]proto.DHTNode, error) {
+	cfg = cfg.withDefaults()
+	if cfg.DisjointPaths > 1 {
+		res, err := d.DisjointFindNode(n, targetHex, cfg)
+		return res.Nodes, err
+	}
 
 	target, err := ParseNodeIDHex(targetHex)
 	if err != nil {
@@ -45,6 +73,7 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 	}
 
 	start := time.Now()
+	d.rt.MarkLookup(target, start)
 	queries := 0
 	ok := false
 	defer func() { d.metrics.ObserveLookup("FIND_NODE", queries, time.Since(start), ok) }()
@@ -98,13 +127,22 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 		if limit > cfg.K*2 {
 			limit = cfg.K * 2
 		}
-		toQuery := make([]*cand, 0, cfg.Alpha)
-		for i := 0; i < limit && len(toQuery) < cfg.Alpha; i++ {
+		// Among the 2*alpha closest unqueried, prefer low-latency peers.
+		toQuery := make([]*cand, 0, cfg.Alpha*2)
+		for i := 0; i < limit && len(toQuery) < cfg.Alpha*2; i++ {
 			if cands[i].state == stUnqueried {
-				cands[i].state = stQuerying
 				toQuery = append(toQuery, cands[i])
 			}
 		}
+		sort.SliceStable(toQuery, func(i, j int) bool {
+			return d.fasterPeer(toQuery[i].node.PeerID, toQuery[j].node.PeerID)
+		})
+		if len(toQuery) > cfg.Alpha {
+			toQuery = toQuery[:cfg.Alpha]
+		}
+		for _, c := range toQuery {
+			c.state = stQuerying
+		}
 
 		if len(toQuery) == 0 {
 			limit2 := len(cands)
@@ -128,6 +166,7 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 			c   *cand
 			w   proto.DHTWire
 			err error
+			rtt time.Duration
 		}
 		queries += len(toQuery)
 		resCh := make(chan result, len(toQuery))
@@ -135,15 +174,24 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 		for _, c := range toQuery {
 			peerID := c.node.PeerID
 			go func(c *cand, pid string) {
+				sent := time.Now()
 				resp, err := d.QueryFindNode(n, pid, targetHex, cfg.RPCTimeout)
-				resCh <- result{c: c, w: resp, err: err}
+				resCh <- result{c: c, w: resp, err: err, rtt: time.Since(sent)}
 			}(c, peerID)
 		}
 
 		for i := 0; i < len(toQuery); i++ {
 			r := <-resCh
+			hop := LookupHop{Round: round, PeerID: r.c.node.PeerID, Addr: r.c.node.Addr, Distance: r.c.dist, RTT: r.rtt}
 			if r.err != nil || r.w.Kind != "NODES" {
 				r.c.state = stFailed
+				if cfg.Trace != nil {
+					hop.Err = r.err
+					if hop.Err == nil {
+						hop.Err = fmt.Errorf("unexpected %s reply", r.w.Kind)
+					}
+					cfg.Trace(hop)
+				}
 				continue
 			}
 			r.c.state = stDone
@@ -153,15 +201,11 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 			if len(nodes) > cfg.K*2 {
 				nodes = nodes[:cfg.K*2]
 			}
+			hop.Returned = len(nodes)
+			before := len(seen)
 
 			for _, nd := range nodes {
-				if nd.NodeID == "" || nd.PeerID == "" || nd.Addr == "" {
-					continue
-				}
-				if !NodeIDMatchesPeerID(nd.NodeID, nd.PeerID) {
-					continue
-				}
-				if _, _, err := net.SplitHostPort(nd.Addr); err != nil {
+				if !d.acceptNode(nd) {
 					continue
 				}
 				if _, ok := seen[nd.NodeID]; ok {
@@ -180,6 +224,10 @@ func (d *DHT) IterativeFindNode(n Sender, targetHex string, cfg LookupConfig) ([
 
 				seen[nd.NodeID] = &cand{node: nd, id: id, dist: Distance(id, target), state: stUnqueried}
 			}
+			if cfg.Trace != nil {
+				hop.New = len(seen) - before
+				cfg.Trace(hop)
+			}
 		}
 
 		// Bound candidate set.
@@ -0,0 +1,234 @@
+package dht
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+type ProviderLookupConfig struct {
+	Alpha      int
+	K          int
+	RPCTimeout time.Duration
+	MaxRounds  int
+	Count      int // stop once this many distinct providers are known
+}
+
+func DefaultProviderLookupConfig() ProviderLookupConfig {
+	return ProviderLookupConfig{
+		Alpha:      3,
+		K:          20,
+		RPCTimeout: 1200 * time.Millisecond,
+		MaxRounds:  32,
+		Count:      20,
+	}
+}
+
+// IterativeFindProviders walks towards key with GET_PROVIDERS, collecting
+// providers from every node that answers, until cfg.Count are known or no
+// closer node is left to ask. Providers we hold ourselves count too.
+func (d *DHT) IterativeFindProviders(ctx context.Context, n Sender, key [32]byte, cfg ProviderLookupConfig) ([]proto.DHTNode, error) {
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = 3
+	}
+	if cfg.K <= 0 {
+		cfg.K = 20
+	}
+	if cfg.RPCTimeout <= 0 {
+		cfg.RPCTimeout = 1200 * time.Millisecond
+	}
+	if cfg.MaxRounds <= 0 {
+		cfg.MaxRounds = 32
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = 20
+	}
+
+	target := NodeID(key)
+	keyHex := KeyHex(key)
+
+	found := make([]proto.DHTNode, 0, cfg.Count)
+	have := map[string]bool{} // provider PeerIDs already in found
+	addProvider := func(nd proto.DHTNode) {
+		if len(found) >= cfg.Count || have[nd.PeerID] {
+			return
+		}
+		have[nd.PeerID] = true
+		found = append(found, nd)
+	}
+	for _, nd := range d.providers.get(key, time.Now(), cfg.Count) {
+		addProvider(nd)
+	}
+	if len(found) >= cfg.Count {
+		return found, nil
+	}
+
+	start := time.Now()
+	d.rt.MarkLookup(target, start)
+	queries := 0
+	ok := false
+	defer func() { d.metrics.ObserveLookup("GET_PROVIDERS", queries, time.Since(start), ok) }()
+
+	const (
+		stUnqueried = iota
+		stQuerying
+		stDone
+		stFailed
+	)
+
+	type cand struct {
+		node  proto.DHTNode
+		id    NodeID
+		dist  NodeID
+		state int
+	}
+
+	seen := map[string]*cand{} // keyed by NodeID hex
+
+	seed := d.rt.Closest(target, cfg.K)
+	for _, ni := range seed {
+		c := &cand{
+			node:  proto.DHTNode{NodeID: ni.NodeIDHex, PeerID: ni.PeerID, Addr: ni.Addr, Name: ni.Name},
+			id:    ni.NodeID,
+			dist:  Distance(ni.NodeID, target),
+			state: stUnqueried,
+		}
+		seen[c.node.NodeID] = c
+	}
+
+	sorted := func() []*cand {
+		out := make([]*cand, 0, len(seen))
+		for _, c := range seen {
+			out = append(out, c)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			return DistanceLess(out[i].dist, out[j].dist)
+		})
+		return out
+	}
+
+	for round := 0; round < cfg.MaxRounds && len(found) < cfg.Count; round++ {
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+
+		cands := sorted()
+		if len(cands) == 0 {
+			break
+		}
+
+		limit := len(cands)
+		if limit > cfg.K*2 {
+			limit = cfg.K * 2
+		}
+		// Among the 2*alpha closest unqueried, prefer low-latency peers.
+		toQuery := make([]*cand, 0, cfg.Alpha*2)
+		for i := 0; i < limit && len(toQuery) < cfg.Alpha*2; i++ {
+			if cands[i].state == stUnqueried {
+				toQuery = append(toQuery, cands[i])
+			}
+		}
+		sort.SliceStable(toQuery, func(i, j int) bool {
+			return d.fasterPeer(toQuery[i].node.PeerID, toQuery[j].node.PeerID)
+		})
+		if len(toQuery) > cfg.Alpha {
+			toQuery = toQuery[:cfg.Alpha]
+		}
+		for _, c := range toQuery {
+			c.state = stQuerying
+		}
+
+		if len(toQuery) == 0 {
+			limit2 := len(cands)
+			if limit2 > cfg.K {
+				limit2 = cfg.K
+			}
+			left := false
+			for i := 0; i < limit2; i++ {
+				if cands[i].state == stUnqueried {
+					left = true
+					break
+				}
+			}
+			if !left {
+				break
+			}
+			continue
+		}
+
+		type result struct {
+			c   *cand
+			w   proto.DHTWire
+			err error
+		}
+		queries += len(toQuery)
+		resCh := make(chan result, len(toQuery))
+
+		for _, c := range toQuery {
+			peerID := c.node.PeerID
+			go func(c *cand, pid string) {
+				resp, err := d.QueryGetProviders(n, pid, keyHex, cfg.RPCTimeout)
+				resCh <- result{c: c, w: resp, err: err}
+			}(c, peerID)
+		}
+
+		for i := 0; i < len(toQuery); i++ {
+			r := <-resCh
+			if r.err != nil || r.w.Kind != "PROVIDERS" {
+				r.c.state = stFailed
+				continue
+			}
+			r.c.state = stDone
+
+			// Providers are held to the same standard as routing entries.
+			provs := r.w.Providers
+			if len(provs) > maxProvidersReturned {
+				provs = provs[:maxProvidersReturned]
+			}
+			for _, nd := range provs {
+				if d.acceptNode(nd) {
+					addProvider(nd)
+				}
+			}
+
+			nodes := r.w.Nodes
+			if len(nodes) > cfg.K*2 {
+				nodes = nodes[:cfg.K*2]
+			}
+			for _, nd := range nodes {
+				if !d.acceptNode(nd) {
+					continue
+				}
+				if _, ok := seen[nd.NodeID]; ok {
+					continue
+				}
+				id, err := ParseNodeIDHex(nd.NodeID)
+				if err != nil {
+					continue
+				}
+
+				d.rt.UpsertWithEviction(id, nd.PeerID, nd.Addr, nd.Name, func(tail NodeInfo) bool {
+					resp, err := d.QueryPing(n, tail.PeerID, 800*time.Millisecond)
+					return err == nil && resp.Kind == "PONG"
+				})
+
+				seen[nd.NodeID] = &cand{node: nd, id: id, dist: Distance(id, target), state: stUnqueried}
+			}
+		}
+
+		cands = sorted()
+		if len(cands) > cfg.K*8 {
+			cands = cands[:cfg.K*8]
+			keep := map[string]*cand{}
+			for _, c := range cands {
+				keep[c.node.NodeID] = c
+			}
+			seen = keep
+		}
+	}
+
+	ok = true
+	return found, nil
+}
@@ -2,7 +2,6 @@ package dht
 
 import (
 	"context"
-	"net"
 	"sort"
 	"time"
 
@@ -14,6 +13,20 @@ type ValueLookupConfig struct {
 	K          int
 	RPCTimeout time.Duration
 	MaxRounds  int
+
+	// Quorum, if above 1, keeps a mutable lookup going until that many
+	// valid copies are in (our own counts), returns the highest Seq among
+	// them and read-repairs the nodes that had an older copy or none.
+	Quorum int
+
+	// CacheTTL is the base lifetime of the copy a successful lookup leaves
+	// on the closest node that lacked it (see DefaultPathCacheTTL). 0 uses
+	// the default; negative turns path caching off.
+	CacheTTL time.Duration
+
+	// DisjointPaths, if above 1, runs the lookup over that many disjoint
+	// paths; see DisjointFindValue.
+	DisjointPaths int
 }
 
 func DefaultValueLookupConfig() ValueLookupConfig {
@@ -22,13 +35,14 @@ func DefaultValueLookupConfig() ValueLookupConfig {
 		K:          20,
 		RPCTimeout: 1200 * time.Millisecond,
 		MaxRounds:  32,
+		CacheTTL:   DefaultPathCacheTTL,
 	}
 }
 
 func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cfg ValueLookupConfig) (*proto.DHTRecord, bool, error) {
-	if rec, ok := d.rs.Get(key, time.Now()); ok {
-		ok = true
-		return rec, true, nil
+	local, haveLocal := d.rs.Get(key, time.Now())
+	if haveLocal && (cfg.Quorum <= 1 || local.Type != RecordMutable) {
+		return local, true, nil
 	}
 
 	if cfg.Alpha <= 0 {
@@ -43,11 +57,19 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 	if cfg.MaxRounds <= 0 {
 		cfg.MaxRounds = 32
 	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultPathCacheTTL
+	}
+	if cfg.DisjointPaths > 1 {
+		rec, _, err := d.DisjointFindValue(ctx, n, key, cfg)
+		return rec, rec != nil, err
+	}
 
 	target := NodeID(key)
 	keyHex := KeyHex(key)
 
 	start := time.Now()
+	d.rt.MarkLookup(target, start)
 	queries := 0
 	ok := false
 	defer func() { d.metrics.ObserveLookup("FIND_VALUE", queries, time.Since(start), ok) }()
@@ -64,6 +86,14 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 		id    NodeID
 		dist  NodeID
 		state int
+		rec   *proto.DHTRecord // the valid copy it returned, in quorum mode
+	}
+
+	// Quorum state: the newest copy so far and how many valid copies we hold.
+	var best *proto.DHTRecord
+	copies := 0
+	if haveLocal {
+		best, copies = local, 1
 	}
 
 	seen := map[string]*cand{} // keyed by NodeID hex
@@ -90,27 +120,38 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 		return out
 	}
 
-	for round := 0; round < cfg.MaxRounds; round++ {
+	var lookupErr error
+	for round := 0; round < cfg.MaxRounds && (cfg.Quorum <= 1 || copies < cfg.Quorum); round++ {
 		if err := ctx.Err(); err != nil {
-			return nil, false, err
+			lookupErr = err
+			break
 		}
 
 		cands := sorted()
 		if len(cands) == 0 {
-			return nil, false, nil
+			break
 		}
 
 		limit := len(cands)
 		if limit > cfg.K*2 {
 			limit = cfg.K * 2
 		}
-		toQuery := make([]*cand, 0, cfg.Alpha)
-		for i := 0; i < limit && len(toQuery) < cfg.Alpha; i++ {
+		// Among the 2*alpha closest unqueried, prefer low-latency peers.
+		toQuery := make([]*cand, 0, cfg.Alpha*2)
+		for i := 0; i < limit && len(toQuery) < cfg.Alpha*2; i++ {
 			if cands[i].state == stUnqueried {
-				cands[i].state = stQuerying
 				toQuery = append(toQuery, cands[i])
 			}
 		}
+		sort.SliceStable(toQuery, func(i, j int) bool {
+			return d.fasterPeer(toQuery[i].node.PeerID, toQuery[j].node.PeerID)
+		})
+		if len(toQuery) > cfg.Alpha {
+			toQuery = toQuery[:cfg.Alpha]
+		}
+		for _, c := range toQuery {
+			c.state = stQuerying
+		}
 
 		if len(toQuery) == 0 {
 			limit2 := len(cands)
@@ -125,7 +166,7 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 				}
 			}
 			if !left {
-				return nil, false, nil
+				break
 			}
 			continue
 		}
@@ -155,13 +196,33 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 			r.c.state = stDone
 
 			if r.w.Record != nil {
-				if err := d.ValidateRecordAgainstKey(key, r.w.Record); err == nil {
+				if err := d.ValidateRecordAgainstKey(key, r.w.Record); err != nil {
+					r.c.state = stFailed
+					continue
+				}
+				if cfg.Quorum <= 1 || r.w.Record.Type != RecordMutable {
 					_ = d.rs.Put(key, r.w.Record, time.Now())
+					if cfg.CacheTTL > 0 {
+						// Every node that answered before this one lacked
+						// the value; cache it on the closest of them other
+						// than ourselves.
+						closer := 0
+						for _, c := range sorted() {
+							if c != r.c && c.state == stDone && c.node.PeerID != n.ID() {
+								d.cacheOnPath(n, key, r.w.Record, c.node, pathCacheTTL(cfg.CacheTTL, closer), cfg.RPCTimeout)
+								break
+							}
+							closer++
+						}
+					}
 					ok = true
 					return r.w.Record, true, nil
 				}
-				r.c.state = stFailed
-				continue
+				r.c.rec = r.w.Record
+				copies++
+				if best == nil || r.w.Record.Seq > best.Seq {
+					best = r.w.Record
+				}
 			}
 
 			nodes := r.w.Nodes
@@ -169,13 +230,7 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 				nodes = nodes[:cfg.K*2]
 			}
 			for _, nd := range nodes {
-				if nd.NodeID == "" || nd.PeerID == "" || nd.Addr == "" {
-					continue
-				}
-				if !NodeIDMatchesPeerID(nd.NodeID, nd.PeerID) {
-					continue
-				}
-				if _, _, err := net.SplitHostPort(nd.Addr); err != nil {
+				if !d.acceptNode(nd) {
 					continue
 				}
 				if _, ok := seen[nd.NodeID]; ok {
@@ -206,5 +261,45 @@ func (d *DHT) IterativeFindValue(ctx context.Context, n Sender, key [32]byte, cf
 		}
 	}
 
-	return nil, false, nil
+	if best == nil {
+		return nil, false, lookupErr
+	}
+
+	// Among the closest nodes that answered, those without the newest copy
+	// get it now.
+	var stale []proto.DHTNode
+	answered := 0
+	for _, c := range sorted() {
+		if c.state != stDone {
+			continue
+		}
+		if answered++; answered > cfg.K {
+			break
+		}
+		if c.rec == nil || c.rec.Seq < best.Seq {
+			stale = append(stale, c.node)
+		}
+	}
+	_ = d.rs.Put(key, best, time.Now())
+	d.readRepair(n, key, best, stale, cfg.RPCTimeout)
+
+	ok = true
+	return best, true, nil
+}
+
+// readRepair stores rec on nodes that returned an older copy or none. It
+// runs in the background; the lookup already has its answer.
+func (d *DHT) readRepair(n Sender, key [32]byte, rec *proto.DHTRecord, nodes []proto.DHTNode, timeout time.Duration) {
+	if len(nodes) == 0 {
+		return
+	}
+	// best may be a path-cached copy; the repair is a replica, which nodes
+	// holding an older copy take in its place.
+	repair := *rec
+	repair.CachedUntilUnix = 0
+	go func() {
+		for _, nd := range nodes {
+			_, _ = d.QueryStore(n, nd.PeerID, KeyHex(key), &repair, timeout)
+		}
+	}()
 }
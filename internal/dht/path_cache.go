@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"errors"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// DefaultPathCacheTTL is how long a path-cached copy lives on a node with
+// no known node between it and the key. Each node in between halves it, so
+// copies far from the key, which few lookups pass, go away quickly.
+const DefaultPathCacheTTL = time.Hour
+
+const minPathCacheTTL = time.Minute
+
+var ErrCachedCopy = errors.New("dht: record is a cached copy")
+
+// RecordExpiry returns the unix time after which a store stops serving
+// rec: its ExpiresUnix, or its CachedUntilUnix if that comes first. 0
+// means never.
+func RecordExpiry(rec *proto.DHTRecord) int64 {
+	exp := rec.ExpiresUnix
+	if c := rec.CachedUntilUnix; c != 0 && (exp == 0 || c < exp) {
+		exp = c
+	}
+	return exp
+}
+
+// IsCached reports whether rec is a path-cached copy.
+func IsCached(rec *proto.DHTRecord) bool {
+	return rec != nil && rec.CachedUntilUnix != 0
+}
+
+// pathCacheTTL is base halved once for each of the closer nodes, floored
+// at minPathCacheTTL.
+func pathCacheTTL(base time.Duration, closer int) time.Duration {
+	if closer > 30 {
+		closer = 30
+	}
+	ttl := base >> closer
+	if ttl < minPathCacheTTL {
+		ttl = minPathCacheTTL
+	}
+	return ttl
+}
+
+// cacheOnPath stores a cached copy of rec at nd in the background.
+func (d *DHT) cacheOnPath(n Sender, key [32]byte, rec *proto.DHTRecord, nd proto.DHTNode, ttl, timeout time.Duration) {
+	cp := *rec
+	until := time.Now().Add(ttl).Unix()
+	if cp.CachedUntilUnix == 0 || until < cp.CachedUntilUnix {
+		// A copy of a cached copy lives no longer than its source.
+		cp.CachedUntilUnix = until
+	}
+	go func() {
+		_, _ = d.QueryStore(n, nd.PeerID, KeyHex(key), &cp, timeout)
+	}()
+}
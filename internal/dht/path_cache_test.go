@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestPathCacheTTL_HalvesPerCloserNode(t *testing.T) {
+	cases := []struct {
+		closer int
+		want   time.Duration
+	}{
+		{0, time.Hour},
+		{1, 30 * time.Minute},
+		{3, 450 * time.Second},
+		{10, minPathCacheTTL},
+		{100, minPathCacheTTL},
+	}
+	for _, c := range cases {
+		if got := pathCacheTTL(time.Hour, c.closer); got != c.want {
+			t.Errorf("pathCacheTTL(1h, %d) = %v; want %v", c.closer, got, c.want)
+		}
+	}
+}
+
+func TestHandler_CachedStoreNeverDisplacesHeldCopy(t *testing.T) {
+	selfPeerID := randID(t).Hex()
+	h, err := New(selfPeerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeSender{selfID: selfPeerID}
+	from := randID(t).Hex()
+
+	value := []byte("popular")
+	key := KeyFromImmutable(value)
+	store := func(rec *proto.DHTRecord, wantOK bool) {
+		t.Helper()
+		env := proto.Envelope{Type: proto.MsgDHT, FromID: from, Payload: proto.MustMarshal(proto.DHTWire{
+			Kind: "STORE", RPCID: "rpc", Key: KeyHex(key), Record: rec,
+		})}
+		h.HandleDHT(n, from, "127.0.0.1:9999", "", env)
+		var got proto.DHTWire
+		if err := json.Unmarshal(n.sentEnv.Payload, &got); err != nil || got.OK != wantOK {
+			t.Fatalf("STORE_RESULT = %+v, %v; want OK %v", got, err, wantOK)
+		}
+	}
+
+	// A cached copy asking for a day is held to DefaultPathCacheTTL.
+	store(&proto.DHTRecord{Type: RecordImmutable, Value: value, CachedUntilUnix: time.Now().Add(24 * time.Hour).Unix()}, true)
+	rec, ok := h.rs.Get(key, time.Now())
+	if !ok || !IsCached(rec) || rec.CachedUntilUnix > time.Now().Add(DefaultPathCacheTTL).Unix() {
+		t.Fatalf("cached copy = %+v, %v", rec, ok)
+	}
+
+	// A real replica replaces the cached copy, and later cached STOREs
+	// leave it alone and say so.
+	store(&proto.DHTRecord{Type: RecordImmutable, Value: value}, true)
+	store(&proto.DHTRecord{Type: RecordImmutable, Value: value, CachedUntilUnix: time.Now().Add(time.Minute).Unix()}, false)
+	if rec, ok := h.rs.Get(key, time.Now()); !ok || IsCached(rec) {
+		t.Fatalf("held replica was displaced by a cached copy: %+v", rec)
+	}
+}
+
+func TestRepublishOwned_DropsCachedCopies(t *testing.T) {
+	selfPeerID := randID(t).Hex()
+	d, err := New(selfPeerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("v")
+	key := KeyFromImmutable(value)
+	_ = d.rs.Put(key, &proto.DHTRecord{Type: RecordImmutable, Value: value, CachedUntilUnix: time.Now().Add(time.Hour).Unix()}, time.Now())
+	d.setOwned(key, time.Time{})
+
+	n := &fakeSender{selfID: selfPeerID}
+	d.republishOwned(t.Context(), n)
+	if n.sentTo != "" {
+		t.Fatalf("republished a cached copy to %s", n.sentTo)
+	}
+	d.ownedMu.Lock()
+	_, owned := d.owned[key]
+	d.ownedMu.Unlock()
+	if owned {
+		t.Fatalf("cached copy still in the owned set")
+	}
+}
+
+func TestMemRecordStore_ReplicaReplacesCachedCopyOfSameSeq(t *testing.T) {
+	s := NewMemRecordStore()
+	now := time.Now()
+	key := [32]byte{1}
+	cached := &proto.DHTRecord{Type: RecordMutable, Value: []byte("v"), Seq: 3, CachedUntilUnix: now.Add(time.Hour).Unix()}
+	replica := &proto.DHTRecord{Type: RecordMutable, Value: []byte("v"), Seq: 3}
+
+	if err := s.Put(key, cached, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(key, cached, now); !errors.Is(err, ErrSeqTooLow) {
+		t.Fatalf("cached over cached of the same seq: err = %v", err)
+	}
+	if err := s.Put(key, replica, now); err != nil {
+		t.Fatalf("replica over a cached copy of the same seq: %v", err)
+	}
+	if rec, ok := s.Get(key, now); !ok || IsCached(rec) {
+		t.Fatalf("held %+v; want the replica", rec)
+	}
+	if err := s.Put(key, cached, now); !errors.Is(err, ErrSeqTooLow) {
+		t.Fatalf("cached copy over a replica of the same seq: err = %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package dht
+
+import (
+	"net"
+	"slices"
+
+	"p2p-park/internal/proto"
+)
+
+// PeerRecords supplies and checks the signed address records that travel
+// with nodes in NODES replies. Without one the DHT trusts addresses as
+// given, which is only fit for simulations.
+type PeerRecords interface {
+	// Lookup returns the record we hold for peerID, or nil.
+	Lookup(peerID string) *proto.PeerRecord
+	// Accept verifies rec and remembers it. An error means its addresses
+	// must not be stored or dialed.
+	Accept(rec *proto.PeerRecord) error
+}
+
+// WithPeerRecords makes the DHT attach records to the nodes it returns and
+// drop returned nodes that lack a valid one.
+func WithPeerRecords(pr PeerRecords) Option {
+	return func(d *DHT) { d.records = pr }
+}
+
+// wireNodes converts routing table entries for a NODES reply. With peer
+// records on, nodes we hold no record for are left out.
+func (d *DHT) wireNodes(nodes []NodeInfo) []proto.DHTNode {
+	out := make([]proto.DHTNode, 0, len(nodes))
+	for _, ni := range nodes {
+		nd := proto.DHTNode{NodeID: ni.NodeIDHex, PeerID: ni.PeerID, Addr: ni.Addr, Name: ni.Name}
+		if d.records != nil {
+			if nd.Record = d.records.Lookup(ni.PeerID); nd.Record == nil {
+				continue
+			}
+		}
+		out = append(out, nd)
+	}
+	return out
+}
+
+// acceptNode reports whether a node from a NODES reply may enter the
+// routing table: well-formed, and with peer records on, vouched for by a
+// valid record of its own that lists the address.
+func (d *DHT) acceptNode(nd proto.DHTNode) bool {
+	if nd.NodeID == "" || nd.PeerID == "" || nd.Addr == "" {
+		return false
+	}
+	if !NodeIDMatchesPeerID(nd.NodeID, nd.PeerID) {
+		return false
+	}
+	if _, err := CheckPeerID(nd.PeerID, d.rt.IDDifficulty()); err != nil {
+		return false
+	}
+	if _, _, err := net.SplitHostPort(nd.Addr); err != nil {
+		return false
+	}
+	if d.records == nil {
+		return true
+	}
+	rec := nd.Record
+	if rec == nil || rec.NetworkID != nd.PeerID || !slices.Contains(rec.Addrs, nd.Addr) {
+		return false
+	}
+	return d.records.Accept(rec) == nil
+}
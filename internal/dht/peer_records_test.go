@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"p2p-park/internal/proto"
+)
+
+type fakeRecords struct {
+	held     map[string]*proto.PeerRecord
+	rejected map[string]bool // network IDs whose records fail verification
+}
+
+func (f *fakeRecords) Lookup(peerID string) *proto.PeerRecord { return f.held[peerID] }
+
+func (f *fakeRecords) Accept(rec *proto.PeerRecord) error {
+	if f.rejected[rec.NetworkID] {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+func TestPeerRecords_FindNodeRepliesCarryRecords(t *testing.T) {
+	self := MustParseNodeIDHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f").Hex()
+	signed := MustParseNodeIDHex("1111111111111111111111111111111111111111111111111111111111111111").Hex()
+	unsigned := MustParseNodeIDHex("2222222222222222222222222222222222222222222222222222222222222222").Hex()
+	rec := &proto.PeerRecord{NetworkID: signed, Addrs: []string{"10.0.0.1:1001"}}
+
+	h, err := New(self, WithPeerRecords(&fakeRecords{held: map[string]*proto.PeerRecord{signed: rec}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []string{signed, unsigned} {
+		id, _ := NodeIDFromPeerID(pid)
+		h.rt.Upsert(id, pid, "10.0.0.1:1001", "")
+	}
+
+	n := &fakeSender{selfID: self}
+	from := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	req := proto.DHTWire{Kind: "FIND_NODE", RPCID: "rpc-1", Target: signed}
+	h.HandleDHT(n, from, "127.0.0.1:9999", "", proto.Envelope{Type: proto.MsgDHT, FromID: from, Payload: proto.MustMarshal(req)})
+
+	var got proto.DHTWire
+	if err := json.Unmarshal(n.sentEnv.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != 1 || got.Nodes[0].PeerID != signed || got.Nodes[0].Record == nil {
+		t.Fatalf("nodes = %+v; want only %s with its record", got.Nodes, signed)
+	}
+}
+
+func TestPeerRecords_AcceptNodeNeedsMatchingRecord(t *testing.T) {
+	self := MustParseNodeIDHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f").Hex()
+	pid := MustParseNodeIDHex("1111111111111111111111111111111111111111111111111111111111111111").Hex()
+	nid, _ := NodeIDFromPeerID(pid)
+	fr := &fakeRecords{rejected: map[string]bool{}}
+	h, err := New(self, WithPeerRecords(fr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := func(rec *proto.PeerRecord) proto.DHTNode {
+		return proto.DHTNode{NodeID: nid.Hex(), PeerID: pid, Addr: "10.0.0.1:1001", Record: rec}
+	}
+	good := &proto.PeerRecord{NetworkID: pid, Addrs: []string{"10.0.0.1:1001"}}
+	cases := []struct {
+		name string
+		nd   proto.DHTNode
+		want bool
+	}{
+		{"no record", node(nil), false},
+		{"record for another peer", node(&proto.PeerRecord{NetworkID: self, Addrs: good.Addrs}), false},
+		{"address not in record", node(&proto.PeerRecord{NetworkID: pid, Addrs: []string{"10.9.9.9:1"}}), false},
+		{"valid", node(good), true},
+	}
+	for _, c := range cases {
+		if got := h.acceptNode(c.nd); got != c.want {
+			t.Errorf("%s: acceptNode = %v; want %v", c.name, got, c.want)
+		}
+	}
+
+	fr.rejected[pid] = true
+	if h.acceptNode(node(good)) {
+		t.Errorf("acceptNode took a record that failed verification")
+	}
+}
@@ -0,0 +1,369 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// DefaultProviderTTL is how long a node keeps a provider entry after the
+// last ADD_PROVIDER for it. Providers re-announce at half this interval.
+const DefaultProviderTTL = 24 * time.Hour
+
+const (
+	maxProvidersPerKey   = 20
+	maxProviderKeys      = 4096
+	maxKeysPerProvider   = 256 // so one peer cannot fill the table alone
+	maxProvidersReturned = 20
+)
+
+var (
+	ErrProviderNotSender = errors.New("dht: provider is not the sender")
+	ErrBadProvider       = errors.New("dht: bad provider entry")
+)
+
+// WithProviderTTL sets how long provider entries given to us are kept.
+func WithProviderTTL(ttl time.Duration) Option {
+	return func(d *DHT) {
+		if ttl > 0 {
+			d.providers.ttl = ttl
+		}
+	}
+}
+
+type providerEntry struct {
+	node    proto.DHTNode
+	expires time.Time
+}
+
+// providerStore holds the peers that announced they have a key. Each key
+// keeps at most maxProvidersPerKey entries, one per peer, and each peer
+// provides at most maxKeysPerProvider keys.
+type providerStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	byKey   map[[32]byte]map[string]providerEntry
+	perPeer map[string]int // entries held per provider peer ID
+}
+
+func newProviderStore() *providerStore {
+	return &providerStore{
+		ttl:     DefaultProviderTTL,
+		byKey:   make(map[[32]byte]map[string]providerEntry),
+		perPeer: make(map[string]int),
+	}
+}
+
+// add records nd as a provider of key. Whenever a limit is reached it makes
+// room by dropping what is closest to expiry, which is never the fresh
+// entry: within the key, among the peer's own keys, or the whole key whose
+// last announcement is oldest.
+func (s *providerStore) add(key [32]byte, nd proto.DHTNode, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byKey[key][nd.PeerID]; !ok {
+		if s.perPeer[nd.PeerID] >= maxKeysPerProvider {
+			s.evictPeerLocked(nd.PeerID)
+		}
+		if s.byKey[key] == nil && len(s.byKey) >= maxProviderKeys {
+			s.evictKeyLocked()
+		}
+		if provs := s.byKey[key]; len(provs) >= maxProvidersPerKey {
+			var oldest string
+			for id, e := range provs {
+				if oldest == "" || e.expires.Before(provs[oldest].expires) {
+					oldest = id
+				}
+			}
+			s.removeLocked(key, oldest)
+		}
+		s.perPeer[nd.PeerID]++
+	}
+	provs := s.byKey[key]
+	if provs == nil {
+		provs = make(map[string]providerEntry)
+		s.byKey[key] = provs
+	}
+	provs[nd.PeerID] = providerEntry{node: nd, expires: now.Add(s.ttl)}
+}
+
+func (s *providerStore) removeLocked(key [32]byte, peerID string) {
+	provs := s.byKey[key]
+	if _, ok := provs[peerID]; !ok {
+		return
+	}
+	delete(provs, peerID)
+	if len(provs) == 0 {
+		delete(s.byKey, key)
+	}
+	if s.perPeer[peerID]--; s.perPeer[peerID] <= 0 {
+		delete(s.perPeer, peerID)
+	}
+}
+
+// evictPeerLocked drops peerID's entry closest to expiry.
+func (s *providerStore) evictPeerLocked(peerID string) {
+	var (
+		victim [32]byte
+		soon   time.Time
+	)
+	for key, provs := range s.byKey {
+		if e, ok := provs[peerID]; ok && (soon.IsZero() || e.expires.Before(soon)) {
+			victim, soon = key, e.expires
+		}
+	}
+	s.removeLocked(victim, peerID)
+}
+
+// evictKeyLocked drops the key whose latest announcement expires first.
+func (s *providerStore) evictKeyLocked() {
+	var (
+		victim [32]byte
+		soon   time.Time
+	)
+	for key, provs := range s.byKey {
+		var last time.Time
+		for _, e := range provs {
+			if e.expires.After(last) {
+				last = e.expires
+			}
+		}
+		if soon.IsZero() || last.Before(soon) {
+			victim, soon = key, last
+		}
+	}
+	for id := range s.byKey[victim] {
+		s.removeLocked(victim, id)
+	}
+}
+
+// get returns up to max unexpired providers of key, most recently
+// announced first.
+func (s *providerStore) get(key [32]byte, now time.Time, max int) []proto.DHTNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]providerEntry, 0, len(s.byKey[key]))
+	for _, e := range s.byKey[key] {
+		if now.Before(e.expires) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].expires.After(entries[j].expires) })
+	if max > 0 && len(entries) > max {
+		entries = entries[:max]
+	}
+	out := make([]proto.DHTNode, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.node)
+	}
+	return out
+}
+
+func (s *providerStore) sweep(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for key, provs := range s.byKey {
+		for id, e := range provs {
+			if !now.Before(e.expires) {
+				s.removeLocked(key, id)
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// handleAddProvider validates an ADD_PROVIDER: it must name exactly one
+// provider, the sender itself, in a form acceptNode would let into the
+// routing table (so with peer records on, signed for the given address).
+func (d *DHT) handleAddProvider(fromPeerID string, w proto.DHTWire) error {
+	key, err := ParseKeyHex(w.Key)
+	if err != nil || len(w.Providers) != 1 {
+		return ErrBadProvider
+	}
+	nd := w.Providers[0]
+	if nd.PeerID != fromPeerID {
+		return ErrProviderNotSender
+	}
+	if !d.acceptNode(nd) {
+		return ErrBadProvider
+	}
+	d.providers.add(key, nd, time.Now())
+	return nil
+}
+
+// providerNodes returns the providers of key to hand out. With peer
+// records on, each carries the newest record we hold for it, which may
+// have been re-signed since it was announced; one whose record has lapsed
+// is left out, as the asker's acceptNode would refuse it.
+func (d *DHT) providerNodes(key [32]byte, now time.Time) []proto.DHTNode {
+	provs := d.providers.get(key, now, 0)
+	if d.records == nil {
+		if len(provs) > maxProvidersReturned {
+			provs = provs[:maxProvidersReturned]
+		}
+		return provs
+	}
+	out := provs[:0]
+	for _, nd := range provs {
+		if rec := d.records.Lookup(nd.PeerID); rec != nil && len(rec.Addrs) > 0 {
+			nd.Record = rec
+			if !slices.Contains(rec.Addrs, nd.Addr) {
+				nd.Addr = rec.Addrs[0]
+			}
+		} else if nd.Record == nil || now.Unix() >= nd.Record.ExpiresUnix {
+			continue
+		}
+		out = append(out, nd)
+		if len(out) == maxProvidersReturned {
+			break
+		}
+	}
+	return out
+}
+
+// Provide announces self() as a provider of key to the K nodes closest to
+// it, and keeps re-announcing it from RunRecordMaintenance until
+// StopProviding. self is called again for every announcement, so it should
+// return our current signed peer record when the network uses them: the
+// announcement is refused without one, and lookups drop the entry once the
+// record it carries expires.
+func (d *DHT) Provide(ctx context.Context, n Sender, key [32]byte, self func() proto.DHTNode, cfg PublishConfig) error {
+	nd := self()
+	d.provMu.Lock()
+	d.providing[key] = providingRec{self: self, nextReprovide: d.nextReprovide(nd, time.Now())}
+	d.provMu.Unlock()
+
+	return d.announceProvider(ctx, n, key, nd, cfg)
+}
+
+// StopProviding stops re-announcing key. Entries already handed out expire
+// on their own.
+func (d *DHT) StopProviding(key [32]byte) {
+	d.provMu.Lock()
+	delete(d.providing, key)
+	d.provMu.Unlock()
+}
+
+type providingRec struct {
+	self          func() proto.DHTNode
+	nextReprovide time.Time
+}
+
+// nextReprovide is when an announcement of nd made at now is due again:
+// at half the entry TTL, or sooner, at half the life left in the peer
+// record it carries, so nodes always have an unexpired one to hand out.
+func (d *DHT) nextReprovide(nd proto.DHTNode, now time.Time) time.Time {
+	next := now.Add(d.providers.ttl / 2)
+	if nd.Record != nil {
+		if half := now.Add(time.Unix(nd.Record.ExpiresUnix, 0).Sub(now) / 2); half.Before(next) {
+			next = half
+		}
+	}
+	return next
+}
+
+func (d *DHT) announceProvider(ctx context.Context, n Sender, key [32]byte, self proto.DHTNode, cfg PublishConfig) error {
+	if cfg.K <= 0 {
+		cfg.K = 20
+	}
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = 3
+	}
+	if cfg.RPCTimeout <= 0 {
+		cfg.RPCTimeout = 1200 * time.Millisecond
+	}
+
+	// We answer GET_PROVIDERS for our own keys too.
+	d.providers.add(key, self, time.Now())
+
+	lookupCfg := DefaultLookupConfig()
+	lookupCfg.K = cfg.K
+	lookupCfg.Alpha = cfg.Alpha
+	lookupCfg.RPCTimeout = cfg.RPCTimeout
+
+	nodes, err := d.IterativeFindNode(n, NodeID(key).Hex(), lookupCfg)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > cfg.K {
+		nodes = nodes[:cfg.K]
+	}
+
+	sem := make(chan struct{}, cfg.Alpha)
+	errCh := make(chan error, len(nodes))
+
+	for _, nd := range nodes {
+		nd := nd
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				errCh <- ctx.Err()
+				return
+			}
+			w, e := d.QueryAddProvider(n, nd.PeerID, KeyHex(key), self, cfg.RPCTimeout)
+			if e != nil {
+				errCh <- e
+				return
+			}
+			if w.Kind != "STORE_RESULT" || !w.OK {
+				errCh <- ErrBadProvider
+				return
+			}
+			errCh <- nil
+		}()
+	}
+
+	// As with PublishRecord, one node taking the entry is enough.
+	var firstErr error
+	stored := 0
+	for i := 0; i < len(nodes); i++ {
+		if e := <-errCh; e == nil {
+			stored++
+		} else if firstErr == nil {
+			firstErr = e
+		}
+	}
+	if stored > 0 || len(nodes) == 0 {
+		return nil
+	}
+	return firstErr
+}
+
+func (d *DHT) reprovide(ctx context.Context, n Sender) {
+	now := time.Now()
+
+	d.provMu.Lock()
+	due := make(map[[32]byte]func() proto.DHTNode)
+	for k, p := range d.providing {
+		if !now.Before(p.nextReprovide) {
+			due[k] = p.self
+		}
+	}
+	d.provMu.Unlock()
+
+	for k, self := range due {
+		if err := ctx.Err(); err != nil {
+			return
+		}
+		nd := self()
+		_ = d.announceProvider(ctx, n, k, nd, DefaultPublishConfig())
+
+		d.provMu.Lock()
+		if p, ok := d.providing[k]; ok {
+			p.nextReprovide = d.nextReprovide(nd, time.Now())
+			d.providing[k] = p
+		}
+		d.provMu.Unlock()
+	}
+}
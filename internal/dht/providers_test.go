@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func providerNode(t *testing.T, peerID, addr string) proto.DHTNode {
+	t.Helper()
+	id, err := NodeIDFromPeerID(peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proto.DHTNode{NodeID: id.Hex(), PeerID: peerID, Addr: addr}
+}
+
+func sendWire(t *testing.T, h *DHT, n *fakeSender, from string, w proto.DHTWire) proto.DHTWire {
+	t.Helper()
+	env := proto.Envelope{Type: proto.MsgDHT, FromID: from, Payload: proto.MustMarshal(w)}
+	h.HandleDHT(n, from, "127.0.0.1:9999", "", env)
+
+	var got proto.DHTWire
+	if err := json.Unmarshal(n.sentEnv.Payload, &got); err != nil {
+		t.Fatalf("unmarshal reply: %v", err)
+	}
+	return got
+}
+
+func TestHandler_AddProviderThenGetProviders(t *testing.T) {
+	selfPeerID := MustParseNodeIDHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f").Hex()
+	h, err := New(selfPeerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeSender{selfID: selfPeerID}
+
+	from := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	other := "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	key := KeyFromImmutable([]byte("some file"))
+
+	got := sendWire(t, h, n, from, proto.DHTWire{
+		Kind: "ADD_PROVIDER", RPCID: "rpc-1", Key: KeyHex(key),
+		Providers: []proto.DHTNode{providerNode(t, other, "10.0.0.2:1002")},
+	})
+	if got.Kind != "STORE_RESULT" || got.OK || got.Error != ErrProviderNotSender.Error() {
+		t.Fatalf("announcing someone else: got %+v", got)
+	}
+
+	got = sendWire(t, h, n, from, proto.DHTWire{
+		Kind: "ADD_PROVIDER", RPCID: "rpc-2", Key: KeyHex(key),
+		Providers: []proto.DHTNode{providerNode(t, from, "10.0.0.1:1001")},
+	})
+	if got.Kind != "STORE_RESULT" || !got.OK || got.RPCID != "rpc-2" {
+		t.Fatalf("announcing ourselves: got %+v", got)
+	}
+
+	got = sendWire(t, h, n, other, proto.DHTWire{Kind: "GET_PROVIDERS", RPCID: "rpc-3", Key: KeyHex(key)})
+	if got.Kind != "PROVIDERS" || got.RPCID != "rpc-3" {
+		t.Fatalf("expected PROVIDERS reply, got %+v", got)
+	}
+	if len(got.Providers) != 1 || got.Providers[0].PeerID != from || got.Providers[0].Addr != "10.0.0.1:1001" {
+		t.Fatalf("providers = %+v; want just %s", got.Providers, from)
+	}
+	if len(got.Nodes) == 0 {
+		t.Fatalf("expected closer nodes alongside the providers")
+	}
+}
+
+func TestProviderStore_ExpiresAndCapsPerKey(t *testing.T) {
+	s := newProviderStore()
+	s.ttl = time.Minute
+	key := KeyFromImmutable([]byte("k"))
+	now := time.Now()
+
+	for i := 0; i < maxProvidersPerKey+5; i++ {
+		peerID := randID(t).Hex()
+		s.add(key, proto.DHTNode{PeerID: peerID}, now.Add(time.Duration(i)*time.Second))
+	}
+	if got := s.get(key, now, 0); len(got) != maxProvidersPerKey {
+		t.Fatalf("kept %d providers; want %d", len(got), maxProvidersPerKey)
+	}
+
+	if got := s.get(key, now.Add(2*time.Minute), 0); len(got) != 0 {
+		t.Fatalf("expired providers returned: %d", len(got))
+	}
+	if swept := s.sweep(now.Add(2 * time.Minute)); swept != maxProvidersPerKey {
+		t.Fatalf("swept %d; want %d", swept, maxProvidersPerKey)
+	}
+	if len(s.byKey) != 0 {
+		t.Fatalf("empty key left behind after sweep")
+	}
+}
+
+func TestProviderStore_CapsKeysPerProviderAndEvictsByExpiry(t *testing.T) {
+	s := newProviderStore()
+	now := time.Now()
+	keyN := func(i int) [32]byte { return KeyFromImmutable([]byte(fmt.Sprint("k", i))) }
+
+	// One peer announcing more keys than its quota keeps its newest ones.
+	greedy := randID(t).Hex()
+	for i := 0; i < maxKeysPerProvider+10; i++ {
+		s.add(keyN(i), proto.DHTNode{PeerID: greedy}, now.Add(time.Duration(i)*time.Second))
+	}
+	if got := s.perPeer[greedy]; got != maxKeysPerProvider || len(s.byKey) != maxKeysPerProvider {
+		t.Fatalf("greedy peer holds %d entries over %d keys; want %d", got, len(s.byKey), maxKeysPerProvider)
+	}
+	if len(s.get(keyN(0), now, 0)) != 0 || len(s.get(keyN(maxKeysPerProvider+9), now, 0)) != 1 {
+		t.Fatalf("quota evicted the wrong keys")
+	}
+
+	// A full table still takes a new key, dropping the stalest.
+	for i := maxKeysPerProvider + 10; len(s.byKey) < maxProviderKeys; i++ {
+		s.add(keyN(i), proto.DHTNode{PeerID: randID(t).Hex()}, now.Add(time.Hour))
+	}
+	fresh := keyN(-1)
+	s.add(fresh, proto.DHTNode{PeerID: randID(t).Hex()}, now.Add(2*time.Hour))
+	if len(s.byKey) != maxProviderKeys || len(s.get(fresh, now, 0)) != 1 {
+		t.Fatalf("full table refused a new key")
+	}
+	if len(s.get(keyN(10), now, 0)) != 0 {
+		t.Fatalf("full table evicted something other than the key closest to expiry")
+	}
+}
+
+func TestProviders_OutliveTheRecordTheyWereAnnouncedWith(t *testing.T) {
+	selfPeerID := MustParseNodeIDHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f").Hex()
+	fr := &fakeRecords{held: map[string]*proto.PeerRecord{}}
+	h, err := New(selfPeerID, WithPeerRecords(fr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeSender{selfID: selfPeerID}
+
+	from := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	key := KeyFromImmutable([]byte("some file"))
+	now := time.Now()
+
+	nd := providerNode(t, from, "10.0.0.1:1001")
+	nd.Record = &proto.PeerRecord{NetworkID: from, Addrs: []string{nd.Addr}, Seq: 1, ExpiresUnix: now.Add(time.Hour).Unix()}
+	got := sendWire(t, h, n, from, proto.DHTWire{Kind: "ADD_PROVIDER", RPCID: "rpc-1", Key: KeyHex(key), Providers: []proto.DHTNode{nd}})
+	if !got.OK {
+		t.Fatalf("ADD_PROVIDER refused: %+v", got)
+	}
+	if provs := h.providerNodes(key, now); len(provs) != 1 || provs[0].Record.Seq != 1 {
+		t.Fatalf("providers = %+v; want the announced entry", provs)
+	}
+
+	// Past the record's TTL, with nothing newer, the entry is not handed out.
+	later := now.Add(2 * time.Hour)
+	if provs := h.providerNodes(key, later); len(provs) != 0 {
+		t.Fatalf("handed out a provider whose record expired: %+v", provs)
+	}
+
+	// A newer record for the provider, at a new address, revives it.
+	fr.held[from] = &proto.PeerRecord{NetworkID: from, Addrs: []string{"10.0.0.1:2002"}, Seq: 2, ExpiresUnix: later.Add(time.Hour).Unix()}
+	provs := h.providerNodes(key, later)
+	if len(provs) != 1 || provs[0].Record.Seq != 2 || provs[0].Addr != "10.0.0.1:2002" {
+		t.Fatalf("providers = %+v; want the entry with its current record", provs)
+	}
+}
+
+func TestProvide_ReannouncesWithAFreshSelf(t *testing.T) {
+	selfPeerID := MustParseNodeIDHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f").Hex()
+	h, err := New(selfPeerID, WithPeerRecords(&fakeRecords{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeSender{selfID: selfPeerID}
+	key := KeyFromImmutable([]byte("some file"))
+
+	var seq uint64
+	self := func() proto.DHTNode {
+		seq++
+		nd := providerNode(t, selfPeerID, "10.0.0.9:9009")
+		nd.Record = &proto.PeerRecord{NetworkID: selfPeerID, Addrs: []string{nd.Addr}, Seq: seq, ExpiresUnix: time.Now().Add(time.Hour).Unix()}
+		return nd
+	}
+	ctx := context.Background()
+	if err := h.Provide(ctx, n, key, self, DefaultPublishConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	h.provMu.Lock()
+	next := h.providing[key].nextReprovide
+	h.providing[key] = providingRec{self: self, nextReprovide: time.Now().Add(-time.Second)}
+	h.provMu.Unlock()
+	if next.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("next re-announcement at %v comes after the record expires", next)
+	}
+
+	h.reprovide(ctx, n)
+	if seq != 2 {
+		t.Fatalf("self built %d times; want once per announcement", seq)
+	}
+	if provs := h.providers.get(key, time.Now(), 0); len(provs) != 1 || provs[0].Record.Seq != 2 {
+		t.Fatalf("own entry = %+v; want the re-announced record", provs)
+	}
+}
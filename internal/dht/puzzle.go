@@ -0,0 +1,68 @@
+package dht
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+// A node ID is sha256 of the node's network key, so a new key is a new,
+// random place in the keyspace, and picking a place is a matter of trying
+// keys. The static puzzle from S/Kademlia makes each try cost: a network
+// with difficulty c only admits IDs whose own sha256 starts with c zero
+// bits, about 2^c keys per usable ID. Honest nodes pay that once; a Sybil
+// aiming at a key pays it for every candidate it throws away.
+
+var ErrIDPuzzle = errors.New("dht: node id does not solve the network's id puzzle")
+
+// PuzzleBits returns the number of leading zero bits in sha256(id).
+func PuzzleBits(id NodeID) int {
+	sum := sha256.Sum256(id[:])
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// SolvesPuzzle reports whether id is admitted at difficulty bits. Every
+// ID is admitted at 0.
+func SolvesPuzzle(id NodeID, difficulty int) bool {
+	return difficulty <= 0 || PuzzleBits(id) >= difficulty
+}
+
+// CheckPeerID derives peerIDHex's NodeID and checks it against difficulty.
+func CheckPeerID(peerIDHex string, difficulty int) (NodeID, error) {
+	id, err := NodeIDFromPeerID(peerIDHex)
+	if err != nil {
+		return id, err
+	}
+	if !SolvesPuzzle(id, difficulty) {
+		return id, ErrIDPuzzle
+	}
+	return id, nil
+}
+
+// WithIDDifficulty sets the ID puzzle difficulty in leading zero bits.
+// Nodes, routing entries and DHT traffic from IDs that do not solve it are
+// ignored, and New fails if our own ID does not. Every node in a network
+// must use the same value.
+func WithIDDifficulty(bits int) Option {
+	return func(d *DHT) { d.rt.SetIDDifficulty(bits) }
+}
+
+func (rt *RoutingTable) SetIDDifficulty(bits int) {
+	rt.mu.Lock()
+	rt.difficulty = bits
+	rt.mu.Unlock()
+}
+
+// IDDifficulty returns the ID puzzle difficulty the table admits nodes at.
+func (rt *RoutingTable) IDDifficulty() int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.difficulty
+}
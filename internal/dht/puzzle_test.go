@@ -0,0 +1,93 @@
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"p2p-park/internal/proto"
+)
+
+// puzzlePeerID returns a random peer ID whose node ID solves the puzzle at
+// bits, or fails it if solves is false.
+func puzzlePeerID(t *testing.T, bits int, solves bool) string {
+	t.Helper()
+	for {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			t.Fatalf("rand.Read: %v", err)
+		}
+		pid := hex.EncodeToString(b)
+		id, _ := NodeIDFromPeerID(pid)
+		if SolvesPuzzle(id, bits) == solves {
+			return pid
+		}
+	}
+}
+
+func TestPuzzleBits(t *testing.T) {
+	var id NodeID
+	for i := 0; i < 1000; i++ {
+		id = randID(t)
+		if PuzzleBits(id) >= 4 {
+			break
+		}
+	}
+	bits := PuzzleBits(id)
+	if bits < 4 {
+		t.Fatalf("no ID with 4 puzzle bits in 1000 tries")
+	}
+	if !SolvesPuzzle(id, bits) || SolvesPuzzle(id, bits+1) {
+		t.Fatalf("SolvesPuzzle disagrees with PuzzleBits = %d", bits)
+	}
+	if !SolvesPuzzle(id, 0) {
+		t.Fatalf("difficulty 0 must admit every ID")
+	}
+}
+
+func TestNew_SelfMustSolvePuzzle(t *testing.T) {
+	if _, err := New(puzzlePeerID(t, 8, false), WithIDDifficulty(8)); err == nil {
+		t.Fatalf("New accepted a self ID that fails the puzzle")
+	}
+	if _, err := New(puzzlePeerID(t, 8, true), WithIDDifficulty(8)); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+}
+
+func TestIDDifficulty_KeepsUnsolvedNodesOut(t *testing.T) {
+	d, err := New(puzzlePeerID(t, 8, true), WithIDDifficulty(8), WithDiversityPolicy(DiversityPolicy{MaxPerSubnet: 0}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	n := &fakeSender{selfID: d.selfIDHex}
+
+	bad := puzzlePeerID(t, 8, false)
+	good := puzzlePeerID(t, 8, true)
+	d.ObservePeer(n, bad, "127.0.0.1:1", "bad")
+	d.ObservePeer(n, good, "127.0.0.1:2", "good")
+	if got := d.rt.Size(); got != 1 {
+		t.Fatalf("routing table has %d nodes; want only the one that solves the puzzle", got)
+	}
+
+	// Neither the table nor lookups take it second hand.
+	badID, _ := NodeIDFromPeerID(bad)
+	d.rt.Upsert(badID, bad, "127.0.0.1:1", "bad")
+	if got := d.rt.Size(); got != 1 {
+		t.Fatalf("routing table admitted an unsolved ID on Upsert")
+	}
+	if d.acceptNode(proto.DHTNode{NodeID: badID.Hex(), PeerID: bad, Addr: "127.0.0.1:1"}) {
+		t.Fatalf("acceptNode took an unsolved ID")
+	}
+
+	// Its requests go unanswered.
+	env := proto.Envelope{Type: proto.MsgDHT, FromID: bad, Payload: proto.MustMarshal(proto.DHTWire{Kind: "PING", RPCID: "rpc-1"})}
+	d.HandleDHT(n, bad, "127.0.0.1:1", "bad", env)
+	if n.sentTo != "" {
+		t.Fatalf("answered a PING from an unsolved ID")
+	}
+	env.FromID = good
+	d.HandleDHT(n, good, "127.0.0.1:2", "good", env)
+	if n.sentTo != good {
+		t.Fatalf("did not answer a PING from a solved ID")
+	}
+}
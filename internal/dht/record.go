@@ -18,6 +18,7 @@ var (
 	ErrSeqTooLow      = errors.New("dht: seq too low")
 	ErrKeyMismatch    = errors.New("dht: key mismatch")
 	ErrRecordTooLarge = errors.New("dht: record too large")
+	ErrCopyHeld       = errors.New("dht: cached copy not stored; a copy is already held")
 )
 
 func KeyFromImmutable(value []byte) [32]byte {
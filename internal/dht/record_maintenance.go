@@ -38,9 +38,11 @@ func (d *DHT) RunRecordMaintenance(ctx context.Context, n Sender, cfg Maintenanc
 
 		case <-sweepT.C:
 			_ = d.rs.SweepExpired(time.Now())
+			_ = d.providers.sweep(time.Now())
 
 		case <-repT.C:
 			d.republishOwned(ctx, n)
+			d.reprovide(ctx, n)
 		}
 	}
 }
@@ -63,17 +65,12 @@ func (d *DHT) republishOwned(ctx context.Context, n Sender) {
 			return
 		}
 		rec, ok := d.rs.Get(k, time.Now())
-		if !ok || rec == nil {
-			d.ownedMu.Lock()
-			delete(d.owned, k)
-			d.ownedMu.Unlock()
+		if !ok || rec == nil || IsCached(rec) {
+			d.dropOwned(k)
 			continue
 		}
 
 		_ = d.PublishRecord(ctx, n, k, rec, DefaultPublishConfig())
-
-		d.ownedMu.Lock()
-		d.owned[k] = ownedRec{nextRepublish: time.Now().Add(30 * time.Minute)}
-		d.ownedMu.Unlock()
+		d.setOwned(k, time.Now().Add(30*time.Minute))
 	}
 }
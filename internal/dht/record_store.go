@@ -16,6 +16,15 @@ type RecordStore interface {
 	Len() int
 }
 
+// OwnedStore is implemented by record stores that also persist the keys we
+// published ourselves, with when each is next due for republishing, so the
+// republish list survives a restart.
+type OwnedStore interface {
+	LoadOwned() (map[[32]byte]time.Time, error)
+	PutOwned(key [32]byte, nextRepublish time.Time) error
+	DeleteOwned(key [32]byte) error
+}
+
 type MemRecordStore struct {
 	mu   sync.RWMutex
 	data map[[32]byte]*proto.DHTRecord
@@ -55,7 +64,7 @@ func (m *MemRecordStore) Get(key [32]byte, now time.Time) (*proto.DHTRecord, boo
 	if rec == nil {
 		return nil, false
 	}
-	if rec.ExpiresUnix != 0 && now.Unix() > rec.ExpiresUnix {
+	if exp := RecordExpiry(rec); exp != 0 && now.Unix() > exp {
 		return nil, false
 	}
 	return copyRecord(rec), true
@@ -69,20 +78,28 @@ func (m *MemRecordStore) Put(key [32]byte, rec *proto.DHTRecord, now time.Time)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Enforce mutable seq rule (if same key exists)
-	if rec.Type == RecordMutable {
-		old := m.data[key]
-		if old != nil && old.Type == RecordMutable {
-			if rec.Seq <= old.Seq {
-				return ErrSeqTooLow
-			}
-		}
+	if err := CheckReplace(m.data[key], rec); err != nil {
+		return err
 	}
 
 	m.data[key] = copyRecord(rec)
 	return nil
 }
 
+// CheckReplace enforces the mutable seq rule for a store holding old (nil
+// if none) that is given rec: the new copy needs a higher Seq, except that
+// a replica may take the place of a path-cached copy of the same Seq, as
+// the owner's republish or a read-repair would otherwise bounce off it.
+func CheckReplace(old, rec *proto.DHTRecord) error {
+	if old == nil || old.Type != RecordMutable || rec.Type != RecordMutable {
+		return nil
+	}
+	if rec.Seq > old.Seq || rec.Seq == old.Seq && IsCached(old) && !IsCached(rec) {
+		return nil
+	}
+	return ErrSeqTooLow
+}
+
 func (m *MemRecordStore) Delete(key [32]byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -99,7 +116,10 @@ func (m *MemRecordStore) SweepExpired(now time.Time) int {
 
 	n := 0
 	for k, rec := range m.data {
-		if rec != nil && rec.ExpiresUnix != 0 && now.Unix() > rec.ExpiresUnix {
+		if rec == nil {
+			continue
+		}
+		if exp := RecordExpiry(rec); exp != 0 && now.Unix() > exp {
 			delete(m.data, k)
 			n++
 		}
@@ -63,6 +63,29 @@ func (d *DHT) GetValue(ctx context.Context, n Sender, key [32]byte) (*proto.DHTR
 	return d.IterativeFindValue(ctx, n, key, DefaultValueLookupConfig())
 }
 
+// GetValueOpts tunes GetValueWithOpts.
+type GetValueOpts struct {
+	// Quorum is how many valid copies of a mutable record to collect
+	// before settling on the highest Seq; 0 or 1 takes the first one.
+	Quorum int
+	// Timeout bounds the lookup. With a quorum, the newest copy found
+	// by then is returned even if the quorum was not reached.
+	Timeout time.Duration
+}
+
+// GetValueWithOpts is GetValue with a read quorum and timeout. Nodes that
+// turn out to hold an older copy are sent the newest one.
+func (d *DHT) GetValueWithOpts(ctx context.Context, n Sender, key [32]byte, opts GetValueOpts) (*proto.DHTRecord, bool, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	cfg := DefaultValueLookupConfig()
+	cfg.Quorum = opts.Quorum
+	return d.IterativeFindValue(ctx, n, key, cfg)
+}
+
 func (d *DHT) PublishRecord(ctx context.Context, n Sender, key [32]byte, rec *proto.DHTRecord, cfg PublishConfig) error {
 	if cfg.K <= 0 {
 		cfg.K = 20
@@ -78,14 +101,16 @@ func (d *DHT) PublishRecord(ctx context.Context, n Sender, key [32]byte, rec *pr
 	if err := d.ValidateRecordAgainstKey(key, rec); err != nil {
 		return err
 	}
+	// A path-cached copy is someone else's record; only they republish it.
+	if IsCached(rec) {
+		return ErrCachedCopy
+	}
 
 	now := time.Now()
 	_ = d.rs.Put(key, rec, now)
 
 	// Mark as “owned” so maintenance can republish
-	d.ownedMu.Lock()
-	d.owned[key] = ownedRec{nextRepublish: now.Add(30 * time.Minute)}
-	d.ownedMu.Unlock()
+	d.setOwned(key, now.Add(30*time.Minute))
 
 	// Find k-closest peers to the key by doing iterative FIND_NODE on the key target
 	lookupCfg := DefaultLookupConfig()
@@ -124,11 +149,19 @@ func (d *DHT) PublishRecord(ctx context.Context, n Sender, key [32]byte, rec *pr
 		}()
 	}
 
+	// Some of the closest nodes may be unreachable from here; the record is
+	// published as long as one of them took it.
 	var firstErr error
+	stored := 0
 	for i := 0; i < len(nodes); i++ {
-		if e := <-errCh; e != nil && firstErr == nil {
+		if e := <-errCh; e == nil {
+			stored++
+		} else if firstErr == nil {
 			firstErr = e
 		}
 	}
+	if stored > 0 {
+		return nil
+	}
 	return firstErr
 }
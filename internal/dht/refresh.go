@@ -2,28 +2,108 @@ package dht
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
-// RunBucketRefresh periodically refreshes the routing table by performing lookups
-// for random IDs in bucket ranges. Minimal version: just refreshes random targets.
-func (d *DHT) RunBucketRefresh(ctx context.Context, n Sender, interval time.Duration) {
-	if interval <= 0 {
-		interval = 30 * time.Minute
+type RefreshConfig struct {
+	Interval      time.Duration // how often to check for stale buckets and quiet nodes
+	BucketRefresh time.Duration // a bucket not looked up for this long gets a lookup
+	LivenessAge   time.Duration // nodes not heard from for this long get pinged
+	PingTimeout   time.Duration
+}
+
+func DefaultRefreshConfig() RefreshConfig {
+	return RefreshConfig{
+		Interval:      time.Minute,
+		BucketRefresh: time.Hour,
+		LivenessAge:   15 * time.Minute,
+		PingTimeout:   800 * time.Millisecond,
 	}
-	t := time.NewTicker(interval)
-	defer t.Stop()
+}
 
-	cfg := DefaultLookupConfig()
+// RunBucketRefresh keeps the routing table fresh the Kademlia way: every
+// bucket that has not seen a lookup within cfg.BucketRefresh gets one for a
+// random ID in its range, and the least recently seen node of each bucket
+// is pinged once it has been quiet for cfg.LivenessAge, and evicted if it
+// does not answer.
+func (d *DHT) RunBucketRefresh(ctx context.Context, n Sender, cfg RefreshConfig) {
+	def := DefaultRefreshConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.BucketRefresh <= 0 {
+		cfg.BucketRefresh = def.BucketRefresh
+	}
+	if cfg.LivenessAge <= 0 {
+		cfg.LivenessAge = def.LivenessAge
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = def.PingTimeout
+	}
+
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			// Minimal refresh: lookup a random ID (later: per-bucket ranges)
-			target := RandomNodeID()
-			_, _ = d.IterativeFindNode(n, target.Hex(), cfg)
+			d.checkLiveness(ctx, n, cfg)
+			d.refreshBuckets(ctx, n, cfg)
+		}
+	}
+}
+
+// refreshBuckets looks up a random ID in every stale bucket.
+func (d *DHT) refreshBuckets(ctx context.Context, n Sender, cfg RefreshConfig) int {
+	lookup := DefaultLookupConfig()
+	stale := d.rt.StaleBuckets(cfg.BucketRefresh, time.Now())
+	for _, bi := range stale {
+		if ctx.Err() != nil {
+			return 0
+		}
+		target := d.rt.RandomIDInBucket(bi)
+		_, _ = d.IterativeFindNode(n, target.Hex(), lookup)
+	}
+	return len(stale)
+}
+
+// livenessUnreachableLimit is how many liveness checks in a row may fail to
+// send a node a ping before it is evicted anyway. Until then it may be a
+// node we are about to connect to; after that it only keeps live nodes
+// out of its bucket.
+const livenessUnreachableLimit = 3
+
+// checkLiveness pings each bucket's least recently seen node if it has been
+// quiet, refreshing it if it answers and evicting it if the ping times out
+// or could not be sent livenessUnreachableLimit checks in a row.
+func (d *DHT) checkLiveness(ctx context.Context, n Sender, cfg RefreshConfig) (evicted int) {
+	d.liveMu.Lock()
+	defer d.liveMu.Unlock()
+	prev := d.unreachable
+	d.unreachable = make(map[NodeID]int)
+	for _, ni := range d.rt.LeastRecentlySeen(cfg.LivenessAge, time.Now()) {
+		if ctx.Err() != nil {
+			return evicted
+		}
+		resp, err := d.QueryPing(n, ni.PeerID, cfg.PingTimeout)
+		if err == nil && resp.Kind == "PONG" {
+			d.rt.Upsert(ni.NodeID, ni.PeerID, ni.Addr, ni.Name)
+			continue
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			// The ping never went out, most often because we hold no
+			// connection to the node.
+			if misses := prev[ni.NodeID] + 1; misses < livenessUnreachableLimit {
+				d.unreachable[ni.NodeID] = misses
+				continue
+			}
+		}
+		if d.rt.Remove(ni.NodeID) {
+			evicted++
 		}
 	}
+	return evicted
 }
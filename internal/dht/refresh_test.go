@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestRandomIDInBucket_FallsInBucket(t *testing.T) {
+	self := randID(t)
+	rt := NewRoutingTable(self, 20)
+	for bi := 0; bi < 256; bi++ {
+		if got := BucketIndex(self, rt.RandomIDInBucket(bi)); got != bi {
+			t.Fatalf("RandomIDInBucket(%d) landed in bucket %d", bi, got)
+		}
+	}
+}
+
+func TestStaleBuckets_StopsPastDeepestOccupied(t *testing.T) {
+	self := randID(t)
+	rt := NewRoutingTable(self, 20)
+	now := time.Now()
+	rt.upsertLRU(rt.RandomIDInBucket(3), "p", "10.0.0.1:1", "", now, nil)
+	rt.MarkLookup(rt.RandomIDInBucket(1), now)
+
+	got := rt.StaleBuckets(time.Hour, now)
+	want := []int{0, 2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("stale = %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("stale = %v; want %v", got, want)
+		}
+	}
+	if got := rt.StaleBuckets(time.Hour, now.Add(2*time.Hour)); len(got) != 5 {
+		t.Fatalf("after the interval all of 0..4 are stale, got %v", got)
+	}
+}
+
+func TestCheckLiveness_EvictsSilentTailAndPromotes(t *testing.T) {
+	selfPeerID := randID(t).Hex()
+	d, err := New(selfPeerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := time.Now().Add(-time.Hour)
+	quiet := d.rt.RandomIDInBucket(0)
+	fresh := d.rt.RandomIDInBucket(0)
+	spare := NodeInfo{NodeID: d.rt.RandomIDInBucket(0), PeerID: "spare", Addr: "10.0.2.1:1"}
+	d.rt.upsertLRU(quiet, "quiet", "10.0.0.1:1", "", long, nil)
+	d.rt.upsertLRU(fresh, "fresh", "10.0.1.1:1", "", time.Now(), nil)
+	d.rt.buckets[0].repl = []NodeInfo{spare}
+
+	var changes []RoutingChange
+	d.rt.SetObserver(func(c RoutingChange) { changes = append(changes, c) })
+
+	// fakeSender never answers, so the ping times out.
+	cfg := RefreshConfig{LivenessAge: 15 * time.Minute, PingTimeout: 50 * time.Millisecond}
+	if got := d.checkLiveness(context.Background(), &fakeSender{selfID: selfPeerID}, cfg); got != 1 {
+		t.Fatalf("evicted %d; want 1", got)
+	}
+
+	nodes := d.rt.buckets[0].nodes
+	if len(nodes) != 2 || nodes[0].NodeID != fresh || nodes[1].NodeID != spare.NodeID {
+		t.Fatalf("bucket after eviction = %+v", nodes)
+	}
+	if len(changes) != 2 || changes[0].Added || !changes[1].Added {
+		t.Fatalf("changes = %+v; want an eviction then an addition", changes)
+	}
+}
+
+// unreachableSender has no connection to anyone.
+type unreachableSender struct{ fakeSender }
+
+func (u *unreachableSender) SendToPeer(id string, env proto.Envelope) error {
+	return fmt.Errorf("unknown peer %q", id)
+}
+
+func TestCheckLiveness_EvictsNodesItCannotReachAfterRepeatedChecks(t *testing.T) {
+	selfPeerID := randID(t).Hex()
+	d, err := New(selfPeerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := d.rt.RandomIDInBucket(0)
+	d.rt.upsertLRU(quiet, "quiet", "10.0.0.1:1", "", time.Now().Add(-time.Hour), nil)
+
+	cfg := RefreshConfig{LivenessAge: 15 * time.Minute, PingTimeout: 50 * time.Millisecond}
+	n := &unreachableSender{fakeSender{selfID: selfPeerID}}
+	for i := 1; i < livenessUnreachableLimit; i++ {
+		if got := d.checkLiveness(context.Background(), n, cfg); got != 0 {
+			t.Fatalf("check %d evicted a node no ping reached yet", i)
+		}
+	}
+	if d.rt.Size() != 1 {
+		t.Fatalf("routing table lost the unreachable node early")
+	}
+	if got := d.checkLiveness(context.Background(), n, cfg); got != 1 || d.rt.Size() != 0 {
+		t.Fatalf("check %d evicted %d; want the node that was never reachable", livenessUnreachableLimit, got)
+	}
+}
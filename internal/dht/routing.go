@@ -35,7 +35,18 @@ type RoutingTable struct {
 	mu      sync.RWMutex
 	buckets [256]bucket
 
-	diversity DiversityPolicy
+	diversity  DiversityPolicy
+	difficulty int // ID puzzle bits a node needs to get in; see SolvesPuzzle
+
+	lookedUp [256]time.Time // last lookup for a target in each bucket's range
+
+	observer func(RoutingChange) // set before use; called without rt.mu held
+}
+
+// RoutingChange reports a node entering or leaving the routing table.
+type RoutingChange struct {
+	Added bool // false: evicted
+	Node  NodeInfo
 }
 
 func NewRoutingTable(self NodeID, k int) *RoutingTable {
@@ -52,6 +63,21 @@ func (rt *RoutingTable) Upsert(nodeID NodeID, peerID, addr, name string) {
 	rt.upsertLRU(nodeID, peerID, addr, name, time.Now(), nil)
 }
 
+// SetObserver registers fn to be told about nodes added to and evicted from
+// the table. Call it before the table is in use.
+func (rt *RoutingTable) SetObserver(fn func(RoutingChange)) {
+	rt.observer = fn
+}
+
+func (rt *RoutingTable) notify(changes ...RoutingChange) {
+	if rt.observer == nil {
+		return
+	}
+	for _, c := range changes {
+		rt.observer(c)
+	}
+}
+
 // PingFunc returns true if the node is alive.
 type PingFunc func(NodeInfo) bool
 
@@ -73,6 +99,10 @@ func (rt *RoutingTable) upsertLRU(id NodeID, peerID, addr, name string, now time
 	}
 
 	rt.mu.Lock()
+	if !SolvesPuzzle(id, rt.difficulty) {
+		rt.mu.Unlock()
+		return
+	}
 	b := rt.buckets[bi]
 
 	for i := range b.nodes {
@@ -129,6 +159,7 @@ func (rt *RoutingTable) upsertLRU(id NodeID, peerID, addr, name string, now time
 		b.nodes = append([]NodeInfo{ni}, b.nodes...)
 		rt.buckets[bi] = b
 		rt.mu.Unlock()
+		rt.notify(RoutingChange{Added: true, Node: ni})
 		return
 	}
 
@@ -152,6 +183,7 @@ func (rt *RoutingTable) upsertLRU(id NodeID, peerID, addr, name string, now time
 		b.nodes = append([]NodeInfo{ni}, b.nodes...)
 		rt.buckets[bi] = b
 		rt.mu.Unlock()
+		rt.notify(RoutingChange{Added: true, Node: ni})
 		return
 	}
 
@@ -167,10 +199,12 @@ func (rt *RoutingTable) upsertLRU(id NodeID, peerID, addr, name string, now time
 	}
 
 	// Tail considered dead => evict it (best-effort)
+	evicted := b.nodes[len(b.nodes)-1]
 	b.nodes = b.nodes[:len(b.nodes)-1]
 	b.nodes = append([]NodeInfo{ni}, b.nodes...)
 	rt.buckets[bi] = b
 	rt.mu.Unlock()
+	rt.notify(RoutingChange{Node: evicted}, RoutingChange{Added: true, Node: ni})
 }
 
 func (rt *RoutingTable) addReplacement(b bucket, ni NodeInfo) bucket {
@@ -298,3 +332,127 @@ func (rt *RoutingTable) SetDiversityLimit(maxPerSubnet int) {
 	rt.diversity.MaxPerSubnet = maxPerSubnet
 	rt.mu.Unlock()
 }
+
+// MarkLookup records a lookup for target, which counts as a refresh of the
+// bucket target falls in.
+func (rt *RoutingTable) MarkLookup(target NodeID, now time.Time) {
+	bi := BucketIndex(rt.self, target)
+	if bi < 0 {
+		return
+	}
+	rt.mu.Lock()
+	rt.lookedUp[bi] = now
+	rt.mu.Unlock()
+}
+
+// StaleBuckets returns the buckets not looked up since interval before now.
+// Buckets past the deepest occupied one are left out: their ranges are so
+// close to us that they are almost certainly empty.
+func (rt *RoutingTable) StaleBuckets(interval time.Duration, now time.Time) []int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	deepest := -1
+	for i := 255; i >= 0; i-- {
+		if len(rt.buckets[i].nodes) > 0 {
+			deepest = i
+			break
+		}
+	}
+	var out []int
+	for i := 0; i <= min(deepest+1, 255); i++ {
+		if now.Sub(rt.lookedUp[i]) >= interval {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// RandomIDInBucket returns a random ID that shares exactly bi leading bits
+// with our own, i.e. one that falls in bucket bi.
+func (rt *RoutingTable) RandomIDInBucket(bi int) NodeID {
+	id := RandomNodeID()
+	byteIdx, bit := bi/8, bi%8
+	copy(id[:byteIdx], rt.self[:byteIdx])
+	prefix := byte(0xff) << (8 - bit) // bits of self to keep in byteIdx
+	flip := byte(0x80) >> bit
+	id[byteIdx] = rt.self[byteIdx]&prefix | (rt.self[byteIdx]^flip)&flip | id[byteIdx]&^(prefix|flip)
+	return id
+}
+
+// LeastRecentlySeen returns, per bucket, the least recently seen node if we
+// have not heard from it for maxAge.
+func (rt *RoutingTable) LeastRecentlySeen(maxAge time.Duration, now time.Time) []NodeInfo {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var out []NodeInfo
+	for i := range rt.buckets {
+		b := rt.buckets[i].nodes
+		if len(b) == 0 {
+			continue
+		}
+		if tail := b[len(b)-1]; now.Sub(tail.LastSeen) >= maxAge {
+			out = append(out, tail)
+		}
+	}
+	return out
+}
+
+// Remove evicts id, promoting the freshest entry from the bucket's
+// replacement cache in its place. It reports whether id was present.
+func (rt *RoutingTable) Remove(id NodeID) bool {
+	bi := BucketIndex(rt.self, id)
+	if bi < 0 {
+		return false
+	}
+	rt.mu.Lock()
+	b := rt.buckets[bi]
+	idx := -1
+	for i := range b.nodes {
+		if b.nodes[i].NodeID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		rt.mu.Unlock()
+		return false
+	}
+	changes := []RoutingChange{{Node: b.nodes[idx]}}
+	b.nodes = append(b.nodes[:idx:idx], b.nodes[idx+1:]...)
+	if len(b.repl) > 0 {
+		promoted := b.repl[0]
+		b.repl = b.repl[1:]
+		b.nodes = append(b.nodes, promoted) // unproven, so least recently seen
+		changes = append(changes, RoutingChange{Added: true, Node: promoted})
+	}
+	rt.buckets[bi] = b
+	rt.mu.Unlock()
+	rt.notify(changes...)
+	return true
+}
+
+// BucketInfo is a snapshot of one non-empty bucket.
+type BucketInfo struct {
+	Index        int
+	Nodes        []NodeInfo // most recently seen first
+	Replacements int
+}
+
+// Buckets returns the non-empty buckets in index order.
+func (rt *RoutingTable) Buckets() []BucketInfo {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var out []BucketInfo
+	for i := range rt.buckets {
+		b := rt.buckets[i]
+		if len(b.nodes) == 0 && len(b.repl) == 0 {
+			continue
+		}
+		out = append(out, BucketInfo{
+			Index:        i,
+			Nodes:        append([]NodeInfo(nil), b.nodes...),
+			Replacements: len(b.repl),
+		})
+	}
+	return out
+}
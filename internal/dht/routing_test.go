@@ -73,3 +73,26 @@ func TestRoutingTable_ClosestSortedByDistance(t *testing.T) {
 		}
 	}
 }
+
+func TestRoutingTable_ObserverSeesAddAndEvict(t *testing.T) {
+	var self, a, b NodeID
+	a[0], b[0] = 0x80, 0x81 // both in bucket 0
+	rt := NewRoutingTable(self, 1)
+	rt.SetDiversityLimit(0)
+
+	var changes []RoutingChange
+	rt.SetObserver(func(c RoutingChange) { changes = append(changes, c) })
+
+	rt.Upsert(a, a.Hex(), "10.0.0.1:1", "a")
+	rt.Upsert(a, a.Hex(), "10.0.0.1:1", "a") // refresh, not a change
+	rt.UpsertWithEviction(b, b.Hex(), "10.0.0.2:1", "b", func(NodeInfo) bool { return false })
+
+	if len(changes) != 3 {
+		t.Fatalf("changes = %+v, want add a, evict a, add b", changes)
+	}
+	if !changes[0].Added || changes[0].Node.NodeID != a ||
+		changes[1].Added || changes[1].Node.NodeID != a ||
+		!changes[2].Added || changes[2].Node.NodeID != b {
+		t.Fatalf("changes = %+v, want add a, evict a, add b", changes)
+	}
+}
@@ -198,3 +198,76 @@ func (d *DHT) QueryStore(n Sender, peerID string, keyHex string, rec *proto.DHTR
 		return proto.DHTWire{}, context.DeadlineExceeded
 	}
 }
+
+func (d *DHT) QueryAddProvider(n Sender, peerID string, keyHex string, self proto.DHTNode, timeout time.Duration) (proto.DHTWire, error) {
+	ok := false
+	defer func() { d.metrics.IncRPC("ADD_PROVIDER", ok) }()
+	rpcid := newRPCID()
+	ch := make(chan proto.DHTWire, 1)
+	if err := d.beginRPC(peerID, rpcid, ch); err != nil {
+		return proto.DHTWire{}, err
+	}
+	defer d.endRPC(peerID, rpcid)
+
+	req := proto.DHTWire{
+		Kind:      "ADD_PROVIDER",
+		RPCID:     rpcid,
+		Key:       keyHex,
+		Providers: []proto.DHTNode{self},
+	}
+
+	if err := n.SendToPeer(peerID, proto.Envelope{
+		Type:    proto.MsgDHT,
+		FromID:  n.ID(),
+		Payload: proto.MustMarshal(req),
+	}); err != nil {
+		return proto.DHTWire{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-ch:
+		ok = true
+		return resp, nil
+	case <-timer.C:
+		return proto.DHTWire{}, context.DeadlineExceeded
+	}
+}
+
+func (d *DHT) QueryGetProviders(n Sender, peerID string, keyHex string, timeout time.Duration) (proto.DHTWire, error) {
+	ok := false
+	defer func() { d.metrics.IncRPC("GET_PROVIDERS", ok) }()
+	rpcid := newRPCID()
+	ch := make(chan proto.DHTWire, 1)
+	if err := d.beginRPC(peerID, rpcid, ch); err != nil {
+		return proto.DHTWire{}, err
+	}
+	defer d.endRPC(peerID, rpcid)
+
+	req := proto.DHTWire{
+		Kind:  "GET_PROVIDERS",
+		RPCID: rpcid,
+		Key:   keyHex,
+	}
+
+	if err := n.SendToPeer(peerID, proto.Envelope{
+		Type:    proto.MsgDHT,
+		FromID:  n.ID(),
+		Payload: proto.MustMarshal(req),
+	}); err != nil {
+		return proto.DHTWire{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-ch:
+		ok = true
+		return resp, nil
+	case <-timer.C:
+		return proto.DHTWire{}, context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,214 @@
+package sim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
+
+// EclipseConfig describes an eclipse experiment. Honest nodes form a
+// network; then, for each of Victims honest nodes, a Sybil cluster of
+// ClusterSize nodes joins whose IDs were ground to share PrefixBits
+// leading bits with the victim. Sybils answer every lookup with the Sybils
+// closest to its target and never mention an honest node.
+type EclipseConfig struct {
+	Seed          int64
+	Honest        int
+	Victims       int
+	ClusterSize   int
+	PrefixBits    int
+	SybilContacts int // honest nodes each Sybil introduces itself to
+	Lookups       int // per victim, each from a different honest node
+	Lookup        dht.LookupConfig
+}
+
+func DefaultEclipseConfig() EclipseConfig {
+	lookup := dht.DefaultLookupConfig()
+	lookup.RPCTimeout = 50 * time.Millisecond // replies are synchronous; only drops wait
+	return EclipseConfig{
+		Seed:          1,
+		Honest:        100,
+		Victims:       4,
+		ClusterSize:   40, // 2K: enough to fill a lookup's whole candidate window
+		PrefixBits:    12,
+		SybilContacts: 10,
+		Lookups:       10,
+		Lookup:        lookup,
+	}
+}
+
+// EclipseResult counts the lookups for a victim that found it.
+type EclipseResult struct {
+	Lookups   int
+	Found     int
+	Agreement float64 // mean path agreement; 1 for single-path lookups
+}
+
+func (r EclipseResult) SuccessRate() float64 {
+	if r.Lookups == 0 {
+		return 0
+	}
+	return float64(r.Found) / float64(r.Lookups)
+}
+
+func (r EclipseResult) String() string {
+	return fmt.Sprintf("%d/%d lookups found the victim (%.0f%%), agreement %.2f", r.Found, r.Lookups, 100*r.SuccessRate(), r.Agreement)
+}
+
+type simPeer struct {
+	peerID string
+	id     dht.NodeID
+	addr   string
+}
+
+// RunEclipse builds the network described by cfg and runs its lookups.
+// The same Seed builds the same network, so runs that differ only in
+// cfg.Lookup are comparable.
+func RunEclipse(cfg EclipseConfig) (EclipseResult, error) {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	nw := NewNetwork(cfg.Seed)
+
+	newPeer := func(i int, subnet byte) simPeer {
+		b := make([]byte, 32)
+		rng.Read(b)
+		pid := hex.EncodeToString(b)
+		id, _ := dht.NodeIDFromPeerID(pid)
+		return simPeer{peerID: pid, id: id, addr: fmt.Sprintf("10.%d.%d.%d:4000", subnet, i/250, i%250+1)}
+	}
+
+	honest := make([]*Node, cfg.Honest)
+	for i := range honest {
+		p := newPeer(i, 0)
+		d, err := dht.New(p.peerID, dht.WithDiversityPolicy(dht.DiversityPolicy{MaxPerSubnet: 0}))
+		if err != nil {
+			return EclipseResult{}, err
+		}
+		honest[i] = NewNode(nw, p.peerID, p.addr, "honest", d)
+	}
+
+	// Each node knows a few others, then looks itself up to fill its table.
+	bootstrap := dht.DefaultLookupConfig()
+	bootstrap.RPCTimeout = cfg.Lookup.RPCTimeout
+	for _, n := range honest {
+		for j := 0; j < 8; j++ {
+			o := honest[rng.Intn(len(honest))]
+			if o != n {
+				n.DHT().ObservePeer(n, o.ID(), o.addr, o.name)
+				o.DHT().ObservePeer(o, n.ID(), n.addr, n.name)
+			}
+		}
+	}
+	for _, n := range honest {
+		self, _ := dht.NodeIDFromPeerID(n.ID())
+		if _, err := n.DHT().IterativeFindNode(n, self.Hex(), bootstrap); err != nil {
+			return EclipseResult{}, err
+		}
+	}
+
+	// Sybil clusters around the first Victims honest nodes.
+	victims := honest[:cfg.Victims]
+	var sybils []simPeer
+	for v, victim := range victims {
+		vid, _ := dht.NodeIDFromPeerID(victim.ID())
+		for len(sybils) < (v+1)*cfg.ClusterSize {
+			p := newPeer(len(sybils), 66)
+			if dht.BucketIndex(vid, p.id) >= cfg.PrefixBits {
+				sybils = append(sybils, p)
+			}
+		}
+	}
+	respond := sybilResponder(sybils, 2*cfg.Lookup.K)
+	for _, p := range sybils {
+		NewAdversary(nw, p.peerID, p.addr, "sybil", respond)
+	}
+	for _, p := range sybils {
+		for j := 0; j < cfg.SybilContacts; j++ {
+			h := honest[rng.Intn(len(honest))]
+			h.DHT().ObservePeer(h, p.peerID, p.addr, "sybil")
+		}
+	}
+
+	var res EclipseResult
+	agreement := 0.0
+	for _, victim := range victims {
+		vid, _ := dht.NodeIDFromPeerID(victim.ID())
+		done := 0
+		for _, i := range rng.Perm(len(honest)) {
+			if done == cfg.Lookups {
+				break
+			}
+			src := honest[i]
+			if src == victim {
+				continue
+			}
+			done++
+			var nodes []proto.DHTNode
+			if cfg.Lookup.DisjointPaths > 1 {
+				dl, err := src.DHT().DisjointFindNode(src, vid.Hex(), cfg.Lookup)
+				if err != nil {
+					return res, err
+				}
+				nodes = dl.Nodes
+				agreement += dl.Agreement
+			} else {
+				var err error
+				if nodes, err = src.DHT().IterativeFindNode(src, vid.Hex(), cfg.Lookup); err != nil {
+					return res, err
+				}
+				agreement++
+			}
+			res.Lookups++
+			for _, nd := range nodes {
+				if nd.PeerID == victim.ID() {
+					res.Found++
+					break
+				}
+			}
+		}
+	}
+	if res.Lookups > 0 {
+		res.Agreement = agreement / float64(res.Lookups)
+	}
+	return res, nil
+}
+
+// sybilResponder answers PINGs, and FIND_NODE and FIND_VALUE with the n
+// Sybils closest to the target.
+func sybilResponder(sybils []simPeer, n int) Responder {
+	closest := func(targetHex string) []proto.DHTNode {
+		target, err := dht.ParseNodeIDHex(targetHex)
+		if err != nil {
+			return nil
+		}
+		sorted := append([]simPeer(nil), sybils...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return dht.DistanceLess(dht.Distance(sorted[i].id, target), dht.Distance(sorted[j].id, target))
+		})
+		if len(sorted) > n {
+			sorted = sorted[:n]
+		}
+		out := make([]proto.DHTNode, 0, len(sorted))
+		for _, p := range sorted {
+			out = append(out, proto.DHTNode{NodeID: p.id.Hex(), PeerID: p.peerID, Addr: p.addr, Name: "sybil"})
+		}
+		return out
+	}
+
+	return func(_ string, req proto.DHTWire) (proto.DHTWire, bool) {
+		switch req.Kind {
+		case "PING":
+			return proto.DHTWire{Kind: "PONG"}, true
+		case "FIND_NODE":
+			return proto.DHTWire{Kind: "NODES", Target: req.Target, Nodes: closest(req.Target)}, true
+		case "FIND_VALUE":
+			return proto.DHTWire{Kind: "VALUE", Key: req.Key, Nodes: closest(req.Key)}, true
+		default:
+			return proto.DHTWire{}, false
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package sim_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"sync"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+	sim "p2p-park/internal/dht/sim"
+)
+
+func TestSim_DisjointPathsResistEclipse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("eclipse experiment builds two 300-node networks")
+	}
+	single := sim.DefaultEclipseConfig()
+	single.Lookup.DisjointPaths = 1
+	disjoint := sim.DefaultEclipseConfig()
+	disjoint.Lookup.DisjointPaths = 4
+
+	rs, err := sim.RunEclipse(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := sim.RunEclipse(disjoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("single path: %v", rs)
+	t.Logf("4 disjoint paths: %v", rd)
+
+	if rd.Found <= rs.Found {
+		t.Fatalf("disjoint paths found the victim %d times, single path %d", rd.Found, rs.Found)
+	}
+	if rd.SuccessRate() < 0.9 {
+		t.Fatalf("disjoint paths success rate %.2f; want at least 0.9", rd.SuccessRate())
+	}
+}
+
+func TestSim_DisjointPathsNeverShareANode(t *testing.T) {
+	nw := sim.NewNetwork(1)
+
+	const N = 40
+	nodes := make([]*sim.Node, 0, N)
+	for i := 0; i < N; i++ {
+		pid := randPeerID(t)
+		d, err := dht.New(pid, dht.WithDiversityPolicy(dht.DiversityPolicy{MaxPerSubnet: 0}))
+		if err != nil {
+			t.Fatalf("new dht: %v", err)
+		}
+		nodes = append(nodes, sim.NewNode(nw, pid, "127.0.0.1:0", "n", d))
+	}
+	for i := 1; i < N; i++ {
+		nodes[i].DHT().ObservePeer(nodes[i], nodes[0].ID(), "127.0.0.1:0", "root")
+		nodes[0].DHT().ObservePeer(nodes[0], nodes[i].ID(), "127.0.0.1:0", "leaf")
+	}
+
+	var mu sync.Mutex
+	queriedBy := map[string]int{}
+	cfg := dht.DefaultLookupConfig()
+	cfg.DisjointPaths = 3
+	cfg.Trace = func(h dht.LookupHop) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p, ok := queriedBy[h.PeerID]; ok && p != h.Path {
+			t.Errorf("%s queried by paths %d and %d", h.PeerID[:8], p, h.Path)
+		}
+		queriedBy[h.PeerID] = h.Path
+	}
+
+	target, _ := dht.NodeIDFromPeerID(nodes[N-1].ID())
+	res, err := nodes[1].DHT().DisjointFindNode(nodes[1], target.Hex(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Nodes) == 0 || res.Nodes[0].PeerID != nodes[N-1].ID() {
+		t.Fatalf("target not found first: %+v", res.Nodes)
+	}
+	if res.Paths != 3 || res.Agreement <= 0 || res.Agreement > 1 {
+		t.Fatalf("paths=%d agreement=%.2f", res.Paths, res.Agreement)
+	}
+}
+
+func TestSim_DisjointFindValuePicksNewestPath(t *testing.T) {
+	nw := sim.NewNetwork(1)
+
+	const N = 10
+	nodes := make([]*sim.Node, 0, N)
+	stores := make([]*dht.MemRecordStore, 0, N)
+	for i := 0; i < N; i++ {
+		pid := randPeerID(t)
+		rs := dht.NewMemRecordStore()
+		d, err := dht.New(pid, dht.WithRecordStore(rs), dht.WithDiversityPolicy(dht.DiversityPolicy{MaxPerSubnet: 0}))
+		if err != nil {
+			t.Fatalf("new dht: %v", err)
+		}
+		nodes = append(nodes, sim.NewNode(nw, pid, "127.0.0.1:0", "n", d))
+		stores = append(stores, rs)
+	}
+	reader := nodes[0]
+	for i := 1; i < N; i++ {
+		reader.DHT().ObservePeer(reader, nodes[i].ID(), "127.0.0.1:0", "n")
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, old := mutableRecord(priv, "status", 1, "old")
+	_, newest := mutableRecord(priv, "status", 2, "new")
+	for i := 1; i < N; i++ {
+		rec := newest
+		if i%3 == 0 {
+			rec = old
+		}
+		_ = stores[i].Put(key, rec, time.Now())
+	}
+
+	cfg := dht.DefaultValueLookupConfig()
+	cfg.DisjointPaths = 3
+	rec, res, err := reader.DHT().DisjointFindValue(context.Background(), reader, key, cfg)
+	if err != nil || rec == nil {
+		t.Fatalf("disjoint find value: %v, %v", rec, err)
+	}
+	// Every path asks three of the nine replicas in its first round, and
+	// only three replicas are stale, so at least two paths see seq 2.
+	if rec.Seq != 2 {
+		t.Fatalf("picked seq %d; want 2", rec.Seq)
+	}
+	if res.Paths != 3 || res.Agreement < 2.0/3 {
+		t.Fatalf("paths=%d agreement=%.2f; want 3 paths, at least 2 agreeing", res.Paths, res.Agreement)
+	}
+}
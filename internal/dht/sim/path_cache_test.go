@@ -0,0 +1,58 @@
+package sim_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+	sim "p2p-park/internal/dht/sim"
+	"p2p-park/internal/proto"
+)
+
+func TestSim_FindValueCachesOnPath(t *testing.T) {
+	nw := sim.NewNetwork(1)
+
+	// reader -> middle -> holder: only the holder has the value, and the
+	// reader can only reach it through the middle node.
+	var nodes [3]*sim.Node
+	var stores [3]*dht.MemRecordStore
+	for i := range nodes {
+		pid := randPeerID(t)
+		stores[i] = dht.NewMemRecordStore()
+		d, err := dht.New(pid, dht.WithRecordStore(stores[i]), dht.WithDiversityPolicy(dht.DiversityPolicy{MaxPerSubnet: 0}))
+		if err != nil {
+			t.Fatalf("new dht: %v", err)
+		}
+		nodes[i] = sim.NewNode(nw, pid, "127.0.0.1:0", "n", d)
+	}
+	reader, middle, holder := nodes[0], nodes[1], nodes[2]
+	reader.DHT().ObservePeer(reader, middle.ID(), "127.0.0.1:0", "middle")
+	middle.DHT().ObservePeer(middle, holder.ID(), "127.0.0.1:0", "holder")
+
+	value := []byte("popular")
+	key := dht.KeyFromImmutable(value)
+	_ = stores[2].Put(key, &proto.DHTRecord{Type: dht.RecordImmutable, Value: value}, time.Now())
+
+	rec, ok, err := reader.DHT().GetValue(context.Background(), reader, key)
+	if err != nil || !ok || string(rec.Value) != "popular" {
+		t.Fatalf("find value: %+v, %v, %v", rec, ok, err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if c, ok := stores[1].Get(key, time.Now()); ok {
+			if !dht.IsCached(c) {
+				t.Fatalf("copy on the path is not marked cached: %+v", c)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("middle node never got a cached copy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c, _ := stores[2].Get(key, time.Now()); dht.IsCached(c) {
+		t.Fatalf("holder's replica was turned into a cached copy")
+	}
+}
@@ -0,0 +1,101 @@
+package sim_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+	sim "p2p-park/internal/dht/sim"
+	"p2p-park/internal/proto"
+)
+
+func mutableRecord(priv ed25519.PrivateKey, name string, seq uint64, value string) ([32]byte, *proto.DHTRecord) {
+	pub := priv.Public().(ed25519.PublicKey)
+	key := dht.KeyFromMutable(pub, name)
+	rec := &proto.DHTRecord{
+		Type:        dht.RecordMutable,
+		Name:        name,
+		Value:       []byte(value),
+		PubKey:      pub,
+		Seq:         seq,
+		ExpiresUnix: time.Now().Add(time.Hour).Unix(),
+	}
+	rec.Sig = dht.SignMutable(priv, key, rec.Seq, rec.ExpiresUnix, rec.Value)
+	return key, rec
+}
+
+func TestSim_QuorumReadPicksNewestAndRepairs(t *testing.T) {
+	t.Run("replica", func(t *testing.T) { quorumReadRepairs(t, false) })
+	// A path-cached newest copy still repairs: nodes holding an older one
+	// would turn a cached STORE away.
+	t.Run("cached", func(t *testing.T) { quorumReadRepairs(t, true) })
+}
+
+func quorumReadRepairs(t *testing.T, cached bool) {
+	nw := sim.NewNetwork(1)
+
+	const N = 8
+	nodes := make([]*sim.Node, 0, N)
+	stores := make([]*dht.MemRecordStore, 0, N)
+	for i := 0; i < N; i++ {
+		pid := randPeerID(t)
+		rs := dht.NewMemRecordStore()
+		d, err := dht.New(pid, dht.WithRecordStore(rs), dht.WithDiversityPolicy(dht.DiversityPolicy{MaxPerSubnet: 0}))
+		if err != nil {
+			t.Fatalf("new dht: %v", err)
+		}
+		nodes = append(nodes, sim.NewNode(nw, pid, "127.0.0.1:0", "n", d))
+		stores = append(stores, rs)
+	}
+	for i := 1; i < N; i++ {
+		nodes[i].DHT().ObservePeer(nodes[i], nodes[0].ID(), "127.0.0.1:0", "root")
+		nodes[0].DHT().ObservePeer(nodes[0], nodes[i].ID(), "127.0.0.1:0", "leaf")
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, old := mutableRecord(priv, "status", 1, "old")
+	_, newest := mutableRecord(priv, "status", 2, "new")
+
+	// Node 0 answers first, with the stale copy; one other replica is current.
+	now := time.Now()
+	for i := 0; i < N-2; i++ {
+		_ = stores[i].Put(key, old, now)
+	}
+	if cached {
+		newest.CachedUntilUnix = now.Add(dht.DefaultPathCacheTTL).Unix()
+	}
+	_ = stores[N-2].Put(key, newest, now)
+
+	ctx := context.Background()
+	reader := nodes[N-1].DHT()
+	rec, ok, err := reader.GetValueWithOpts(ctx, nodes[N-1], key, dht.GetValueOpts{Quorum: N - 1, Timeout: 5 * time.Second})
+	if err != nil || !ok {
+		t.Fatalf("quorum read: ok=%v err=%v", ok, err)
+	}
+	if rec.Seq != 2 {
+		t.Fatalf("quorum read returned seq %d; want 2", rec.Seq)
+	}
+
+	// Read-repair runs in the background.
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		stale := 0
+		for i := 0; i < N-1; i++ {
+			if r, ok := stores[i].Get(key, time.Now()); !ok || r.Seq != 2 {
+				stale++
+			}
+		}
+		if stale == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d replicas still stale after read-repair", stale)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,7 @@
 package sim
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -47,10 +48,31 @@ func (nw *Network) deliver(from *Node, toPeerID string, env proto.Envelope) erro
 	if nw.Latency > 0 {
 		time.Sleep(nw.Latency)
 	}
+	if to.respond != nil {
+		var req proto.DHTWire
+		if err := json.Unmarshal(env.Payload, &req); err != nil {
+			return nil
+		}
+		reply, ok := to.respond(from.peerID, req)
+		if !ok || from.dht == nil {
+			return nil
+		}
+		reply.RPCID = req.RPCID
+		from.dht.HandleDHT(from, to.peerID, to.addr, to.name, proto.Envelope{
+			Type:    proto.MsgDHT,
+			FromID:  to.peerID,
+			Payload: proto.MustMarshal(reply),
+		})
+		return nil
+	}
 	to.dht.HandleDHT(to, from.peerID, from.addr, from.name, env)
 	return nil
 }
 
+// Responder answers a DHT request in place of a real DHT; ok=false drops
+// it. It models nodes that do not follow the protocol.
+type Responder func(fromPeerID string, req proto.DHTWire) (reply proto.DHTWire, ok bool)
+
 // Node implements dht.Sender for simulation.
 type Node struct {
 	nw     *Network
@@ -58,6 +80,8 @@ type Node struct {
 	addr   string
 	name   string
 	dht    *dht.DHT
+
+	respond Responder // set for adversarial nodes, which have no DHT
 }
 
 func NewNode(nw *Network, peerID, addr, name string, d *dht.DHT) *Node {
@@ -66,6 +90,13 @@ func NewNode(nw *Network, peerID, addr, name string, d *dht.DHT) *Node {
 	return n
 }
 
+// NewAdversary adds a node whose answers come from respond.
+func NewAdversary(nw *Network, peerID, addr, name string, respond Responder) *Node {
+	n := &Node{nw: nw, peerID: peerID, addr: addr, name: name, respond: respond}
+	nw.Add(n)
+	return n
+}
+
 func (n *Node) ID() string { return n.peerID }
 
 func (n *Node) SendToPeer(id string, env proto.Envelope) error {
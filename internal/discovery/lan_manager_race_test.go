@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -60,8 +61,10 @@ func TestLANDiscoveryRaceHarness(t *testing.T) {
 	}
 
 	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p2p.DefaultStopTimeout)
+		defer cancel()
 		for _, n := range all {
-			n.Stop()
+			_ = n.Stop(ctx)
 		}
 	}()
 
@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -56,8 +57,10 @@ func TestLANUDPDiscoveryHardRaceHarness(t *testing.T) {
 		for _, ch := range stops {
 			close(ch)
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), p2p.DefaultStopTimeout)
+		defer cancel()
 		for _, n := range all {
-			n.Stop()
+			_ = n.Stop(ctx)
 		}
 	}()
 
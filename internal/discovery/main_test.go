@@ -0,0 +1,20 @@
+package discovery
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain gives the package's nodes a throwaway data dir, so the DHT
+// routing snapshot they write (see dht.DefaultStorePath) stays out of the
+// source tree.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "p2p-park-test-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("P2P_PARK_DATA_DIR", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
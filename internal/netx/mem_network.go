@@ -0,0 +1,386 @@
+package netx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConnRefused  = errors.New("memnet: connection refused")
+	ErrPunchTimeout = errors.New("memnet: hole punch timed out")
+)
+
+const memFirstPort = 40000
+
+// MemHub is an in-process switch that connects MemNetworks, optionally
+// through emulated NATs. It is meant for tests and simulations.
+//
+// A NAT maps each internal endpoint to one external port, whatever the
+// destination (endpoint-independent mapping), and only lets an inbound
+// connection through to an external port whose internal endpoint is itself
+// dialing that exact remote endpoint (address- and port-dependent
+// filtering). That is the common home-router behaviour, where plain dials
+// between two NATed hosts fail but a simultaneous open succeeds.
+type MemHub struct {
+	mu        sync.Mutex
+	listeners map[Addr]*memNetwork
+	nextPort  map[string]int
+	punches   map[memPunchKey]chan *memConn
+}
+
+// MemNAT is an emulated NAT with one public host address.
+type MemNAT struct {
+	hub      *MemHub
+	public   string
+	mappings map[Addr]Addr // internal endpoint -> external endpoint
+}
+
+type memPunchKey struct {
+	from Addr // external endpoint of the waiting dialer
+	to   Addr // remote endpoint it is dialing
+}
+
+func NewMemHub() *MemHub {
+	return &MemHub{
+		listeners: make(map[Addr]*memNetwork),
+		nextPort:  make(map[string]int),
+		punches:   make(map[memPunchKey]chan *memConn),
+	}
+}
+
+// NewNetwork returns a Network with a public (directly reachable) host address.
+func (h *MemHub) NewNetwork(host string) Network {
+	return &memNetwork{hub: h, host: host, closed: make(chan struct{})}
+}
+
+// NewNAT creates a NAT whose external endpoints use publicHost.
+func (h *MemHub) NewNAT(publicHost string) *MemNAT {
+	return &MemNAT{hub: h, public: publicHost, mappings: make(map[Addr]Addr)}
+}
+
+// NewNetwork returns a Network with a private host address behind nat.
+func (nat *MemNAT) NewNetwork(privateHost string) Network {
+	return &memNetwork{hub: nat.hub, host: privateHost, nat: nat, closed: make(chan struct{})}
+}
+
+// externalLocked returns the external endpoint for internal endpoint local,
+// creating the mapping on first use. Caller holds hub.mu.
+func (nat *MemNAT) externalLocked(local Addr) Addr {
+	if ext, ok := nat.mappings[local]; ok {
+		return ext
+	}
+	ext := nat.hub.allocLocked(nat.public)
+	nat.mappings[local] = ext
+	return ext
+}
+
+func (h *MemHub) allocLocked(host string) Addr {
+	port := h.nextPort[host]
+	if port == 0 {
+		port = memFirstPort
+	}
+	h.nextPort[host] = port + 1
+	return Addr(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+type memNetwork struct {
+	hub  *MemHub
+	host string
+	nat  *MemNAT // nil for public hosts
+
+	mu         sync.Mutex
+	listenAddr Addr
+	accept     chan *memConn
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+func (m *memNetwork) Listen(bindAddr string) (Addr, error) {
+	_, port, err := net.SplitHostPort(bindAddr)
+	if err != nil {
+		return "", err
+	}
+
+	m.hub.mu.Lock()
+	defer m.hub.mu.Unlock()
+
+	var addr Addr
+	if port == "" || port == "0" {
+		addr = m.hub.allocLocked(m.host)
+	} else {
+		addr = Addr(net.JoinHostPort(m.host, port))
+	}
+	if _, taken := m.hub.listeners[addr]; taken {
+		return "", fmt.Errorf("memnet: address %s in use", addr)
+	}
+
+	m.mu.Lock()
+	m.listenAddr = addr
+	m.accept = make(chan *memConn, 16)
+	m.mu.Unlock()
+
+	m.hub.listeners[addr] = m
+	return addr, nil
+}
+
+func (m *memNetwork) Accept() (Conn, error) {
+	m.mu.Lock()
+	ch := m.accept
+	m.mu.Unlock()
+	if ch == nil {
+		return nil, net.ErrClosed
+	}
+	select {
+	case c := <-ch:
+		return c, nil
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *memNetwork) Dial(addr Addr) (Conn, error) {
+	m.hub.mu.Lock()
+	local := m.hub.allocLocked(m.host)
+	m.hub.mu.Unlock()
+	return m.connect(local, addr, 0)
+}
+
+// DialFrom dials remote from local, waiting up to timeout for a matching
+// dial from the other side if a NAT would otherwise drop it.
+func (m *memNetwork) DialFrom(local, remote Addr, timeout time.Duration) (Conn, error) {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return m.connect(local, remote, timeout)
+}
+
+func (m *memNetwork) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		m.hub.mu.Lock()
+		m.mu.Lock()
+		if m.hub.listeners[m.listenAddr] == m {
+			delete(m.hub.listeners, m.listenAddr)
+		}
+		m.mu.Unlock()
+		m.hub.mu.Unlock()
+	})
+	return nil
+}
+
+func (m *memNetwork) externalLocked(local Addr) Addr {
+	if m.nat == nil {
+		return local
+	}
+	return m.nat.externalLocked(local)
+}
+
+func (m *memNetwork) connect(local, remote Addr, timeout time.Duration) (Conn, error) {
+	h := m.hub
+	h.mu.Lock()
+	ext := m.externalLocked(local)
+
+	// A listener is reachable if it is public, or on our side of the same NAT.
+	if l := h.listeners[remote]; l != nil && (l.nat == nil || l.nat == m.nat) {
+		from := ext
+		if l.nat != nil {
+			from = local
+		}
+		h.mu.Unlock()
+
+		ours, theirs := newMemConnPair(local, remote, remote, from)
+		select {
+		case l.accept <- theirs:
+			return ours, nil
+		default: // backlog full
+			return nil, ErrConnRefused
+		}
+	}
+
+	// The remote endpoint's NAT admits us only if it is dialing us too.
+	if waiter, ok := h.punches[memPunchKey{from: remote, to: ext}]; ok {
+		delete(h.punches, memPunchKey{from: remote, to: ext})
+		h.mu.Unlock()
+
+		ours, theirs := newMemConnPair(local, remote, Addr(""), ext)
+		waiter <- theirs
+		return ours, nil
+	}
+
+	if timeout == 0 {
+		h.mu.Unlock()
+		return nil, ErrConnRefused
+	}
+
+	key := memPunchKey{from: ext, to: remote}
+	ch := make(chan *memConn, 1)
+	h.punches[key] = ch
+	h.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case c := <-ch:
+		c.local = local
+		return c, nil
+	case <-m.closed:
+	case <-t.C:
+	}
+
+	h.mu.Lock()
+	if h.punches[key] == ch {
+		delete(h.punches, key)
+	}
+	h.mu.Unlock()
+	// A match may have raced with the timeout.
+	select {
+	case c := <-ch:
+		c.local = local
+		return c, nil
+	default:
+	}
+	return nil, ErrPunchTimeout
+}
+
+// memConn is one end of a buffered in-memory duplex stream.
+type memConn struct {
+	rd, wr *memPipe
+	local  Addr
+	remote Addr
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	closeOnce    sync.Once
+}
+
+// Pipe returns the two ends of a buffered in-memory stream. The first end
+// reports (local, remote) as its addresses, the second the reverse. Unlike
+// net.Pipe, writes do not wait for a reader.
+func Pipe(local, remote Addr) (Conn, Conn) {
+	a, b := newMemConnPair(local, remote, remote, local)
+	return a, b
+}
+
+// newMemConnPair returns two connected ends. The first sees (aLocal, aRemote);
+// the second sees (bLocal, bRemote).
+func newMemConnPair(aLocal, aRemote, bLocal, bRemote Addr) (*memConn, *memConn) {
+	ab, ba := newMemPipe(), newMemPipe()
+	a := &memConn{rd: ba, wr: ab, local: aLocal, remote: aRemote}
+	b := &memConn{rd: ab, wr: ba, local: bLocal, remote: bRemote}
+	return a, b
+}
+
+func (c *memConn) RemoteAddr() Addr { return c.remote }
+func (c *memConn) LocalAddr() Addr  { return c.local }
+
+func (c *memConn) Read(b []byte) (int, error) {
+	for {
+		n, wake, err := c.rd.tryRead(b)
+		if n > 0 || err != nil {
+			return n, err
+		}
+
+		c.mu.Lock()
+		dl := c.readDeadline
+		c.mu.Unlock()
+
+		if dl.IsZero() {
+			<-wake
+			continue
+		}
+		d := time.Until(dl)
+		if d <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+func (c *memConn) Write(b []byte) (int, error) { return c.wr.write(b) }
+
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.rd.close()
+		c.wr.close()
+	})
+	return nil
+}
+
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.rd.signal()
+	return nil
+}
+
+type memPipe struct {
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+	wake   chan struct{} // closed and replaced on every change
+}
+
+func newMemPipe() *memPipe { return &memPipe{wake: make(chan struct{})} }
+
+func (p *memPipe) signalLocked() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+func (p *memPipe) signal() {
+	p.mu.Lock()
+	p.signalLocked()
+	p.mu.Unlock()
+}
+
+func (p *memPipe) tryRead(b []byte) (int, <-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return n, nil, nil
+	}
+	if p.closed {
+		return 0, nil, io.EOF
+	}
+	return 0, p.wake, nil
+}
+
+func (p *memPipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.buf = append(p.buf, b...)
+	p.signalLocked()
+	return len(b), nil
+}
+
+func (p *memPipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.signalLocked()
+	}
+}
+
+var (
+	_ Network       = (*memNetwork)(nil)
+	_ Puncher       = (*memNetwork)(nil)
+	_ LocalAddrConn = (*memConn)(nil)
+)
@@ -0,0 +1,68 @@
+package netx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemNAT_SimultaneousOpen(t *testing.T) {
+	hub := NewMemHub()
+	natA, natB := hub.NewNAT("203.0.113.10"), hub.NewNAT("198.51.100.20")
+	srv := hub.NewNetwork("192.0.2.1")
+	nwA, nwB := natA.NewNetwork("10.0.0.2"), natB.NewNetwork("10.0.1.2")
+
+	srvAddr, err := srv.Listen("0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	// Each side talks to the server once so its NAT creates a mapping.
+	dialSrv := func(nw Network) (local, external Addr) {
+		t.Helper()
+		c, err := nw.Dial(srvAddr)
+		if err != nil {
+			t.Fatalf("Dial server: %v", err)
+		}
+		sc, err := srv.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		return c.(LocalAddrConn).LocalAddr(), sc.RemoteAddr()
+	}
+	localA, extA := dialSrv(nwA)
+	localB, extB := dialSrv(nwB)
+
+	if _, err := nwA.Dial(extB); err == nil {
+		t.Fatalf("unsolicited dial through NAT should be refused")
+	}
+
+	type result struct {
+		c   Conn
+		err error
+	}
+	resB := make(chan result, 1)
+	go func() {
+		c, err := nwB.(Puncher).DialFrom(localB, extA, time.Second)
+		resB <- result{c, err}
+	}()
+	ca, err := nwA.(Puncher).DialFrom(localA, extB, time.Second)
+	if err != nil {
+		t.Fatalf("A DialFrom: %v", err)
+	}
+	rb := <-resB
+	if rb.err != nil {
+		t.Fatalf("B DialFrom: %v", rb.err)
+	}
+	if ca.RemoteAddr() != extB || rb.c.RemoteAddr() != extA {
+		t.Fatalf("remote addrs = %s, %s; want %s, %s", ca.RemoteAddr(), rb.c.RemoteAddr(), extB, extA)
+	}
+
+	if _, err := ca.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := rb.c.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+}
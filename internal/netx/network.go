@@ -1,6 +1,9 @@
 package netx
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type PeerID string
 type Addr string
@@ -16,3 +19,16 @@ type Network interface {
 	Dial(addr Addr) (Conn, error)
 	Close() error
 }
+
+// LocalAddrConn is implemented by connections that know their local endpoint.
+type LocalAddrConn interface {
+	LocalAddr() Addr
+}
+
+// Puncher is implemented by networks that can dial out from an endpoint an
+// existing connection already uses. Both sides of a hole punch dial each
+// other from the endpoint their NAT has mapped, so the outbound packets open
+// the mappings and the two dials meet as one connection.
+type Puncher interface {
+	DialFrom(local, remote Addr, timeout time.Duration) (Conn, error)
+}
@@ -0,0 +1,148 @@
+package portmap
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// FakeGateway is a stand-in router on a local UDP socket that grants TCP
+// mappings over PCP and NAT-PMP. It is meant for tests.
+type FakeGateway struct {
+	External netip.Addr // the address mappings are made on
+
+	conn  *net.UDPConn
+	pcp   bool
+	epoch uint32
+
+	mu       sync.Mutex
+	mappings map[uint16]uint16 // internal port -> external port
+	nextPort uint16
+	done     chan struct{}
+}
+
+// NewFakeGateway starts a gateway on 127.0.0.1. If pcp is false it behaves
+// like a NAT-PMP-only router and rejects PCP requests as an unknown version.
+func NewFakeGateway(external string, pcp bool) (*FakeGateway, error) {
+	ext, err := netip.ParseAddr(external)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	g := &FakeGateway{
+		External: ext,
+		conn:     conn,
+		pcp:      pcp,
+		mappings: make(map[uint16]uint16),
+		nextPort: 30000,
+		done:     make(chan struct{}),
+	}
+	go g.serve()
+	return g, nil
+}
+
+// Addr is the gateway's "host:port", suitable for NewClient.
+func (g *FakeGateway) Addr() string { return g.conn.LocalAddr().String() }
+
+// Mappings returns the live mappings, internal port to external port.
+func (g *FakeGateway) Mappings() map[uint16]uint16 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[uint16]uint16, len(g.mappings))
+	for k, v := range g.mappings {
+		out[k] = v
+	}
+	return out
+}
+
+func (g *FakeGateway) Close() error {
+	err := g.conn.Close()
+	<-g.done
+	return err
+}
+
+func (g *FakeGateway) serve() {
+	defer close(g.done)
+	buf := make([]byte, 1100)
+	for {
+		k, from, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if resp := g.handle(buf[:k]); resp != nil {
+			_, _ = g.conn.WriteToUDP(resp, from)
+		}
+	}
+}
+
+func (g *FakeGateway) handle(req []byte) []byte {
+	if len(req) < 2 {
+		return nil
+	}
+	switch {
+	case req[0] == verPCP && g.pcp && req[1] == opPCPMap && len(req) >= 60:
+		internal := binary.BigEndian.Uint16(req[40:42])
+		lifetime := binary.BigEndian.Uint32(req[4:8])
+		ext := g.apply(internal, lifetime)
+
+		resp := make([]byte, 60)
+		resp[0] = verPCP
+		resp[1] = opReply | opPCPMap
+		binary.BigEndian.PutUint32(resp[4:8], lifetime)
+		binary.BigEndian.PutUint32(resp[8:12], g.epoch)
+		copy(resp[24:60], req[24:60])
+		binary.BigEndian.PutUint16(resp[42:44], ext)
+		putIP16(resp[44:60], g.External)
+		return resp
+
+	case req[0] == verNATPMP && req[1] == opExternalAddr:
+		resp := make([]byte, 12)
+		resp[1] = opReply | opExternalAddr
+		binary.BigEndian.PutUint32(resp[4:8], g.epoch)
+		ip := g.External.As4()
+		copy(resp[8:12], ip[:])
+		return resp
+
+	case req[0] == verNATPMP && req[1] == opMapTCP && len(req) >= 12:
+		internal := binary.BigEndian.Uint16(req[4:6])
+		lifetime := binary.BigEndian.Uint32(req[8:12])
+		ext := g.apply(internal, lifetime)
+
+		resp := make([]byte, 16)
+		resp[1] = opReply | opMapTCP
+		binary.BigEndian.PutUint32(resp[4:8], g.epoch)
+		binary.BigEndian.PutUint16(resp[8:10], internal)
+		binary.BigEndian.PutUint16(resp[10:12], ext)
+		binary.BigEndian.PutUint32(resp[12:16], lifetime)
+		return resp
+
+	default:
+		// What a NAT-PMP router sends for a version it does not speak.
+		resp := make([]byte, 8)
+		resp[1] = opReply | req[1]
+		binary.BigEndian.PutUint16(resp[2:4], resultUnsuppVersion)
+		return resp
+	}
+}
+
+// apply creates, keeps or (for a zero lifetime) deletes the mapping for
+// internal and returns its external port.
+func (g *FakeGateway) apply(internal uint16, lifetime uint32) uint16 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lifetime == 0 {
+		delete(g.mappings, internal)
+		return 0
+	}
+	ext, ok := g.mappings[internal]
+	if !ok {
+		ext = g.nextPort
+		g.nextPort++
+		g.mappings[internal] = ext
+	}
+	return ext
+}
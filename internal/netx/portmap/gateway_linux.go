@@ -0,0 +1,40 @@
+//go:build linux
+
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// defaultGateway reads the IPv4 default route from /proc/net/route.
+func defaultGateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Iface Destination Gateway Flags ...; addresses are little-endian hex.
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], binary.LittleEndian.Uint32(raw))
+		if gw := netip.AddrFrom4(ip); !gw.IsUnspecified() {
+			return gw, nil
+		}
+	}
+	return netip.Addr{}, ErrNoGateway
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package portmap
+
+import "net/netip"
+
+// defaultGateway is only implemented on Linux; elsewhere the gateway has to
+// be configured explicitly.
+func defaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, ErrNoGateway
+}
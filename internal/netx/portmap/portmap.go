@@ -0,0 +1,309 @@
+// Package portmap asks a home router to forward a TCP port to us, using PCP
+// (RFC 6887) and falling back to its predecessor NAT-PMP (RFC 6886).
+package portmap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// ServerPort is the UDP port PCP and NAT-PMP gateways listen on.
+const ServerPort = 5351
+
+const (
+	verNATPMP = 0
+	verPCP    = 2
+
+	opExternalAddr = 0 // NAT-PMP
+	opMapTCP       = 2 // NAT-PMP
+	opPCPMap       = 1
+	opReply        = 0x80
+
+	protoTCP = 6
+
+	resultSuccess       = 0
+	resultUnsuppVersion = 1
+
+	firstRetry = 250 * time.Millisecond
+	attempts   = 3 // 250ms, 500ms, 1s
+)
+
+var (
+	ErrNoGateway   = errors.New("portmap: no default gateway found")
+	ErrNoResponse  = errors.New("portmap: gateway did not answer")
+	errUnsupported = errors.New("portmap: protocol not supported by gateway")
+)
+
+// Protocol names the protocol a mapping was made with.
+type Protocol string
+
+const (
+	PCP    Protocol = "pcp"
+	NATPMP Protocol = "nat-pmp"
+)
+
+// Mapping is a TCP port forward on the gateway.
+type Mapping struct {
+	Protocol     Protocol
+	External     netip.AddrPort // address peers can dial
+	InternalPort uint16
+	Lifetime     time.Duration // as granted by the gateway
+	Obtained     time.Time
+
+	nonce [12]byte // PCP: identifies the mapping for renew and delete
+}
+
+// RenewAt is when the mapping should be refreshed: half way through its
+// lifetime, as both RFCs recommend.
+func (m *Mapping) RenewAt() time.Time {
+	return m.Obtained.Add(m.Lifetime / 2)
+}
+
+// Client talks to one gateway.
+type Client struct {
+	gateway netip.AddrPort
+}
+
+// NewClient returns a client for gateway ("host" or "host:port"). An empty
+// gateway means the system's default route gateway.
+func NewClient(gateway string) (*Client, error) {
+	if gateway == "" {
+		gw, err := defaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		return &Client{gateway: netip.AddrPortFrom(gw, ServerPort)}, nil
+	}
+	if ap, err := netip.ParseAddrPort(gateway); err == nil {
+		return &Client{gateway: ap}, nil
+	}
+	addr, err := netip.ParseAddr(gateway)
+	if err != nil {
+		return nil, fmt.Errorf("portmap: bad gateway %q", gateway)
+	}
+	return &Client{gateway: netip.AddrPortFrom(addr, ServerPort)}, nil
+}
+
+// Gateway returns the address requests are sent to.
+func (c *Client) Gateway() netip.AddrPort { return c.gateway }
+
+// Map asks the gateway to forward a TCP port to internalPort for lifetime.
+// It tries PCP first and falls back to NAT-PMP.
+func (c *Client) Map(ctx context.Context, internalPort uint16, lifetime time.Duration) (*Mapping, error) {
+	m := &Mapping{InternalPort: internalPort}
+	if _, err := rand.Read(m.nonce[:]); err != nil {
+		return nil, err
+	}
+	return c.request(ctx, m, internalPort, lifetime)
+}
+
+// Renew refreshes m, asking for the same external port, and returns the
+// mapping as the gateway now reports it.
+func (c *Client) Renew(ctx context.Context, m *Mapping, lifetime time.Duration) (*Mapping, error) {
+	return c.request(ctx, m, m.External.Port(), lifetime)
+}
+
+// Unmap deletes m from the gateway.
+func (c *Client) Unmap(ctx context.Context, m *Mapping) error {
+	var err error
+	switch m.Protocol {
+	case PCP:
+		_, err = c.pcpMap(ctx, m, 0, 0)
+	case NATPMP:
+		_, err = c.natpmpMap(ctx, m, 0, 0)
+	}
+	return err
+}
+
+func (c *Client) request(ctx context.Context, m *Mapping, suggested uint16, lifetime time.Duration) (*Mapping, error) {
+	if m.Protocol != NATPMP {
+		out, err := c.pcpMap(ctx, m, suggested, lifetime)
+		if !errors.Is(err, errUnsupported) {
+			return out, err
+		}
+	}
+	return c.natpmpMap(ctx, m, suggested, lifetime)
+}
+
+func (c *Client) pcpMap(ctx context.Context, m *Mapping, suggested uint16, lifetime time.Duration) (*Mapping, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(c.gateway))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr()
+
+	req := make([]byte, 60)
+	req[0] = verPCP
+	req[1] = opPCPMap
+	binary.BigEndian.PutUint32(req[4:8], seconds(lifetime))
+	putIP16(req[8:24], client)
+	copy(req[24:36], m.nonce[:])
+	req[36] = protoTCP
+	binary.BigEndian.PutUint16(req[40:42], m.InternalPort)
+	binary.BigEndian.PutUint16(req[42:44], suggested)
+	if m.External.IsValid() {
+		putIP16(req[44:60], m.External.Addr())
+	} else {
+		putIP16(req[44:60], netip.IPv4Unspecified())
+	}
+
+	resp, err := exchange(ctx, conn, req, func(b []byte) bool {
+		// A NAT-PMP-only gateway answers a version it does not know with a
+		// short version 0 error, which we accept here too.
+		if len(b) >= 4 && b[0] == verNATPMP {
+			return true
+		}
+		if len(b) >= 24 && b[0] == verPCP && b[3] == resultUnsuppVersion {
+			return true
+		}
+		return len(b) >= 60 && b[0] == verPCP && b[1] == opReply|opPCPMap && [12]byte(b[24:36]) == m.nonce
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] == verNATPMP {
+		return nil, errUnsupported
+	}
+	if code := resp[3]; code != resultSuccess {
+		if code == resultUnsuppVersion {
+			return nil, errUnsupported
+		}
+		return nil, fmt.Errorf("portmap: pcp map failed: result %d", code)
+	}
+
+	out := *m
+	out.Protocol = PCP
+	out.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[4:8])) * time.Second
+	out.External = netip.AddrPortFrom(ip16(resp[44:60]), binary.BigEndian.Uint16(resp[42:44]))
+	out.Obtained = time.Now()
+	return &out, nil
+}
+
+func (c *Client) natpmpMap(ctx context.Context, m *Mapping, suggested uint16, lifetime time.Duration) (*Mapping, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(c.gateway))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, 12)
+	req[0] = verNATPMP
+	req[1] = opMapTCP
+	binary.BigEndian.PutUint16(req[4:6], m.InternalPort)
+	binary.BigEndian.PutUint16(req[6:8], suggested)
+	binary.BigEndian.PutUint32(req[8:12], seconds(lifetime))
+
+	resp, err := exchange(ctx, conn, req, func(b []byte) bool {
+		return len(b) >= 16 && b[0] == verNATPMP && b[1] == opReply|opMapTCP &&
+			binary.BigEndian.Uint16(b[8:10]) == m.InternalPort
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != resultSuccess {
+		return nil, fmt.Errorf("portmap: nat-pmp map failed: result %d", code)
+	}
+
+	out := *m
+	out.Protocol = NATPMP
+	out.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+	out.Obtained = time.Now()
+	port := binary.BigEndian.Uint16(resp[10:12])
+	if lifetime == 0 {
+		out.External = netip.AddrPortFrom(out.External.Addr(), port)
+		return &out, nil
+	}
+
+	// The map reply carries no address; NAT-PMP has a separate request for it.
+	ext, err := c.natpmpExternalAddr(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	out.External = netip.AddrPortFrom(ext, port)
+	return &out, nil
+}
+
+func (c *Client) natpmpExternalAddr(ctx context.Context, conn *net.UDPConn) (netip.Addr, error) {
+	resp, err := exchange(ctx, conn, []byte{verNATPMP, opExternalAddr}, func(b []byte) bool {
+		return len(b) >= 12 && b[0] == verNATPMP && b[1] == opReply|opExternalAddr
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != resultSuccess {
+		return netip.Addr{}, fmt.Errorf("portmap: nat-pmp external address: result %d", code)
+	}
+	return netip.AddrFrom4([4]byte(resp[8:12])), nil
+}
+
+// exchange sends req until a reply accepted by match arrives, doubling the
+// wait after each silent attempt.
+func exchange(ctx context.Context, conn *net.UDPConn, req []byte, match func([]byte) bool) ([]byte, error) {
+	buf := make([]byte, 1100) // PCP's maximum message size
+	wait := firstRetry
+	for range attempts {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(wait)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			k, err := conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if match(buf[:k]) {
+				return append([]byte(nil), buf[:k]...), nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+	return nil, ErrNoResponse
+}
+
+func seconds(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	return uint32((d + time.Second - 1) / time.Second)
+}
+
+func putIP16(b []byte, a netip.Addr) {
+	a16 := a.As16()
+	copy(b, a16[:])
+}
+
+func ip16(b []byte) netip.Addr {
+	return netip.AddrFrom16([16]byte(b)).Unmap()
+}
+
+// PortOf returns the port of a "host:port" listen address.
+func PortOf(addr string) (uint16, error) {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(port), nil
+}
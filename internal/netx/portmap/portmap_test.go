@@ -0,0 +1,101 @@
+package portmap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMapRenewUnmap_PCP(t *testing.T) {
+	testMapRenewUnmap(t, true, PCP)
+}
+
+func TestMapRenewUnmap_NATPMPFallback(t *testing.T) {
+	testMapRenewUnmap(t, false, NATPMP)
+}
+
+func testMapRenewUnmap(t *testing.T, pcp bool, want Protocol) {
+	gw, err := NewFakeGateway("203.0.113.7", pcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	c, err := NewClient(gw.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m, err := c.Map(ctx, 4001, time.Hour)
+	if err != nil {
+		t.Fatalf("map: %v", err)
+	}
+	if m.Protocol != want {
+		t.Fatalf("protocol = %s, want %s", m.Protocol, want)
+	}
+	if m.External.Addr().String() != "203.0.113.7" || m.External.Port() == 0 {
+		t.Fatalf("external = %s", m.External)
+	}
+	if m.Lifetime != time.Hour {
+		t.Fatalf("lifetime = %s", m.Lifetime)
+	}
+	if got := gw.Mappings()[4001]; got != m.External.Port() {
+		t.Fatalf("gateway maps 4001 -> %d, client thinks %d", got, m.External.Port())
+	}
+
+	r, err := c.Renew(ctx, m, time.Hour)
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if r.External != m.External || r.Protocol != want {
+		t.Fatalf("renewed mapping = %+v, want same as %+v", r, m)
+	}
+
+	if err := c.Unmap(ctx, r); err != nil {
+		t.Fatalf("unmap: %v", err)
+	}
+	if len(gw.Mappings()) != 0 {
+		t.Fatalf("gateway still has mappings: %v", gw.Mappings())
+	}
+}
+
+func TestMap_SilentGateway(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := NewClient(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.Map(ctx, 4001, time.Hour)
+	if err == nil {
+		t.Fatal("map against a silent gateway succeeded")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("err = %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("map ignored the context deadline")
+	}
+}
+
+func TestNewClient_DefaultPort(t *testing.T) {
+	c, err := NewClient("192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Gateway().String() != "192.168.1.1:5351" {
+		t.Fatalf("gateway = %s", c.Gateway())
+	}
+}
@@ -3,6 +3,7 @@ package netx
 import (
 	"net"
 	"sync"
+	"time"
 )
 
 type tcpNetwork struct {
@@ -42,7 +43,24 @@ func (t *tcpNetwork) Accept() (Conn, error) {
 }
 
 func (t *tcpNetwork) Dial(addr Addr) (Conn, error) {
-	c, err := net.Dial("tcp", string(addr))
+	d := net.Dialer{Control: reuseControl}
+	c, err := d.Dial("tcp", string(addr))
+	if err != nil {
+		return nil, err
+	}
+	return &tcpConn{Conn: c}, nil
+}
+
+// DialFrom dials remote from the local endpoint of an earlier Dial. When
+// both sides do this at about the same time, TCP simultaneous open joins the
+// two attempts into one connection.
+func (t *tcpNetwork) DialFrom(local, remote Addr, timeout time.Duration) (Conn, error) {
+	laddr, err := net.ResolveTCPAddr("tcp", string(local))
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{LocalAddr: laddr, Timeout: timeout, Control: reuseControl}
+	c, err := d.Dial("tcp", string(remote))
 	if err != nil {
 		return nil, err
 	}
@@ -67,3 +85,9 @@ type tcpConn struct {
 func (c *tcpConn) RemoteAddr() Addr {
 	return Addr(c.Conn.RemoteAddr().String())
 }
+
+func (c *tcpConn) LocalAddr() Addr {
+	return Addr(c.Conn.LocalAddr().String())
+}
+
+var _ Puncher = (*tcpNetwork)(nil)
@@ -27,6 +27,6 @@ func (n *Node) acceptLoop() {
 			continue
 		}
 
-		go n.handleConn(conn, true)
+		n.goHandleConn(conn, true, "")
 	}
 }
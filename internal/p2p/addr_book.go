@@ -0,0 +1,164 @@
+package p2p
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxAddrBook         = 1024
+	addrBackoffMin      = 30 * time.Second
+	addrBackoffMax      = 30 * time.Minute
+	addrMaxFailures     = 8 // consecutive failed dials before an entry is forgotten
+	addrSuccessFreshFor = 24 * time.Hour
+)
+
+// addrEntry is what we know about dialing one peer. Its addresses live in
+// the peer record book; the address book only keeps the dial history.
+type addrEntry struct {
+	id          string
+	source      string // network ID of the peer that told us about it
+	added       time.Time
+	failures    int // dials since the last successful connection
+	lastAttempt time.Time
+	lastSuccess time.Time
+}
+
+// score ranks entries for dialing; higher is better.
+func (e *addrEntry) score(now time.Time) float64 {
+	s := 1.0
+	if !e.lastSuccess.IsZero() && now.Sub(e.lastSuccess) < addrSuccessFreshFor {
+		s += 2
+	}
+	return s / float64(1+e.failures)
+}
+
+// backoff is how long after a failed dial e is left alone.
+func (e *addrEntry) backoff() time.Duration {
+	if e.failures == 0 {
+		return 0
+	}
+	d := addrBackoffMin << min(e.failures-1, 16)
+	return min(d, addrBackoffMax)
+}
+
+// addrBook holds peers learned through PEX that we may dial later. Nothing
+// is dialed on arrival: dialFromBook picks the best candidates when we are
+// short of peers.
+type addrBook struct {
+	mu      sync.Mutex
+	entries map[string]*addrEntry
+}
+
+func newAddrBook() *addrBook {
+	return &addrBook{entries: make(map[string]*addrEntry)}
+}
+
+// add records that source told us about id. It reports whether id is new.
+func (b *addrBook) add(id, source string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[id]; ok {
+		return false
+	}
+	if len(b.entries) >= maxAddrBook {
+		b.evictLocked(now)
+	}
+	b.entries[id] = &addrEntry{id: id, source: source, added: now}
+	return true
+}
+
+// evictLocked drops the lowest-scoring entry, oldest first on ties.
+func (b *addrBook) evictLocked(now time.Time) {
+	var victim *addrEntry
+	for _, e := range b.entries {
+		if victim == nil || e.score(now) < victim.score(now) ||
+			(e.score(now) == victim.score(now) && e.added.Before(victim.added)) {
+			victim = e
+		}
+	}
+	if victim != nil {
+		delete(b.entries, victim.id)
+	}
+}
+
+func (b *addrBook) remove(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, id)
+}
+
+// attempted notes a dial to id. It counts as a failure until connected
+// clears it, so an unanswered dial backs off like a refused one.
+func (b *addrBook) attempted(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[id]
+	if !ok {
+		return
+	}
+	e.failures++
+	e.lastAttempt = now
+	if e.failures > addrMaxFailures {
+		delete(b.entries, id)
+	}
+}
+
+func (b *addrBook) connected(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[id]; ok {
+		e.failures = 0
+		e.lastSuccess = now
+	}
+}
+
+// candidates returns up to max entries not in backoff for which skip is
+// false, best score first.
+func (b *addrBook) candidates(max int, now time.Time, skip func(id string) bool) []addrEntry {
+	b.mu.Lock()
+	var out []addrEntry
+	for _, e := range b.entries {
+		if now.Sub(e.lastAttempt) < e.backoff() || skip(e.id) {
+			continue
+		}
+		out = append(out, *e)
+	}
+	b.mu.Unlock()
+
+	// Shuffle first so equal scores do not always favour the same peers.
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score(now) > out[j].score(now) })
+	if len(out) > max {
+		out = out[:max]
+	}
+	return out
+}
+
+func (b *addrBook) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// subnetGroup buckets an address by network: /16 for IPv4, /32 for IPv6
+// and the name itself for anything unparsable. Peers sharing a group are
+// likely run by the same operator.
+func subnetGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(16, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(32, 128)).String()
+	}
+}
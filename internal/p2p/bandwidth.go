@@ -0,0 +1,300 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+const (
+	// ShortRateWindow and LongRateWindow are the sliding windows rates are
+	// reported over.
+	ShortRateWindow = 10 * time.Second
+	LongRateWindow  = time.Minute
+
+	rateSlots            = 60  // one-second buckets, covering LongRateWindow
+	maxTrafficProtocols  = 256 // gossip channels are picked by senders
+	trafficOtherProtocol = "other"
+)
+
+// BandwidthConfig limits traffic in bytes per second; zero means unlimited.
+// Limits are enforced by pausing a connection's reader or writer, so a
+// throttled peer backs up into its send queue and its TCP window.
+type BandwidthConfig struct {
+	GlobalIn  int // all peers together
+	GlobalOut int
+	PeerIn    int // each peer
+	PeerOut   int
+	Burst     time.Duration // unused allowance kept, as time at the limit; default 1s
+}
+
+// TrafficStats is a snapshot of one set of counters. Rates are in bytes
+// per second.
+type TrafficStats struct {
+	BytesIn, BytesOut uint64
+	MsgsIn, MsgsOut   uint64
+	RateIn, RateOut   float64 // over ShortRateWindow
+	RateIn1m          float64 // over LongRateWindow
+	RateOut1m         float64
+}
+
+// BandwidthStats is the node's traffic: in total, per connected peer by
+// network ID, and per message type. Gossip is split by channel as
+// "gossip/<channel>".
+type BandwidthStats struct {
+	Total     TrafficStats
+	Peers     map[string]TrafficStats
+	Protocols map[string]TrafficStats
+}
+
+// rateWindow counts bytes in one-second buckets over LongRateWindow.
+type rateWindow struct {
+	slots [rateSlots]uint64
+	head  int64 // unix second of the newest bucket
+}
+
+func (w *rateWindow) advance(sec int64) {
+	if sec <= w.head {
+		return
+	}
+	if sec-w.head >= rateSlots {
+		w.slots = [rateSlots]uint64{}
+	} else {
+		for s := w.head + 1; s <= sec; s++ {
+			w.slots[s%rateSlots] = 0
+		}
+	}
+	w.head = sec
+}
+
+func (w *rateWindow) add(now time.Time, n int) {
+	sec := now.Unix()
+	w.advance(sec)
+	if sec > w.head-rateSlots {
+		w.slots[sec%rateSlots] += uint64(n)
+	}
+}
+
+// rate is the average over the last window, counting the current second.
+func (w *rateWindow) rate(now time.Time, window time.Duration) float64 {
+	w.advance(now.Unix())
+	secs := min(int64(window/time.Second), rateSlots)
+	var sum uint64
+	for s := w.head - secs + 1; s <= w.head; s++ {
+		sum += w.slots[s%rateSlots]
+	}
+	return float64(sum) / float64(secs)
+}
+
+type trafficCounter struct {
+	mu                sync.Mutex
+	bytesIn, bytesOut uint64
+	msgsIn, msgsOut   uint64
+	in, out           rateWindow
+}
+
+func (c *trafficCounter) add(inbound bool, n int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inbound {
+		c.bytesIn += uint64(n)
+		c.msgsIn++
+		c.in.add(now, n)
+	} else {
+		c.bytesOut += uint64(n)
+		c.msgsOut++
+		c.out.add(now, n)
+	}
+}
+
+func (c *trafficCounter) snapshot(now time.Time) TrafficStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return TrafficStats{
+		BytesIn:   c.bytesIn,
+		BytesOut:  c.bytesOut,
+		MsgsIn:    c.msgsIn,
+		MsgsOut:   c.msgsOut,
+		RateIn:    c.in.rate(now, ShortRateWindow),
+		RateOut:   c.out.rate(now, ShortRateWindow),
+		RateIn1m:  c.in.rate(now, LongRateWindow),
+		RateOut1m: c.out.rate(now, LongRateWindow),
+	}
+}
+
+// tokenBucket enforces a byte rate. A nil bucket is unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, burst time.Duration) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = time.Second
+	}
+	b := float64(rate) * burst.Seconds()
+	return &tokenBucket{rate: float64(rate), burst: b, tokens: b, last: time.Now()}
+}
+
+// take charges n bytes and returns how long the caller should pause to get
+// back under the rate. Messages larger than the burst go through, paid for
+// by the pause.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// bandwidth is the node-wide side of accounting and limiting; each peer
+// has its own counter and buckets.
+type bandwidth struct {
+	total     trafficCounter
+	globalIn  *tokenBucket
+	globalOut *tokenBucket
+
+	mu        sync.Mutex
+	protocols map[string]*trafficCounter
+}
+
+func newBandwidth(cfg BandwidthConfig) *bandwidth {
+	return &bandwidth{
+		globalIn:  newTokenBucket(cfg.GlobalIn, cfg.Burst),
+		globalOut: newTokenBucket(cfg.GlobalOut, cfg.Burst),
+		protocols: make(map[string]*trafficCounter),
+	}
+}
+
+func (b *bandwidth) protocol(key string) *trafficCounter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.protocols[key]
+	if !ok {
+		if len(b.protocols) >= maxTrafficProtocols {
+			key = trafficOtherProtocol
+			if c, ok = b.protocols[key]; ok {
+				return c
+			}
+		}
+		c = &trafficCounter{}
+		b.protocols[key] = c
+	}
+	return c
+}
+
+// peerTraffic is a peer's counter and rate limits.
+type peerTraffic struct {
+	counter trafficCounter
+	in      *tokenBucket
+	out     *tokenBucket
+}
+
+func newPeerTraffic(cfg BandwidthConfig) *peerTraffic {
+	return &peerTraffic{
+		in:  newTokenBucket(cfg.PeerIn, cfg.Burst),
+		out: newTokenBucket(cfg.PeerOut, cfg.Burst),
+	}
+}
+
+// trafficKey names the protocol a message is accounted under.
+func trafficKey(env proto.Envelope) string {
+	if env.Type != proto.MsgGossip {
+		return string(env.Type)
+	}
+	var g struct {
+		Channel string `json:"channel"`
+	}
+	if json.Unmarshal(env.Payload, &g) != nil || g.Channel == "" {
+		return string(env.Type)
+	}
+	return string(env.Type) + "/" + g.Channel
+}
+
+// account counts one message of size bytes to or from p and returns how
+// long to pause p's reader or writer to respect the rate limits.
+func (n *Node) account(p *peer, inbound bool, env proto.Envelope, size int) time.Duration {
+	now := time.Now()
+	n.bw.total.add(inbound, size, now)
+	n.bw.protocol(trafficKey(env)).add(inbound, size, now)
+	p.traffic.counter.add(inbound, size, now)
+	if inbound {
+		return max(p.traffic.in.take(size, now), n.bw.globalIn.take(size, now))
+	}
+	return max(p.traffic.out.take(size, now), n.bw.globalOut.take(size, now))
+}
+
+// throttle sleeps for d unless ctx ends first.
+func throttle(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// BandwidthStats returns the traffic counters. Peers only lists peers
+// connected now; their counters start over when they reconnect.
+func (n *Node) BandwidthStats() BandwidthStats {
+	now := time.Now()
+	st := BandwidthStats{
+		Total:     n.bw.total.snapshot(now),
+		Peers:     make(map[string]TrafficStats),
+		Protocols: make(map[string]TrafficStats),
+	}
+
+	n.mu.RLock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.mu.RUnlock()
+	for _, p := range peers {
+		st.Peers[p.id] = p.traffic.counter.snapshot(now)
+	}
+
+	n.bw.mu.Lock()
+	protocols := make(map[string]*trafficCounter, len(n.bw.protocols))
+	for k, c := range n.bw.protocols {
+		protocols[k] = c
+	}
+	n.bw.mu.Unlock()
+	for k, c := range protocols {
+		st.Protocols[k] = c.snapshot(now)
+	}
+	return st
+}
+
+// countingWriter counts the bytes written through it. Only the peer's
+// write loop uses it once the session is up.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	m, err := c.w.Write(b)
+	c.n += int64(m)
+	return m, err
+}
@@ -0,0 +1,92 @@
+package p2p
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestRateWindow_SlidesOut(t *testing.T) {
+	var w rateWindow
+	t0 := time.Unix(1_000_000, 0)
+	w.add(t0, 600)
+	if got := w.rate(t0, ShortRateWindow); got != 60 {
+		t.Fatalf("rate = %v; want 60", got)
+	}
+	if got := w.rate(t0.Add(ShortRateWindow), ShortRateWindow); got != 0 {
+		t.Fatalf("rate after the window = %v; want 0", got)
+	}
+	if got := w.rate(t0.Add(ShortRateWindow), LongRateWindow); got != 10 {
+		t.Fatalf("long rate = %v; want 10", got)
+	}
+	if got := w.rate(t0.Add(2*LongRateWindow), LongRateWindow); got != 0 {
+		t.Fatalf("long rate much later = %v; want 0", got)
+	}
+}
+
+func TestTokenBucket_PausesOverRate(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(1000, time.Second)
+	b.last = now
+	if d := b.take(1000, now); d != 0 {
+		t.Fatalf("burst-sized take paused %s", d)
+	}
+	if d := b.take(500, now); d != 500*time.Millisecond {
+		t.Fatalf("pause = %s; want 500ms", d)
+	}
+	if d := b.take(0, now.Add(500*time.Millisecond)); d != 0 {
+		t.Fatalf("still paused after refilling: %s", d)
+	}
+	if d := (*tokenBucket)(nil).take(1<<20, now); d != 0 {
+		t.Fatalf("unlimited bucket paused %s", d)
+	}
+}
+
+func TestBandwidth_CountsPeersAndChannels(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+	waitPeers(t, b, 1, 3*time.Second)
+
+	a.Broadcast(proto.Gossip{ID: NewMsgID(), Channel: "chat", Body: json.RawMessage(`"hello"`)})
+	waitCond(t, "b to count the gossip", func() bool {
+		return b.BandwidthStats().Protocols["gossip/chat"].MsgsIn == 1
+	})
+
+	as, bs := a.BandwidthStats(), b.BandwidthStats()
+	if got, want := bs.Protocols["gossip/chat"].BytesIn, as.Protocols["gossip/chat"].BytesOut; got != want || got == 0 {
+		t.Fatalf("gossip bytes: b got %d, a sent %d", got, want)
+	}
+	if st := bs.Peers[a.ID()]; st.BytesIn == 0 || st.RateIn == 0 {
+		t.Fatalf("b's counters for a: %+v", st)
+	}
+	if bs.Total.MsgsIn < bs.Peers[a.ID()].MsgsIn {
+		t.Fatalf("total %d below the one peer's %d", bs.Total.MsgsIn, bs.Peers[a.ID()].MsgsIn)
+	}
+}
+
+func TestBandwidth_PeerOutLimitSlowsWriter(t *testing.T) {
+	const limit = 20_000
+	a := newTestNode(t, "a", WithBandwidth(BandwidthConfig{PeerOut: limit, Burst: 100 * time.Millisecond}))
+	b := newTestNode(t, "b")
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+	waitPeers(t, b, 1, 3*time.Second)
+
+	body := json.RawMessage(`"` + strings.Repeat("x", 2000) + `"`)
+
+	start := time.Now()
+	for range 20 { // about 40KB: two seconds at the limit
+		a.Broadcast(proto.Gossip{ID: NewMsgID(), Channel: "bulk", Body: body})
+	}
+	waitCond(t, "b to receive the burst", func() bool {
+		return b.BandwidthStats().Protocols["gossip/bulk"].MsgsIn == 20
+	})
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("40KB at %d B/s arrived in %s", limit, d)
+	}
+}
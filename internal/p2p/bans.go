@@ -0,0 +1,43 @@
+package p2p
+
+import (
+	"errors"
+	"time"
+)
+
+var errPeerBanned = errors.New("peer is banned")
+
+// Ban disconnects peerID and refuses its connections for d.
+func (n *Node) Ban(peerID string, d time.Duration, reason string) {
+	n.mu.Lock()
+	if n.banned == nil {
+		n.banned = make(map[string]time.Time)
+	}
+	n.banned[peerID] = time.Now().Add(d)
+	p := n.peers[peerID]
+	n.mu.Unlock()
+
+	n.Logf("banned %s for %s: %s", peerID, d, reason)
+	n.emit(Event{Type: EventPeerBanned, PeerID: peerID, Detail: reason})
+	if p != nil {
+		n.dropPeer(p)
+	}
+}
+
+// Unban lifts a ban early.
+func (n *Node) Unban(peerID string) {
+	n.mu.Lock()
+	delete(n.banned, peerID)
+	n.mu.Unlock()
+}
+
+func (n *Node) isBanned(peerID string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	until, ok := n.banned[peerID]
+	if ok && time.Now().After(until) {
+		delete(n.banned, peerID)
+		ok = false
+	}
+	return ok
+}
@@ -0,0 +1,260 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+const (
+	circuitChunk      = 16 << 10 // max bytes per CircuitData
+	circuitAddrPrefix = "circuit/"
+	circuitPollGap    = 5 * time.Millisecond
+)
+
+var errCircuitCongested = errors.New("circuit: send queue full")
+
+// circuitConn is the stream a relayed peer runs over. It only exists so
+// establishPeer can tell relayed connections from direct ones.
+type circuitConn struct {
+	netx.Conn
+}
+
+func (c *circuitConn) SetReadDeadline(t time.Time) error {
+	if dc, ok := c.Conn.(deadlineConn); ok {
+		return dc.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// circuitEnd is our end of a relayed circuit: the seed it runs through and
+// the pipe end whose bytes we ship to it as CircuitData.
+type circuitEnd struct {
+	id   string
+	seed *peer
+	pump netx.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+type circuitTable struct {
+	mu    sync.Mutex
+	ends  map[string]*circuitEnd   // circuits we are an end of
+	relay map[string]*relayCircuit // circuits we relay (seeds only)
+}
+
+func newCircuitTable() *circuitTable {
+	return &circuitTable{
+		ends:  make(map[string]*circuitEnd),
+		relay: make(map[string]*relayCircuit),
+	}
+}
+
+// ConnectViaRelay opens a circuit to userID through a connected seed and
+// runs the normal Noise handshake over it. Like ConnectTo it returns once
+// the attempt is under way; the peer shows up when the handshake completes.
+// The seed enforces byte, duration and concurrency limits on the circuit.
+func (n *Node) ConnectViaRelay(userID string) error {
+	seeds := n.seedPeers()
+	if len(seeds) == 0 {
+		return errNoSeeds
+	}
+	sort.SliceStable(seeds, func(i, j int) bool { return rttLess(seeds[i].ka.rtt(), seeds[j].ka.rtt()) })
+	seed := seeds[0]
+
+	id := NewMsgID()
+	end, conn := n.newCircuitEnd(id, seed)
+	if end == nil {
+		return errors.New("circuit: duplicate id")
+	}
+	open := proto.Envelope{
+		Type:    proto.MsgCircuitOpen,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.CircuitOpen{ID: id, ToUserID: userID}),
+	}
+	if !n.pushCircuit(seed, open) {
+		n.closeCircuitEnd(end, "", false)
+		return errCircuitCongested
+	}
+
+	n.Logf("circuit %s: dialing user %s via seed %s", id, userID, seed.id)
+	n.spawn("circuit pump", func() { n.runCircuitPump(end) })
+	n.goHandleConn(conn, false, "")
+	return nil
+}
+
+// newCircuitEnd registers a circuit end and returns the connection the
+// relayed peer will run over.
+func (n *Node) newCircuitEnd(id string, seed *peer) (*circuitEnd, netx.Conn) {
+	peerSide, pumpSide := netx.Pipe("", netx.Addr(circuitAddrPrefix+id))
+	end := &circuitEnd{id: id, seed: seed, pump: pumpSide, done: make(chan struct{})}
+
+	n.circuits.mu.Lock()
+	if _, dup := n.circuits.ends[id]; dup {
+		n.circuits.mu.Unlock()
+		return nil, nil
+	}
+	n.circuits.ends[id] = end
+	n.circuits.mu.Unlock()
+	n.emit(Event{Type: EventCircuitOpened, PeerID: seed.id, Detail: id})
+
+	n.spawn("circuit watch", func() {
+		select {
+		case <-seed.ctx.Done():
+			n.closeCircuitEnd(end, "", false)
+		case <-end.done:
+		}
+	})
+	return end, &circuitConn{Conn: peerSide}
+}
+
+func (n *Node) closeCircuitEnd(end *circuitEnd, reason string, notify bool) {
+	end.once.Do(func() {
+		n.circuits.mu.Lock()
+		if n.circuits.ends[end.id] == end {
+			delete(n.circuits.ends, end.id)
+		}
+		n.circuits.mu.Unlock()
+
+		close(end.done)
+		_ = end.pump.Close()
+		if notify {
+			n.pushCircuit(end.seed, proto.Envelope{
+				Type:    proto.MsgCircuitClose,
+				FromID:  n.id.ID,
+				Payload: proto.MustMarshal(proto.CircuitClose{ID: end.id, Reason: reason}),
+			})
+		}
+		n.emit(Event{Type: EventCircuitClosed, PeerID: end.seed.id, Detail: end.id, Err: reason})
+	})
+}
+
+// runCircuitPump ships whatever the relayed peer writes to the seed.
+func (n *Node) runCircuitPump(end *circuitEnd) {
+	defer n.closeCircuitEnd(end, "closed", true)
+
+	buf := make([]byte, circuitChunk)
+	for {
+		k, err := end.pump.Read(buf)
+		if k > 0 {
+			if !n.waitCircuitRoom(end) {
+				return
+			}
+			env := proto.Envelope{
+				Type:    proto.MsgCircuitData,
+				FromID:  n.id.ID,
+				Payload: proto.MustMarshal(proto.CircuitData{ID: end.id, Data: buf[:k]}),
+			}
+			if !n.pushCircuit(end.seed, env) {
+				n.Logf("circuit %s: %v", end.id, errCircuitCongested)
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitCircuitRoom holds the pump back while the seed's queue is half full,
+// so a fast writer slows down instead of overflowing it.
+func (n *Node) waitCircuitRoom(end *circuitEnd) bool {
+	limit := end.seed.sendq.cfg[ClassSync].Capacity / 2
+	for end.seed.sendq.queued(ClassSync) >= max(limit, 1) {
+		select {
+		case <-end.done:
+			return false
+		case <-end.seed.ctx.Done():
+			return false
+		case <-time.After(circuitPollGap):
+		}
+	}
+	return true
+}
+
+// pushCircuit queues circuit traffic. Unlike sendAsync it reports overflow:
+// a lost chunk corrupts the stream, so the caller must tear the circuit down.
+func (n *Node) pushCircuit(p *peer, env proto.Envelope) bool {
+	select {
+	case <-p.ctx.Done():
+		return false
+	default:
+	}
+	switch p.sendq.push(ClassSync, env) {
+	case pushQueued:
+		return true
+	case pushDisconnect:
+		go n.dropPeer(p)
+	}
+	return false
+}
+
+func (n *Node) handleCircuitOpen(p *peer, env proto.Envelope) {
+	var open proto.CircuitOpen
+	if err := json.Unmarshal(env.Payload, &open); err != nil || open.ID == "" {
+		n.Logf("bad circuit open from %s", p.id)
+		return
+	}
+	if n.cfg.IsSeed {
+		n.relayCircuitOpen(p, open)
+		return
+	}
+	if !p.isSeed {
+		return
+	}
+
+	end, conn := n.newCircuitEnd(open.ID, p)
+	if end == nil {
+		return
+	}
+	n.Logf("circuit %s: inbound from user %s via seed %s", open.ID, open.FromUserID, p.id)
+	n.spawn("circuit pump", func() { n.runCircuitPump(end) })
+	n.goHandleConn(conn, true, "")
+}
+
+func (n *Node) handleCircuitData(p *peer, env proto.Envelope) {
+	var data proto.CircuitData
+	if err := json.Unmarshal(env.Payload, &data); err != nil {
+		n.Logf("bad circuit data from %s: %v", p.id, err)
+		return
+	}
+	if n.cfg.IsSeed {
+		n.relayCircuitData(p, data, env)
+		return
+	}
+
+	n.circuits.mu.Lock()
+	end := n.circuits.ends[data.ID]
+	n.circuits.mu.Unlock()
+	if end == nil || end.seed != p {
+		return
+	}
+	if _, err := end.pump.Write(data.Data); err != nil {
+		n.closeCircuitEnd(end, "closed", true)
+	}
+}
+
+func (n *Node) handleCircuitClose(p *peer, env proto.Envelope) {
+	var c proto.CircuitClose
+	if err := json.Unmarshal(env.Payload, &c); err != nil {
+		return
+	}
+	if n.cfg.IsSeed {
+		n.relayCircuitClose(p, c)
+		return
+	}
+
+	n.circuits.mu.Lock()
+	end := n.circuits.ends[c.ID]
+	n.circuits.mu.Unlock()
+	if end == nil || end.seed != p {
+		return
+	}
+	n.Logf("circuit %s closed by seed: %s", c.ID, c.Reason)
+	n.closeCircuitEnd(end, c.Reason, false)
+}
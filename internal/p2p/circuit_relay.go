@@ -0,0 +1,202 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// RelayConfig limits the circuits a seed relays.
+type RelayConfig struct {
+	MaxCircuits        int           // concurrent circuits through this seed
+	MaxCircuitsPerPeer int           // concurrent circuits one peer may be an end of
+	MaxBytes           int64         // bytes relayed per circuit, both directions combined
+	MaxDuration        time.Duration // lifetime of one circuit
+}
+
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		MaxCircuits:        128,
+		MaxCircuitsPerPeer: 8,
+		MaxBytes:           8 << 20,
+		MaxDuration:        10 * time.Minute,
+	}
+}
+
+func (n *Node) relayConfig() RelayConfig {
+	cfg, def := n.cfg.Relay, DefaultRelayConfig()
+	if cfg.MaxCircuits <= 0 {
+		cfg.MaxCircuits = def.MaxCircuits
+	}
+	if cfg.MaxCircuitsPerPeer <= 0 {
+		cfg.MaxCircuitsPerPeer = def.MaxCircuitsPerPeer
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = def.MaxBytes
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = def.MaxDuration
+	}
+	return cfg
+}
+
+// relayCircuit is a circuit a seed splices between two connected peers.
+type relayCircuit struct {
+	id    string
+	a, b  *peer // a opened the circuit
+	bytes int64 // guarded by circuitTable.mu
+
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *relayCircuit) other(p *peer) *peer {
+	switch p {
+	case c.a:
+		return c.b
+	case c.b:
+		return c.a
+	}
+	return nil
+}
+
+func (n *Node) relayCircuitOpen(from *peer, open proto.CircuitOpen) {
+	cfg := n.relayConfig()
+	reject := func(reason string) {
+		n.Logf("circuit %s from %s rejected: %s", open.ID, from.id, reason)
+		n.pushCircuit(from, proto.Envelope{
+			Type:    proto.MsgCircuitClose,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(proto.CircuitClose{ID: open.ID, Reason: reason}),
+		})
+	}
+
+	n.mu.RLock()
+	target := n.natByUserID[open.ToUserID]
+	n.mu.RUnlock()
+	if target == nil || target == from || from.userID == "" {
+		reject("unknown target")
+		return
+	}
+
+	c := &relayCircuit{id: open.ID, a: from, b: target, done: make(chan struct{})}
+
+	n.circuits.mu.Lock()
+	if _, dup := n.circuits.relay[open.ID]; dup {
+		n.circuits.mu.Unlock()
+		reject("duplicate id")
+		return
+	}
+	if len(n.circuits.relay) >= cfg.MaxCircuits {
+		n.circuits.mu.Unlock()
+		reject("relay full")
+		return
+	}
+	perFrom, perTarget := 0, 0
+	for _, rc := range n.circuits.relay {
+		if rc.a == from || rc.b == from {
+			perFrom++
+		}
+		if rc.a == target || rc.b == target {
+			perTarget++
+		}
+	}
+	if perFrom >= cfg.MaxCircuitsPerPeer || perTarget >= cfg.MaxCircuitsPerPeer {
+		n.circuits.mu.Unlock()
+		reject("too many circuits for peer")
+		return
+	}
+	n.circuits.relay[open.ID] = c
+	n.circuits.mu.Unlock()
+	n.emit(Event{Type: EventRelayCircuitOpened, PeerID: from.id, UserID: open.ToUserID, Detail: c.id})
+
+	fwd := proto.Envelope{
+		Type:    proto.MsgCircuitOpen,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.CircuitOpen{ID: open.ID, FromUserID: from.userID}),
+	}
+	if !n.pushCircuit(target, fwd) {
+		n.closeRelayCircuit(c, "target congested", nil)
+		return
+	}
+	n.Logf("circuit %s: relaying %s <-> %s", c.id, from.id, target.id)
+
+	n.spawn("relay circuit watch", func() {
+		t := time.NewTimer(cfg.MaxDuration)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			n.closeRelayCircuit(c, "duration limit", nil)
+		case <-c.a.ctx.Done():
+			n.closeRelayCircuit(c, "peer gone", c.a)
+		case <-c.b.ctx.Done():
+			n.closeRelayCircuit(c, "peer gone", c.b)
+		case <-c.done:
+		}
+	})
+}
+
+func (n *Node) relayCircuitData(from *peer, data proto.CircuitData, env proto.Envelope) {
+	maxBytes := n.relayConfig().MaxBytes
+
+	n.circuits.mu.Lock()
+	c := n.circuits.relay[data.ID]
+	var to *peer
+	over := false
+	if c != nil {
+		to = c.other(from)
+		if to != nil {
+			c.bytes += int64(len(data.Data))
+			over = c.bytes > maxBytes
+		}
+	}
+	n.circuits.mu.Unlock()
+
+	if to == nil {
+		return
+	}
+	if over {
+		n.closeRelayCircuit(c, "byte limit", nil)
+		return
+	}
+	env.FromID = n.id.ID
+	if !n.pushCircuit(to, env) {
+		n.closeRelayCircuit(c, "congested", nil)
+	}
+}
+
+func (n *Node) relayCircuitClose(from *peer, cl proto.CircuitClose) {
+	n.circuits.mu.Lock()
+	c := n.circuits.relay[cl.ID]
+	n.circuits.mu.Unlock()
+	if c == nil || c.other(from) == nil {
+		return
+	}
+	n.closeRelayCircuit(c, cl.Reason, from)
+}
+
+// closeRelayCircuit unregisters c and tells both ends, except skip.
+func (n *Node) closeRelayCircuit(c *relayCircuit, reason string, skip *peer) {
+	c.once.Do(func() {
+		n.circuits.mu.Lock()
+		if n.circuits.relay[c.id] == c {
+			delete(n.circuits.relay, c.id)
+		}
+		n.circuits.mu.Unlock()
+		close(c.done)
+
+		env := proto.Envelope{
+			Type:    proto.MsgCircuitClose,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(proto.CircuitClose{ID: c.id, Reason: reason}),
+		}
+		for _, p := range []*peer{c.a, c.b} {
+			if p != skip {
+				n.pushCircuit(p, env)
+			}
+		}
+		n.Logf("circuit %s closed: %s", c.id, reason)
+		n.emit(Event{Type: EventRelayCircuitClosed, PeerID: c.a.id, UserID: c.b.userID, Detail: c.id, Err: reason})
+	})
+}
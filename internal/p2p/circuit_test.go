@@ -0,0 +1,120 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+// natedTrio returns a public seed and two nodes behind separate NATs, both
+// registered with the seed.
+func natedTrio(t *testing.T, relay RelayConfig) (seed, a, b *Node) {
+	t.Helper()
+	hub := netx.NewMemHub()
+	seed = newTestNode(t, "seed", WithSeed(true), WithRelay(relay), WithNetwork(hub.NewNetwork("192.0.2.1")))
+	a = newTestNode(t, "A", WithNetwork(hub.NewNAT("203.0.113.10").NewNetwork("10.0.0.2")), WithBootstraps(seed.ListenAddr()))
+	b = newTestNode(t, "B", WithNetwork(hub.NewNAT("198.51.100.20").NewNetwork("10.0.1.2")), WithBootstraps(seed.ListenAddr()))
+	waitForNatRegistry(t, seed, 2)
+	return seed, a, b
+}
+
+func relayTo(t *testing.T, from, to *Node) {
+	t.Helper()
+	userID := hex.EncodeToString(to.Identity().SignPub)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// Retry until from has learned which peer is the seed from Identify.
+		if err := from.ConnectViaRelay(userID); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ConnectViaRelay never found a seed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func waitCond(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCircuit_RelayedPeerLooksOrdinary(t *testing.T) {
+	_, a, b := natedTrio(t, RelayConfig{})
+	relayTo(t, a, b)
+
+	waitCond(t, "relayed connection", func() bool { return a.hasPeer(b.ID()) && b.hasPeer(a.ID()) })
+
+	for _, ps := range a.SnapshotPeers() {
+		if ps.NetworkID == b.ID() && !ps.Relayed {
+			t.Fatalf("peer B should be marked relayed: %+v", ps)
+		}
+	}
+
+	want := proto.MessageType("circuit_test")
+	got := make(chan Inbound, 1)
+	if err := b.Handle(want, func(in Inbound) { got <- in }); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SendToPeer(b.ID(), proto.Envelope{Type: want, FromID: a.ID(), Payload: []byte(`{"x":1}`)}); err != nil {
+		t.Fatalf("SendToPeer: %v", err)
+	}
+	select {
+	case in := <-got:
+		if in.PeerID != a.ID() {
+			t.Fatalf("envelope arrived from %s, want %s", in.PeerID, a.ID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("B never received the envelope over the circuit")
+	}
+}
+
+func TestCircuit_ByteLimitClosesCircuit(t *testing.T) {
+	seed, a, b := natedTrio(t, RelayConfig{MaxBytes: 64 << 10})
+	relayTo(t, a, b)
+	waitCond(t, "relayed connection", func() bool { return a.hasPeer(b.ID()) })
+
+	big := proto.MustMarshal(strings.Repeat("x", 128<<10))
+	_ = a.SendToPeer(b.ID(), proto.Envelope{Type: "circuit_test", FromID: a.ID(), Payload: big})
+
+	waitCond(t, "circuit teardown", func() bool { return !a.hasPeer(b.ID()) && !b.hasPeer(a.ID()) })
+	seed.circuits.mu.Lock()
+	left := len(seed.circuits.relay)
+	seed.circuits.mu.Unlock()
+	if left != 0 {
+		t.Fatalf("seed still relays %d circuits", left)
+	}
+}
+
+func TestCircuit_PerPeerLimit(t *testing.T) {
+	seed, a, b := natedTrio(t, RelayConfig{MaxCircuitsPerPeer: 1})
+	relayTo(t, a, b)
+	waitCond(t, "relayed connection", func() bool { return a.hasPeer(b.ID()) })
+
+	// A second circuit for A is refused and A's end is torn down.
+	relayTo(t, a, b)
+	waitCond(t, "rejected circuit end to close", func() bool {
+		a.circuits.mu.Lock()
+		defer a.circuits.mu.Unlock()
+		return len(a.circuits.ends) == 1
+	})
+	seed.circuits.mu.Lock()
+	n := len(seed.circuits.relay)
+	seed.circuits.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("seed relays %d circuits, want 1", n)
+	}
+	if !a.hasPeer(b.ID()) {
+		t.Fatalf("rejection must not disturb the existing circuit")
+	}
+}
@@ -6,20 +6,41 @@ import (
 
 // ConnectTo allows manual dialing (used by discovery/bootstraps).
 func (n *Node) ConnectTo(addr netx.Addr) error {
+	return n.connectExpecting(addr, "")
+}
+
+// connectExpecting dials addr and keeps the connection only if the Noise
+// handshake shows network ID want ("" accepts anyone). Addresses learned
+// from others go through here, so a forged record cannot get us talking to
+// an impostor.
+func (n *Node) connectExpecting(addr netx.Addr, want string) error {
 	conn, err := n.cfg.Network.Dial(addr)
 	if err != nil {
 		n.Logf("dial %s failed: %v", addr, err)
 		return err
 	}
-	go n.handleConn(conn, false)
+	n.goHandleConn(conn, false, want)
 	return nil
 }
 
-func (n *Node) handleConn(rawConn netx.Conn, inbound bool) {
-	p, secureCloser, err := n.establishPeer(rawConn, inbound)
+// goHandleConn runs handleConn for a new raw connection on a goroutine
+// that Stop waits for.
+func (n *Node) goHandleConn(rawConn netx.Conn, inbound bool, want string) {
+	n.spawn("conn", func() { n.handleConn(rawConn, inbound, want) })
+}
+
+func (n *Node) handleConn(rawConn netx.Conn, inbound bool, want string) {
+	if !n.trackConn(rawConn) {
+		_ = rawConn.Close()
+		return
+	}
+	defer n.untrackConn(rawConn)
+
+	p, secureCloser, err := n.establishPeer(rawConn, inbound, want)
 	if err != nil {
 		n.Logf("conn setup failed (inbound=%v): %v", inbound, err)
 		_ = rawConn.Close()
+		n.emit(Event{Type: EventHandshakeFailed, PeerAddr: string(rawConn.RemoteAddr()), Err: err.Error()})
 		return
 	}
 	if p == nil {
@@ -27,12 +48,18 @@ func (n *Node) handleConn(rawConn netx.Conn, inbound bool) {
 		return
 	}
 	defer func() {
-		n.removePeer(p.id)
+		n.dropPeer(p)
 		if secureCloser != nil {
 			_ = secureCloser.Close()
 		}
 	}()
 
+	if !n.isRegistered(p) {
+		// Lost the duplicate-connection tie-break: only drain what is in flight.
+		n.runPeerReadLoop(p)
+		return
+	}
+
 	if !n.cfg.IsSeed {
 		if err := n.sendNatRegister(p); err != nil {
 			n.Logf("send NAT register to %s failed: %v", p.id, err)
@@ -45,8 +72,10 @@ func (n *Node) handleConn(rawConn netx.Conn, inbound bool) {
 
 	n.Logf("connected to peer id=%s name=%s addr=%s inbound=%v", p.id, p.name, p.addr, inbound)
 
-	if err := n.sendPeerList(p); err != nil {
-		n.Logf("send peer list to %s failed: %v", p.id, err)
+	n.spawn("keepalive", func() { n.keepaliveLoop(p) })
+
+	if !p.relayed && n.PeerCount() < n.pexConfig().TargetPeers {
+		n.spawn("pex request", func() { n.requestPeers(p.id) })
 	}
 
 	n.runPeerReadLoop(p)
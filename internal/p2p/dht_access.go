@@ -1,9 +1,36 @@
 package p2p
 
-import "p2p-park/internal/dht"
+import (
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
 
 // dhtAccessor returns the node's DHT engine.
 // It is intentionally unexported; tests in package p2p may use it.
 func (n *Node) dhtAccessor() *dht.DHT {
 	return n.dht
 }
+
+// routingChanged turns routing table changes into events.
+func (n *Node) routingChanged(c dht.RoutingChange) {
+	detail := "evicted"
+	if c.Added {
+		detail = "added"
+	}
+	n.emit(Event{Type: EventRoutingChanged, PeerID: c.Node.PeerID, PeerAddr: c.Node.Addr, PeerName: c.Node.Name, Detail: detail})
+}
+
+// dhtPeerRecords lets the DHT attach and check signed peer records.
+type dhtPeerRecords struct{ n *Node }
+
+func (r dhtPeerRecords) Lookup(peerID string) *proto.PeerRecord {
+	rec, ok := r.n.PeerRecordFor(peerID)
+	if !ok {
+		return nil
+	}
+	return &rec
+}
+
+func (r dhtPeerRecords) Accept(rec *proto.PeerRecord) error {
+	return r.n.notePeerRecord(rec)
+}
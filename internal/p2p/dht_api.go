@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
+
+// DefaultDHTValueTTL is how long values stored through the DHT methods
+// below live unless republished.
+const DefaultDHTValueTTL = 24 * time.Hour
+
+// DefaultDHTReadQuorum is how many copies of a mutable value DHTGet
+// compares before trusting its Seq.
+const DefaultDHTReadQuorum = 3
+
+// DHTPut stores value in the DHT under its sha256 hash.
+func (n *Node) DHTPut(ctx context.Context, value []byte) ([32]byte, error) {
+	return n.dht.PutImmutable(ctx, n, value, DefaultDHTValueTTL)
+}
+
+// DHTGet fetches the record stored under key. Mutable values are read
+// from DefaultDHTReadQuorum replicas when that many can be reached.
+func (n *Node) DHTGet(ctx context.Context, key [32]byte) (*proto.DHTRecord, bool, error) {
+	return n.dht.GetValueWithOpts(ctx, n, key, dht.GetValueOpts{Quorum: DefaultDHTReadQuorum})
+}
+
+// DHTGetWithOpts fetches the record stored under key with a read quorum;
+// see dht.GetValueOpts.
+func (n *Node) DHTGetWithOpts(ctx context.Context, key [32]byte, opts dht.GetValueOpts) (*proto.DHTRecord, bool, error) {
+	return n.dht.GetValueWithOpts(ctx, n, key, opts)
+}
+
+// DHTPutMutable signs value with our user key and stores it under
+// sha256(userPub || name). The sequence number is one past the newest
+// version a quorum read can find, so the new value replaces it everywhere.
+func (n *Node) DHTPutMutable(ctx context.Context, name string, value []byte) ([32]byte, uint64, error) {
+	key := dht.KeyFromMutable(n.id.SignPub, name)
+	seq := uint64(1)
+	if rec, ok, _ := n.dht.GetValueWithOpts(ctx, n, key, dht.GetValueOpts{Quorum: DefaultDHTReadQuorum}); ok && bytes.Equal(rec.PubKey, n.id.SignPub) {
+		seq = rec.Seq + 1
+	}
+	key, err := n.dht.PutMutable(ctx, n, n.id.SignPriv, name, value, seq, DefaultDHTValueTTL)
+	return key, seq, err
+}
+
+// DHTBuckets returns the routing table's non-empty buckets.
+func (n *Node) DHTBuckets() []dht.BucketInfo {
+	return n.dht.Routing().Buckets()
+}
+
+// DHTLookup runs an iterative FIND_NODE for a 64-hex-digit target and
+// returns the closest nodes found. trace, if not nil, sees every query.
+func (n *Node) DHTLookup(target string, trace func(dht.LookupHop)) ([]proto.DHTNode, error) {
+	cfg := dht.DefaultLookupConfig()
+	cfg.Trace = trace
+	return n.dht.IterativeFindNode(n, target, cfg)
+}
+
+// DHTLookupDisjoint is DHTLookup over paths disjoint paths, which a Sybil
+// cluster capturing one path cannot steer; see dht.DisjointFindNode.
+func (n *Node) DHTLookupDisjoint(target string, paths int, trace func(dht.LookupHop)) (dht.DisjointLookup, error) {
+	cfg := dht.DefaultLookupConfig()
+	cfg.DisjointPaths = paths
+	cfg.Trace = trace
+	return n.dht.DisjointFindNode(n, target, cfg)
+}
+
+// DHTProvide announces us as a provider of key, and keeps announcing it
+// until DHTStopProviding.
+func (n *Node) DHTProvide(ctx context.Context, key [32]byte) error {
+	nodeID, err := dht.NodeIDFromPeerID(n.id.ID)
+	if err != nil {
+		return err
+	}
+	// Each re-announcement carries our record as it is then, re-signed if
+	// it has aged or our address moved.
+	self := func() proto.DHTNode {
+		rec := n.SelfRecord()
+		return proto.DHTNode{
+			NodeID: nodeID.Hex(),
+			PeerID: n.id.ID,
+			Addr:   string(n.advertisedAddr()),
+			Name:   n.cfg.Name,
+			Record: &rec,
+		}
+	}
+	return n.dht.Provide(ctx, n, key, self, dht.DefaultPublishConfig())
+}
+
+// DHTStopProviding stops re-announcing key.
+func (n *Node) DHTStopProviding(key [32]byte) {
+	n.dht.StopProviding(key)
+}
+
+// DHTFindProviders looks up peers that announced they provide key.
+func (n *Node) DHTFindProviders(ctx context.Context, key [32]byte) ([]proto.DHTNode, error) {
+	return n.dht.IterativeFindProviders(ctx, n, key, dht.DefaultProviderLookupConfig())
+}
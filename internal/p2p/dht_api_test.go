@@ -0,0 +1,95 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+)
+
+func TestDHTPutMutable_IncrementsSeq(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for want := uint64(1); want <= 2; want++ {
+		_, seq, err := a.DHTPutMutable(ctx, "status", []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != want {
+			t.Fatalf("seq = %d; want %d", seq, want)
+		}
+	}
+
+	key := dht.KeyFromMutable(a.Identity().SignPub, "status")
+	waitCond(t, "b to hold seq 2", func() bool {
+		rec, ok, _ := b.DHTGet(ctx, key)
+		return ok && rec.Seq == 2
+	})
+}
+
+func TestDHTLookup_TracesHops(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	c := newTestNode(t, "c")
+	connectTriangle(t, a, b, c)
+	waitPeers(t, a, 2, 3*time.Second)
+	waitCond(t, "records for the triangle", func() bool {
+		_, ab := a.PeerRecordFor(b.ID())
+		_, bc := b.PeerRecordFor(c.ID())
+		return ab && bc
+	})
+
+	target, _ := dht.NodeIDFromPeerID(c.ID())
+	var hops []dht.LookupHop
+	nodes, err := a.DHTLookup(target.Hex(), func(h dht.LookupHop) { hops = append(hops, h) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := 0
+	for _, h := range hops {
+		if h.Err == nil && h.Returned > 0 {
+			answered++
+		}
+	}
+	if answered == 0 {
+		t.Fatalf("no answered hops traced: %+v", hops)
+	}
+	if len(nodes) == 0 || nodes[0].PeerID != c.ID() {
+		t.Fatalf("closest = %+v; want c first", nodes)
+	}
+}
+
+func TestDHTProvide_FoundByThirdPeer(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	c := newTestNode(t, "c")
+	connectTriangle(t, a, b, c)
+	waitPeers(t, a, 2, 3*time.Second)
+	waitCond(t, "records for the triangle", func() bool {
+		_, ab := a.PeerRecordFor(b.ID())
+		_, ac := a.PeerRecordFor(c.ID())
+		_, ca := c.PeerRecordFor(a.ID())
+		return ab && ac && ca
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key := dht.KeyFromImmutable([]byte("shared file"))
+	if err := a.DHTProvide(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	provs, err := c.DHTFindProviders(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provs) != 1 || provs[0].PeerID != a.ID() {
+		t.Fatalf("providers = %+v; want just a", provs)
+	}
+}
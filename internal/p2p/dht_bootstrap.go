@@ -42,7 +42,7 @@ func (n *Node) startDHTBootstrapLoop(cfg DHTBootstrapConfig) {
 		cfg.PerTickLookups = 2
 	}
 
-	go func() {
+	n.spawn("dht bootstrap", func() {
 		t := time.NewTicker(cfg.Tick)
 		defer t.Stop()
 
@@ -78,12 +78,12 @@ func (n *Node) startDHTBootstrapLoop(cfg DHTBootstrapConfig) {
 						if n.hasPeer(ni.NodeID) {
 							continue
 						}
-						_ = n.ConnectTo(netx.Addr(ni.Addr))
+						_ = n.connectExpecting(netx.Addr(ni.Addr), ni.PeerID)
 					}
 				}
 			}
 		}
-	}()
+	})
 }
 
 func (n *Node) coldStartDHTBootstrap() {
@@ -12,6 +12,11 @@ func TestDHT_QueryFindNode_Triangle(t *testing.T) {
 	c := newTestNode(t, "c")
 
 	connectTriangle(t, a, b, c)
+	// b only returns nodes whose signed record it holds, which comes with Identify.
+	waitCond(t, "b to hold c's record", func() bool {
+		_, ok := b.PeerRecordFor(c.ID())
+		return ok
+	})
 
 	if a.dht == nil || b.dht == nil || c.dht == nil {
 		t.Fatalf("expected dht enabled on all nodes")
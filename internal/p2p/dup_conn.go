@@ -0,0 +1,68 @@
+package p2p
+
+import "time"
+
+// duplicateConnGrace is how long the losing side of a duplicate connection
+// keeps reading (and flushing its send queue) before it is closed, so
+// messages already in flight on it are not lost.
+const duplicateConnGrace = 2 * time.Second
+
+// dialer returns the network ID of the node that initiated p's connection.
+func (n *Node) dialer(p *peer) string {
+	if p.inbound {
+		return p.id
+	}
+	return n.id.ID
+}
+
+// connWins reports whether cand should replace cur as the connection to the
+// same peer. Both ends evaluate the same rule on the same pair of connections,
+// so they always keep the same one: a direct connection beats a relayed one,
+// then the connection dialed by the lower NetworkID wins, and between two
+// connections from the same dialer the one with the lower dial nonce wins.
+func (n *Node) connWins(cand, cur *peer) bool {
+	if cand.relayed != cur.relayed {
+		return !cand.relayed
+	}
+	cd, od := n.dialer(cand), n.dialer(cur)
+	if cd != od {
+		return cd < od
+	}
+	return cand.dialNonce < cur.dialNonce
+}
+
+// replacePeerLocked swaps old for p in every index. Caller holds n.mu.
+func (n *Node) replacePeerLocked(old, p *peer) {
+	n.peers[p.id] = p
+
+	if p.name == "" {
+		p.name = old.name
+	}
+	if p.userID == "" && old.userID != "" {
+		p.userID = old.userID
+		p.userPub = old.userPub
+	}
+	if uid := old.userID; uid != "" {
+		if n.peersByUserID[uid] == old {
+			n.peersByUserID[uid] = p
+		}
+		if n.natByUserID[uid] == old {
+			n.natByUserID[uid] = p
+		}
+	}
+	n.Logf("duplicate connection to %s: keeping dialer=%s", p.id, n.dialer(p))
+}
+
+// retirePeer closes a losing duplicate connection after the grace period.
+// Until then its read loop keeps delivering in-flight messages.
+func (n *Node) retirePeer(p *peer) {
+	t := time.NewTimer(duplicateConnGrace)
+	n.spawn("retire duplicate", func() {
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-p.ctx.Done():
+		}
+		n.dropPeer(p)
+	})
+}
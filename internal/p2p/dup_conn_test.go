@@ -0,0 +1,79 @@
+package p2p
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestConnWins_BothSidesAgree(t *testing.T) {
+	a := &Node{id: &Identity{ID: strings.Repeat("1", 64)}}
+	b := &Node{id: &Identity{ID: strings.Repeat("2", 64)}}
+
+	cases := []struct {
+		name         string
+		dialer1      *Node
+		nonce1       string
+		dialer2      *Node
+		nonce2       string
+		wantFirstWin bool
+	}{
+		{"cross dial", a, "zz", b, "aa", true},
+		{"cross dial reversed", b, "aa", a, "zz", false},
+		{"same dialer", b, "bb", b, "aa", false},
+	}
+
+	view := func(self, other, dialer *Node, nonce string) *peer {
+		return &peer{id: other.id.ID, inbound: dialer != self, dialNonce: nonce}
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			onA := a.connWins(view(a, b, tc.dialer1, tc.nonce1), view(a, b, tc.dialer2, tc.nonce2))
+			onB := b.connWins(view(b, a, tc.dialer1, tc.nonce1), view(b, a, tc.dialer2, tc.nonce2))
+			if onA != onB {
+				t.Fatalf("sides disagree: a=%v b=%v", onA, onB)
+			}
+			if onA != tc.wantFirstWin {
+				t.Fatalf("got first-wins=%v want %v", onA, tc.wantFirstWin)
+			}
+		})
+	}
+}
+
+func TestSimultaneousDial_KeepsOneWorkingConnection(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); _ = a.ConnectTo(b.ListenAddr()) }()
+		go func() { defer wg.Done(); _ = b.ConnectTo(a.ListenAddr()) }()
+	}
+	wg.Wait()
+
+	// Let losing connections drain and close.
+	time.Sleep(duplicateConnGrace + 500*time.Millisecond)
+
+	if a.PeerCount() != 1 || b.PeerCount() != 1 {
+		t.Fatalf("expected exactly one peer each, a=%d b=%d", a.PeerCount(), b.PeerCount())
+	}
+
+	g := proto.Gossip{ID: NewMsgID(), Channel: "test", Body: json.RawMessage(`{}`)}
+	a.Broadcast(g)
+
+	select {
+	case env := <-b.Incoming():
+		var got proto.Gossip
+		if err := json.Unmarshal(env.Payload, &got); err != nil || got.ID != g.ID {
+			t.Fatalf("unexpected gossip %s (err=%v)", env.Payload, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("gossip did not arrive over surviving connection")
+	}
+}
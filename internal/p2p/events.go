@@ -1,16 +1,239 @@
 package p2p
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 type EventType string
 
 const (
 	EventPeerConnected    EventType = "peer_connected"
-	EventPeerDisconnected EventType = "peer_disconnected"
+	EventPeerDisconnected EventType = "peer_disconnected" // Detail is the Goodbye reason, if the peer sent one
+	EventPeerIdentified   EventType = "peer_identified"   // Identify arrived; UserID and PeerName are set
+	EventHandshakeFailed  EventType = "handshake_failed"  // PeerAddr is the remote end, Err why
+	EventPeerBanned       EventType = "peer_banned"       // Detail is the reason
+
+	EventGossipReceived EventType = "gossip_received" // new gossip; Detail is the channel, PeerID the relaying peer
+	EventRoutingChanged EventType = "routing_changed" // Detail is "added" or "evicted"
+
+	EventCircuitOpened      EventType = "circuit_opened"       // we are an end; PeerID is the seed, Detail the circuit ID
+	EventCircuitClosed      EventType = "circuit_closed"       // Err is the reason, if any
+	EventRelayCircuitOpened EventType = "relay_circuit_opened" // seeds: PeerID is the opener, UserID the target, Detail the circuit ID
+	EventRelayCircuitClosed EventType = "relay_circuit_closed"
+
+	EventReachabilityChanged EventType = "reachability_changed" // Detail is the status, PeerAddr the confirmed address
+	EventPortMapChanged      EventType = "port_map_changed"     // PeerAddr is the mapped address, "" when lost
 )
 
 type Event struct {
 	Type     EventType
+	Time     time.Time
 	PeerID   string
 	PeerAddr string
 	PeerName string
+	UserID   string
+	Detail   string
 	Err      string
 }
+
+// OverflowPolicy says what happens when a subscriber's buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest discards the new event. The default.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered event to make room.
+	OverflowDropOldest
+	// OverflowBlock makes the emitting goroutine wait for room. Only for
+	// subscribers that always keep reading, such as tests: a stalled one
+	// stalls the node.
+	OverflowBlock
+)
+
+const defaultEventBuffer = 128
+
+// SubscribeOption configures a Subscription.
+type SubscribeOption func(*Subscription)
+
+// WithEventBuffer sets how many events the subscription buffers.
+func WithEventBuffer(n int) SubscribeOption {
+	return func(s *Subscription) { s.buffer = max(n, 1) }
+}
+
+// WithOverflow sets the policy for a full buffer.
+func WithOverflow(p OverflowPolicy) SubscribeOption {
+	return func(s *Subscription) { s.policy = p }
+}
+
+// WithEventTypes delivers only the given types.
+func WithEventTypes(types ...EventType) SubscribeOption {
+	return func(s *Subscription) {
+		s.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+}
+
+// Subscription is one consumer of node events. Events arrive on C, which is
+// closed by Close or when the node stops.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	buffer  int
+	policy  OverflowPolicy
+	types   map[EventType]bool
+	dropped atomic.Uint64
+
+	bus  *eventBus
+	mu   sync.Mutex // serializes delivery with close
+	done chan struct{}
+	once sync.Once
+}
+
+// Dropped returns how many events overflowed this subscription's buffer.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+// Close stops delivery and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done) // releases an emitter blocked in deliver
+		s.mu.Lock()
+		close(s.ch)
+		s.mu.Unlock()
+		s.bus.remove(s)
+	})
+}
+
+// deliver hands e to the subscriber and reports whether an event was
+// dropped to make it fit (or e itself was).
+func (s *Subscription) deliver(e Event) (dropped bool) {
+	if s.types != nil && !s.types[e.Type] {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	switch s.policy {
+	case OverflowBlock:
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		}
+		return false
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.ch <- e:
+				return dropped
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+				dropped = true
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- e:
+			return false
+		default:
+			s.dropped.Add(1)
+			return true
+		}
+	}
+}
+
+type eventBus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+// publish delivers e to every subscriber and returns how many of them
+// overflowed.
+func (b *eventBus) publish(e Event) (overflowed int) {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+	for _, s := range subs {
+		if s.deliver(e) {
+			overflowed++
+		}
+	}
+	return overflowed
+}
+
+// close ends every subscription; later subscribers get a closed channel.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	b.closed = true
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// Subscribe returns a new subscription to node events. Each subscriber has
+// its own buffer; events that overflow it are counted in Dropped.
+func (n *Node) Subscribe(opts ...SubscribeOption) *Subscription {
+	s := &Subscription{
+		buffer: defaultEventBuffer,
+		bus:    n.events,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ch = make(chan Event, s.buffer)
+	s.C = s.ch
+
+	n.events.mu.Lock()
+	closed := n.events.closed
+	if !closed {
+		n.events.subs[s] = struct{}{}
+	}
+	n.events.mu.Unlock()
+	if closed {
+		s.Close()
+	}
+	return s
+}
+
+// emit publishes e to every subscriber. It must not be called with n.mu
+// held: a blocking subscriber would stall the node.
+func (n *Node) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if k := n.events.publish(e); k > 0 {
+		n.Logf("event %s overflowed %d subscriber(s)", e.Type, k)
+	}
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func waitEvent(t *testing.T, s *Subscription, typ EventType) Event {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				t.Fatalf("subscription closed waiting for %s", typ)
+			}
+			if ev.Type == typ {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s", typ)
+		}
+	}
+}
+
+func TestEvents_EverySubscriberSeesIdentified(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	s1 := a.Subscribe()
+	s2 := a.Subscribe(WithEventTypes(EventPeerIdentified))
+	routing := a.Subscribe(WithEventTypes(EventRoutingChanged))
+
+	connect(t, b, a)
+
+	for _, s := range []*Subscription{s1, s2} {
+		ev := waitEvent(t, s, EventPeerIdentified)
+		if ev.PeerID != b.ID() || ev.PeerName != "B" {
+			t.Fatalf("identified event = %+v; want peer %s named B", ev, b.ID())
+		}
+		if want := hex.EncodeToString(b.Identity().SignPub); ev.UserID != want {
+			t.Fatalf("identified UserID = %q; want %q", ev.UserID, want)
+		}
+		if ev.Time.IsZero() {
+			t.Fatalf("event has no time")
+		}
+	}
+	if ev := waitEvent(t, routing, EventRoutingChanged); ev.PeerID != b.ID() || ev.Detail != "added" {
+		t.Fatalf("routing event = %+v; want %s added", ev, b.ID())
+	}
+}
+
+func TestEvents_OverflowPolicies(t *testing.T) {
+	n := newTestNode(t, "A")
+	const typ EventType = "test_event"
+	newest := n.Subscribe(WithEventBuffer(2), WithEventTypes(typ))
+	oldest := n.Subscribe(WithEventBuffer(2), WithEventTypes(typ), WithOverflow(OverflowDropOldest))
+	blocking := n.Subscribe(WithEventBuffer(1), WithEventTypes(typ), WithOverflow(OverflowBlock))
+
+	got := make(chan string, 3)
+	go func() {
+		for ev := range blocking.C {
+			got <- ev.Detail
+		}
+	}()
+	for _, d := range []string{"1", "2", "3"} {
+		n.emit(Event{Type: typ, Detail: d})
+	}
+
+	read := func(s *Subscription) (out []string) {
+		for range 2 {
+			out = append(out, (<-s.C).Detail)
+		}
+		return out
+	}
+	if d := read(newest); d[0] != "1" || d[1] != "2" || newest.Dropped() != 1 {
+		t.Fatalf("drop-newest kept %v, dropped %d; want [1 2], 1", d, newest.Dropped())
+	}
+	if d := read(oldest); d[0] != "2" || d[1] != "3" || oldest.Dropped() != 1 {
+		t.Fatalf("drop-oldest kept %v, dropped %d; want [2 3], 1", d, oldest.Dropped())
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		if d := <-got; d != want {
+			t.Fatalf("blocking subscriber got %q; want %q", d, want)
+		}
+	}
+	if blocking.Dropped() != 0 {
+		t.Fatalf("blocking subscriber dropped %d", blocking.Dropped())
+	}
+
+	newest.Close()
+	if _, ok := <-newest.C; ok {
+		t.Fatalf("closed subscription still delivers")
+	}
+	n.emit(Event{Type: typ}) // must not panic on the closed channel
+}
+
+func TestEvents_GossipReceived(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	s := a.Subscribe(WithEventTypes(EventGossipReceived))
+	connect(t, b, a)
+	waitPeers(t, b, 1, 3*time.Second)
+
+	b.Broadcast(proto.Gossip{ID: NewMsgID(), Channel: "global", Body: json.RawMessage(`{}`)})
+
+	ev := waitEvent(t, s, EventGossipReceived)
+	if ev.PeerID != b.ID() || ev.Detail != "global" {
+		t.Fatalf("gossip event = %+v; want from %s on global", ev, b.ID())
+	}
+}
+
+func TestEvents_BanDropsAndRefusesPeer(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	s := a.Subscribe()
+	connect(t, b, a)
+	waitPeers(t, a, 1, 3*time.Second)
+
+	a.Ban(b.ID(), time.Minute, "misbehaving")
+	if ev := waitEvent(t, s, EventPeerBanned); ev.PeerID != b.ID() || ev.Detail != "misbehaving" {
+		t.Fatalf("ban event = %+v", ev)
+	}
+	waitEvent(t, s, EventPeerDisconnected)
+
+	connect(t, b, a)
+	if ev := waitEvent(t, s, EventHandshakeFailed); ev.Err != errPeerBanned.Error() {
+		t.Fatalf("handshake failure = %q; want %q", ev.Err, errPeerBanned)
+	}
+	if a.PeerCount() != 0 {
+		t.Fatalf("banned peer was let back in")
+	}
+
+	a.Unban(b.ID())
+	connect(t, b, a)
+	waitPeers(t, a, 1, 3*time.Second)
+}
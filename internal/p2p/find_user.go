@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+const (
+	// peerRecordDHTName names the mutable DHT record holding a user's
+	// current PeerRecord; its key is sha256(userPub || name).
+	peerRecordDHTName = "peer-record"
+
+	// DefaultRecordPublishInterval is how often we re-publish our record to
+	// the DHT even if it has not changed. It is well under the record TTL.
+	DefaultRecordPublishInterval = 20 * time.Minute
+
+	recordPublishCheck = 30 * time.Second // how often to look for address changes
+	findUserDialWait   = 5 * time.Second  // per attempt, before falling back
+)
+
+var ErrUserNotFound = errors.New("p2p: user not found")
+
+// UserRecordKey returns the DHT key under which userID publishes its record.
+func UserRecordKey(userID string) ([32]byte, error) {
+	pub, err := hex.DecodeString(userID)
+	if err != nil || len(pub) != 32 {
+		return [32]byte{}, fmt.Errorf("bad user ID %q", userID)
+	}
+	return dht.KeyFromMutable(pub, peerRecordDHTName), nil
+}
+
+// PublishSelfRecord stores our current PeerRecord in the DHT so others can
+// find us by user ID.
+func (n *Node) PublishSelfRecord(ctx context.Context) error {
+	rec := n.SelfRecord()
+	ttl := time.Until(time.Unix(rec.ExpiresUnix, 0))
+	_, err := n.dht.PutMutable(ctx, n, n.id.SignPriv, peerRecordDHTName, proto.MustMarshal(rec), rec.Seq, ttl)
+	return err
+}
+
+// recordPublishLoop keeps our DHT record current: it publishes once we have
+// peers, again whenever the record is re-signed, and at least every
+// DefaultRecordPublishInterval.
+func (n *Node) recordPublishLoop() {
+	t := time.NewTicker(recordPublishCheck)
+	defer t.Stop()
+
+	var lastSeq uint64
+	var lastAt time.Time
+	for {
+		if n.PeerCount() > 0 {
+			seq := n.SelfRecord().Seq
+			if seq != lastSeq || time.Since(lastAt) >= DefaultRecordPublishInterval {
+				if err := n.PublishSelfRecord(n.ctx); err != nil {
+					n.Logf("publish peer record: %v", err)
+				}
+				lastSeq, lastAt = seq, time.Now()
+			}
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// FindUser connects to userID and returns its network ID. Without an
+// existing connection it looks up the user's record in the DHT and dials
+// the addresses it lists; if that fails it opens a circuit through a seed.
+func (n *Node) FindUser(ctx context.Context, userID string) (string, error) {
+	if pid, ok := n.NetworkPeerIDForUserID(userID); ok {
+		return pid, nil
+	}
+	sub := n.Subscribe(WithEventTypes(EventPeerIdentified))
+	defer sub.Close()
+
+	rec, lookupErr := n.lookupUserRecord(ctx, userID)
+	if lookupErr == nil {
+		for _, addr := range rec.Addrs {
+			if n.connectExpecting(netx.Addr(addr), rec.NetworkID) != nil {
+				continue
+			}
+			if pid, ok := n.awaitUser(ctx, sub, userID); ok {
+				return pid, nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	n.Logf("find user %s: direct dial failed (%v); trying a relay", userID, lookupErr)
+	if err := n.ConnectViaRelay(userID); err != nil {
+		if lookupErr != nil {
+			return "", fmt.Errorf("%w: %v; relay: %v", ErrUserNotFound, lookupErr, err)
+		}
+		return "", fmt.Errorf("%w: relay: %v", ErrUserNotFound, err)
+	}
+	if pid, ok := n.awaitUser(ctx, sub, userID); ok {
+		return pid, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrUserNotFound
+}
+
+// lookupUserRecord fetches and verifies userID's PeerRecord from the DHT.
+func (n *Node) lookupUserRecord(ctx context.Context, userID string) (proto.PeerRecord, error) {
+	key, err := UserRecordKey(userID)
+	if err != nil {
+		return proto.PeerRecord{}, err
+	}
+	dr, ok, err := n.dht.GetValue(ctx, n, key)
+	if err != nil {
+		return proto.PeerRecord{}, err
+	}
+	if !ok || dr == nil {
+		return proto.PeerRecord{}, ErrUserNotFound
+	}
+
+	var rec proto.PeerRecord
+	if err := json.Unmarshal(dr.Value, &rec); err != nil {
+		return proto.PeerRecord{}, ErrBadPeerRecord
+	}
+	if !bytes.Equal(rec.UserPub, dr.PubKey) {
+		return proto.PeerRecord{}, ErrPeerRecordConflict
+	}
+	if err := n.notePeerRecord(&rec); err != nil {
+		return proto.PeerRecord{}, err
+	}
+	return rec, nil
+}
+
+// awaitUser waits for userID to identify on any connection, for at most
+// findUserDialWait.
+func (n *Node) awaitUser(ctx context.Context, sub *Subscription, userID string) (string, bool) {
+	if pid, ok := n.NetworkPeerIDForUserID(userID); ok {
+		return pid, true
+	}
+	t := time.NewTimer(findUserDialWait)
+	defer t.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return "", false
+			}
+			if ev.UserID == userID {
+				return ev.PeerID, true
+			}
+		case <-t.C:
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFindUser_ResolvesThroughDHT(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	c := newTestNode(t, "C")
+	connect(t, a, b)
+	connect(t, c, b)
+	waitPeers(t, b, 2, 3*time.Second)
+	waitCond(t, "B to hold C's record", func() bool {
+		_, ok := b.PeerRecordFor(c.ID())
+		return ok
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.PublishSelfRecord(ctx); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	cUser := hex.EncodeToString(c.Identity().SignPub)
+	got, err := a.FindUser(ctx, cUser)
+	if err != nil {
+		t.Fatalf("FindUser: %v", err)
+	}
+	if got != c.ID() {
+		t.Fatalf("FindUser = %s; want %s", got, c.ID())
+	}
+	a.mu.RLock()
+	pc := a.peers[c.ID()]
+	a.mu.RUnlock()
+	if pc == nil || pc.relayed {
+		t.Fatalf("expected a direct connection to C")
+	}
+}
+
+func TestFindUser_UnknownUser(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+
+	nobody, _ := NewIdentity()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := a.FindUser(ctx, hex.EncodeToString(nobody.SignPub)); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v; want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"p2p-park/internal/proto"
+)
+
+// Inbound is an envelope as handlers see it.
+type Inbound struct {
+	PeerID  string // network ID of the connection it arrived on
+	UserID  string // that peer's user ID; "" until it has identified
+	Relayed bool   // forwarded by the seed PeerID on behalf of Env.FromID
+	Env     proto.Envelope
+
+	peer *peer
+}
+
+// Handler processes one inbound envelope.
+type Handler func(in Inbound)
+
+// Middleware wraps the handler for msgType. It can observe, delay or drop
+// envelopes by not calling next.
+type Middleware func(msgType proto.MessageType, next Handler) Handler
+
+// HandleOption configures how a handler registered with Handle is run.
+type HandleOption func(*handlerEntry)
+
+// WithGoroutine runs the handler in a new goroutine per envelope.
+func WithGoroutine() HandleOption {
+	return func(e *handlerEntry) { e.mode = dispatchGoroutine }
+}
+
+// WithQueue runs the handler on its own goroutine, fed by a queue of size
+// envelopes. Envelopes arriving while the queue is full are dropped.
+func WithQueue(size int) HandleOption {
+	return func(e *handlerEntry) {
+		e.mode = dispatchQueue
+		e.queueSize = max(size, 1)
+	}
+}
+
+// WithMiddleware wraps only this handler, inside the node-wide chain.
+func WithMiddleware(mw ...Middleware) HandleOption {
+	return func(e *handlerEntry) { e.local = append(e.local, mw...) }
+}
+
+type dispatchMode int
+
+const (
+	dispatchInline    dispatchMode = iota // on the peer's read loop; must not block
+	dispatchGoroutine                     // one goroutine per envelope
+	dispatchQueue                         // dedicated worker with a bounded queue
+)
+
+type handlerEntry struct {
+	msgType   proto.MessageType
+	h         Handler
+	builtin   bool
+	mode      dispatchMode
+	queueSize int
+	local     []Middleware
+
+	wrapped Handler // h inside local and node-wide middleware; rebuilt on Use
+	queue   chan Inbound
+	done    chan struct{} // stops the queue worker when the entry is replaced
+}
+
+type handlerRegistry struct {
+	mu          sync.RWMutex
+	entries     map[proto.MessageType]*handlerEntry
+	middlewares []Middleware
+}
+
+func newHandlerRegistry() *handlerRegistry {
+	return &handlerRegistry{entries: make(map[proto.MessageType]*handlerEntry)}
+}
+
+// Handle registers h for envelopes of msgType. By default h runs on the
+// sending peer's read loop and must return quickly; use WithGoroutine or
+// WithQueue for anything slower. Types the node handles itself cannot be
+// taken over. Registering nil removes the handler.
+func (n *Node) Handle(msgType proto.MessageType, h Handler, opts ...HandleOption) error {
+	r := n.handlers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.entries[msgType]
+	if old != nil && old.builtin {
+		return fmt.Errorf("p2p: message type %q is handled by the node", msgType)
+	}
+	if old != nil && old.done != nil {
+		close(old.done)
+	}
+	if h == nil {
+		delete(r.entries, msgType)
+		return nil
+	}
+
+	e := &handlerEntry{msgType: msgType, h: h}
+	for _, opt := range opts {
+		opt(e)
+	}
+	r.wrap(e)
+	if e.mode == dispatchQueue {
+		e.queue = make(chan Inbound, e.queueSize)
+		e.done = make(chan struct{})
+		n.spawn("handler "+string(msgType), func() { n.runHandlerQueue(e) })
+	}
+	r.entries[msgType] = e
+	return nil
+}
+
+// Use appends middleware that wraps every handler, the node's own included.
+// The first middleware added is the outermost.
+func (n *Node) Use(mw ...Middleware) {
+	r := n.handlers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, mw...)
+	for _, e := range r.entries {
+		r.wrap(e)
+	}
+}
+
+// handleBuiltin registers one of the node's own handlers. They always run
+// inline, before the envelope could be mistaken for an app message.
+func (n *Node) handleBuiltin(msgType proto.MessageType, fn func(p *peer, env proto.Envelope)) {
+	r := n.handlers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := &handlerEntry{
+		msgType: msgType,
+		h:       func(in Inbound) { fn(in.peer, in.Env) },
+		builtin: true,
+	}
+	r.wrap(e)
+	r.entries[msgType] = e
+}
+
+// wrap rebuilds e.wrapped. Callers hold r.mu.
+func (r *handlerRegistry) wrap(e *handlerEntry) {
+	h := e.h
+	for i := len(e.local) - 1; i >= 0; i-- {
+		h = e.local[i](e.msgType, h)
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		h = r.middlewares[i](e.msgType, h)
+	}
+	e.wrapped = h
+}
+
+func (n *Node) runHandlerQueue(e *handlerEntry) {
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-e.done:
+			return
+		case in := <-e.queue:
+			n.handlers.mu.RLock()
+			h := e.wrapped
+			n.handlers.mu.RUnlock()
+			h(in)
+		}
+	}
+}
+
+// dispatch routes an envelope from p to its handler, replying "unsupported"
+// for types nobody handles.
+func (n *Node) dispatch(p *peer, env proto.Envelope) {
+	n.handlers.mu.RLock()
+	e := n.handlers.entries[env.Type]
+	var h Handler
+	if e != nil {
+		h = e.wrapped
+	}
+	n.handlers.mu.RUnlock()
+
+	if e == nil {
+		n.replyUnsupported(p, env.Type)
+		return
+	}
+	n.runHandler(e, h, n.inbound(p, env, false))
+}
+
+// dispatchRelayed delivers an envelope a seed forwarded on someone else's
+// behalf. Only app handlers see these: a relayed Identify or Ping must not
+// act on the seed's connection.
+func (n *Node) dispatchRelayed(seed *peer, env proto.Envelope) {
+	n.handlers.mu.RLock()
+	e := n.handlers.entries[env.Type]
+	var h Handler
+	if e != nil && !e.builtin {
+		h = e.wrapped
+	}
+	n.handlers.mu.RUnlock()
+
+	if h == nil {
+		n.Logf("relayed %q from %s: no handler", env.Type, env.FromID)
+		return
+	}
+	n.runHandler(e, h, n.inbound(seed, env, true))
+}
+
+func (n *Node) runHandler(e *handlerEntry, h Handler, in Inbound) {
+	switch e.mode {
+	case dispatchGoroutine:
+		n.spawn("handler "+string(e.msgType), func() { h(in) })
+	case dispatchQueue:
+		select {
+		case e.queue <- in:
+		default:
+			n.Logf("handler queue for %q full; dropping envelope from %s", e.msgType, in.PeerID)
+		}
+	default:
+		h(in)
+	}
+}
+
+func (n *Node) inbound(p *peer, env proto.Envelope, relayed bool) Inbound {
+	n.mu.RLock()
+	userID := p.userID
+	n.mu.RUnlock()
+	return Inbound{PeerID: p.id, UserID: userID, Relayed: relayed, Env: env, peer: p}
+}
+
+func (n *Node) replyUnsupported(p *peer, t proto.MessageType) {
+	if t == proto.MsgUnsupported {
+		return // never answer an answer
+	}
+	n.Logf("unsupported message type %q from %s", t, p.id)
+	n.sendAsync(p, proto.Envelope{
+		Type:    proto.MsgUnsupported,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.Unsupported{Type: t}),
+	})
+}
+
+func (n *Node) handleUnsupported(p *peer, env proto.Envelope) {
+	var u proto.Unsupported
+	if err := json.Unmarshal(env.Payload, &u); err != nil {
+		return
+	}
+	n.Logf("peer %s does not support %q", p.id, u.Type)
+}
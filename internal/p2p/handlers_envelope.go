@@ -2,62 +2,56 @@ package p2p
 
 import (
 	"encoding/json"
-	"p2p-park/internal/netx"
 	"p2p-park/internal/proto"
 )
 
-func (n *Node) handleEnvelope(p *peer, env proto.Envelope) {
-	switch env.Type {
-	case proto.MsgPeerList:
-		var pl proto.PeerList
-		if err := json.Unmarshal(env.Payload, &pl); err != nil {
-			n.Logf("bad peer list from %s: %s", p.id, err)
-			return
-		}
-		for _, pi := range pl.Peers {
-			if pi.ID == n.id.ID {
-				continue
-			}
-			if n.hasPeer(pi.ID) {
-				continue
-			}
-			n.Logf("discovery: dialing peer %s at %s", pi.ID, pi.Addr)
-			n.ConnectTo(netx.Addr(pi.Addr))
-		}
-	case proto.MsgGossip:
-		var g proto.Gossip
-		if err := json.Unmarshal(env.Payload, &g); err != nil {
-			return
-		}
-		if n.seen.Seen(g.ID) {
-			return
-		}
-
-		select {
-		case n.incoming <- env:
-		default:
-		}
-		n.relay(p.id, env)
-	case proto.MsgIdentify:
-		n.handleIdentify(p, env)
-	case proto.MsgNatRegister:
-		n.handleNatRegister(p, env)
-	case proto.MsgNatRelay:
+// registerBuiltinHandlers wires the message types the node handles itself.
+// Everything else goes to handlers registered with Handle.
+func (n *Node) registerBuiltinHandlers() {
+	n.handleBuiltin(proto.MsgGossip, n.handleGossip)
+	n.handleBuiltin(proto.MsgIdentify, n.handleIdentify)
+	n.handleBuiltin(proto.MsgPing, n.handlePing)
+	n.handleBuiltin(proto.MsgPong, n.handlePong)
+	n.handleBuiltin(proto.MsgNatRegister, n.handleNatRegister)
+	n.handleBuiltin(proto.MsgNatRelay, func(p *peer, env proto.Envelope) {
 		if n.cfg.IsSeed {
 			n.handleNatRelaySeed(p, env)
 		} else {
 			n.handleNatRelayClient(p, env)
 		}
-	case proto.MsgDHT:
+	})
+	n.handleBuiltin(proto.MsgPunchRequest, n.handlePunchRequest)
+	n.handleBuiltin(proto.MsgPunchSync, n.handlePunchSync)
+	n.handleBuiltin(proto.MsgDialBack, n.handleDialBack)
+	n.handleBuiltin(proto.MsgDialBackResult, n.handleDialBackResult)
+	n.handleBuiltin(proto.MsgCircuitOpen, n.handleCircuitOpen)
+	n.handleBuiltin(proto.MsgCircuitData, n.handleCircuitData)
+	n.handleBuiltin(proto.MsgCircuitClose, n.handleCircuitClose)
+	n.handleBuiltin(proto.MsgRequest, n.handleRequest)
+	n.handleBuiltin(proto.MsgResponse, n.handleResponse)
+	n.handleBuiltin(proto.MsgUnsupported, n.handleUnsupported)
+	n.handleBuiltin(proto.MsgGoodbye, n.handleGoodbye)
+	n.handleBuiltin(proto.MsgDHT, func(p *peer, env proto.Envelope) {
 		if n.dht != nil {
 			n.dht.HandleDHT(n, p.id, string(p.addr), p.name, env)
 		}
+	})
+}
+
+// handleGossip hands new gossip to the app through Incoming and floods it on.
+func (n *Node) handleGossip(p *peer, env proto.Envelope) {
+	var g proto.Gossip
+	if err := json.Unmarshal(env.Payload, &g); err != nil {
+		return
+	}
+	if n.seen.Seen(g.ID) {
 		return
+	}
 
+	select {
+	case n.incoming <- env:
 	default:
-		select {
-		case n.incoming <- env:
-		default:
-		}
 	}
+	n.emit(Event{Type: EventGossipReceived, PeerID: p.id, Detail: g.Channel})
+	n.relay(p.id, env)
 }
@@ -1,18 +1,32 @@
 package p2p
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"net"
+	"p2p-park/internal/netx"
 	"p2p-park/internal/proto"
+	"time"
 )
 
 func (n *Node) sendIdentify(p *peer) error {
 	id := n.id
+	st, _ := n.Reachability()
+	rec := n.SelfRecord()
 
 	ident := proto.Identify{
-		Name:    n.cfg.Name,
-		UserPub: id.SignPub,
+		Name:         n.cfg.Name,
+		UserPub:      id.SignPub,
+		IsSeed:       n.cfg.IsSeed,
+		Addr:         string(n.externalAddr()),
+		Reachability: st.String(),
+		Record:       &rec,
+	}
+	if !p.relayed {
+		ident.ObservedAddr = string(p.observedAddr)
 	}
 
 	env := proto.Envelope{
@@ -31,18 +45,49 @@ func (n *Node) handleIdentify(p *peer, env proto.Envelope) {
 		return
 	}
 
+	n.noteObservedAddr(p, ident.ObservedAddr)
+
 	n.mu.Lock()
 	p.name = ident.Name
-	if len(ident.UserPub) == ed25519.PublicKeySize {
+	p.isSeed = ident.IsSeed
+	p.reach = parseReachability(ident.Reachability)
+	if _, _, err := net.SplitHostPort(ident.Addr); err == nil && !p.relayed {
+		// A confirmed or mapped external address beats whatever Hello.Listen said.
+		p.addr = netx.Addr(ident.Addr)
+	}
+	addr := p.addr
+	if len(ident.UserPub) == ed25519.PublicKeySize && userOwnsNetworkID(p.id, ident.UserPub) {
 		p.userPub = ed25519.PublicKey(ident.UserPub)
 		p.userID = hex.EncodeToString(ident.UserPub)
-		n.peersByUserID[p.userID] = p
+		if n.peers[p.id] == p {
+			n.peersByUserID[p.userID] = p
+		}
 	}
+	registered := n.peers[p.id] == p
+	name, userID, userPub := p.name, p.userID, p.userPub
 	n.mu.Unlock()
 
+	if rec := ident.Record; rec != nil {
+		// The session proves p.id; the record must be signed by the same user.
+		if rec.NetworkID != p.id || !bytes.Equal(rec.UserPub, userPub) {
+			n.Logf("identify from %s carries a record for someone else", p.id)
+		} else if err := n.book.put(*rec, true, time.Now()); err != nil && !errors.Is(err, ErrPeerRecordStale) {
+			n.Logf("identify from %s: %v", p.id, err)
+		}
+	}
+
+	if registered && n.dht != nil && p.reach != ReachPrivate && !p.relayed {
+		n.dht.OnPeerSeen(p.id, string(addr), p.name)
+	}
+
 	if len(ident.UserPub) != ed25519.PublicKeySize {
 		n.Logf("identify from %s has invalid user_pub length %d", p.id, len(ident.UserPub))
+	} else if userPub == nil {
+		n.Logf("identify from %s has a user_pub that does not own its network key", p.id)
 	}
 
-	n.Logf("peer %s identified as %q (userID=%s)", p.id, p.name, p.userID)
+	n.Logf("peer %s identified as %q (userID=%s)", p.id, name, userID)
+	if registered {
+		n.emit(Event{Type: EventPeerIdentified, PeerID: p.id, PeerAddr: string(addr), PeerName: name, UserID: userID})
+	}
 }
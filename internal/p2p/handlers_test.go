@@ -0,0 +1,135 @@
+package p2p
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestHandle_DispatchAndUnsupported(t *testing.T) {
+	a, b := rpcPair(t)
+
+	got := make(chan Inbound, 1)
+	if err := b.Handle("app_ping", func(in Inbound) { got <- in }, WithQueue(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Handle(proto.MsgIdentify, func(Inbound) {}); err == nil {
+		t.Fatalf("taking over a built-in type should fail")
+	}
+
+	_ = a.SendToPeer(b.ID(), proto.Envelope{Type: "app_ping", FromID: a.ID(), Payload: []byte(`{}`)})
+	select {
+	case in := <-got:
+		if in.PeerID != a.ID() || in.UserID == "" || in.Relayed {
+			t.Fatalf("inbound = %+v", in)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("handler not called")
+	}
+
+	// a has no handler for app_ping, so b hears back that it is unsupported.
+	var unsupported atomic.Int32
+	b.Use(func(mt proto.MessageType, next Handler) Handler {
+		return func(in Inbound) {
+			if mt == proto.MsgUnsupported {
+				unsupported.Add(1)
+			}
+			next(in)
+		}
+	})
+	_ = b.SendToPeer(a.ID(), proto.Envelope{Type: "app_ping", FromID: b.ID(), Payload: []byte(`{}`)})
+	waitCond(t, "unsupported reply", func() bool { return unsupported.Load() == 1 })
+
+	// ...and nothing answers the unsupported reply itself.
+	time.Sleep(100 * time.Millisecond)
+	if n := unsupported.Load(); n != 1 {
+		t.Fatalf("unsupported replies = %d, want 1", n)
+	}
+}
+
+func TestHandle_MiddlewareOrderAndDrop(t *testing.T) {
+	a, b := rpcPair(t)
+
+	var mu sync.Mutex
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(mt proto.MessageType, next Handler) Handler {
+			return func(in Inbound) {
+				if mt == "traced" {
+					mu.Lock()
+					trace = append(trace, name)
+					mu.Unlock()
+				}
+				next(in)
+			}
+		}
+	}
+	metrics := NewHandlerMetrics()
+	b.Use(metrics.Middleware, mark("outer"), mark("inner"))
+
+	done := make(chan struct{}, 10)
+	if err := b.Handle("traced", func(Inbound) {
+		mu.Lock()
+		trace = append(trace, "handler")
+		mu.Unlock()
+		done <- struct{}{}
+	}, WithMiddleware(mark("local"), RateLimitMiddleware(0.001, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 5 {
+		_ = a.SendToPeer(b.ID(), proto.Envelope{Type: "traced", FromID: a.ID(), Payload: []byte(`{}`)})
+	}
+	for range 2 {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler not called")
+		}
+	}
+	waitCond(t, "all five envelopes", func() bool {
+		for _, s := range metrics.Snapshot() {
+			if s.Type == "traced" {
+				return s.Count == 5
+			}
+		}
+		return false
+	})
+
+	select {
+	case <-done:
+		t.Fatalf("rate limiter let a third envelope through a burst of 2")
+	default:
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"outer", "inner", "local", "handler"}
+	for i, w := range want {
+		if trace[i] != w {
+			t.Fatalf("trace = %v, want it to start with %v", trace, want)
+		}
+	}
+}
+
+func TestRequireIdentified(t *testing.T) {
+	called := 0
+	h := RequireIdentified()("app", func(Inbound) { called++ })
+	h(Inbound{PeerID: "p"})
+	if called != 0 {
+		t.Fatalf("unidentified peer got through")
+	}
+	h(Inbound{PeerID: "p", UserID: "u"})
+	if called != 1 {
+		t.Fatalf("identified peer was dropped")
+	}
+
+	ping := RequireIdentified()(proto.MsgPing, func(Inbound) { called++ })
+	ping(Inbound{PeerID: "p"})
+	if called != 2 {
+		t.Fatalf("keepalives must pass before Identify")
+	}
+}
@@ -0,0 +1,219 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+const (
+	punchLead        = 100 * time.Millisecond // minimum wait before dialing, for the sync to reach both sides
+	punchAttempts    = 3
+	punchDialTimeout = 2 * time.Second
+	punchRetryGap    = 200 * time.Millisecond // a RST comes back at once; give the other SYN time to leave
+	punchCooldown    = 30 * time.Second       // per target, between requests
+)
+
+// punchState tracks hole punches we asked for and ones in progress.
+type punchState struct {
+	mu        sync.Mutex
+	requested map[string]time.Time // userID -> last request
+	active    map[string]bool      // peer network ID -> punching now
+}
+
+func newPunchState() *punchState {
+	return &punchState{
+		requested: make(map[string]time.Time),
+		active:    make(map[string]bool),
+	}
+}
+
+// RequestPunch asks every connected seed to coordinate a hole punch with
+// userID. On success a direct connection to that user shows up like any
+// other peer, and SendToUserID starts using it.
+func (n *Node) RequestPunch(userID string) error {
+	if _, ok := n.NetworkPeerIDForUserID(userID); ok {
+		return nil
+	}
+	seeds := n.seedPeers()
+	if len(seeds) == 0 {
+		return errNoSeeds
+	}
+	n.punch.mu.Lock()
+	n.punch.requested[userID] = time.Now()
+	n.punch.mu.Unlock()
+
+	n.sendPunchRequest(userID, seeds)
+	return nil
+}
+
+// maybeRequestPunch is RequestPunch rate-limited per target, for callers
+// that hit the relay path repeatedly.
+func (n *Node) maybeRequestPunch(userID string, seeds []*peer) {
+	if n.cfg.IsSeed {
+		return
+	}
+	n.punch.mu.Lock()
+	if last, ok := n.punch.requested[userID]; ok && time.Since(last) < punchCooldown {
+		n.punch.mu.Unlock()
+		return
+	}
+	n.punch.requested[userID] = time.Now()
+	n.punch.mu.Unlock()
+
+	n.sendPunchRequest(userID, seeds)
+}
+
+func (n *Node) sendPunchRequest(userID string, seeds []*peer) {
+	env := proto.Envelope{
+		Type:    proto.MsgPunchRequest,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.PunchRequest{ToUserID: userID}),
+	}
+	for _, p := range seeds {
+		n.sendAsync(p, env)
+	}
+}
+
+func (n *Node) seedPeers() []*peer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	var seeds []*peer
+	for _, p := range n.peers {
+		if p.isSeed {
+			seeds = append(seeds, p)
+		}
+	}
+	return seeds
+}
+
+// handlePunchRequest runs on seeds: tell both sides where to dial and when.
+func (n *Node) handlePunchRequest(p *peer, env proto.Envelope) {
+	if !n.cfg.IsSeed {
+		return
+	}
+	var req proto.PunchRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		n.Logf("bad punch request from %s: %v", p.id, err)
+		return
+	}
+
+	n.mu.RLock()
+	from := n.natByUserID[p.userID]
+	target := n.natByUserID[req.ToUserID]
+	n.mu.RUnlock()
+
+	if from != p || target == nil || target == p {
+		n.Logf("punch request from %s for %s: not registered", p.id, req.ToUserID)
+		return
+	}
+
+	// Delay the nearer side so both dials leave at about the same time.
+	rttFrom, rttTarget := p.ka.rtt(), target.ka.rtt()
+	slowest := max(rttFrom, rttTarget)
+	delay := func(rtt time.Duration) int64 {
+		return (punchLead + (slowest-rtt)/2).Milliseconds()
+	}
+
+	n.sendAsync(p, proto.Envelope{
+		Type:   proto.MsgPunchSync,
+		FromID: n.id.ID,
+		Payload: proto.MustMarshal(proto.PunchSync{
+			PeerID:    target.id,
+			UserID:    target.userID,
+			Addr:      string(target.observedAddr),
+			Initiator: p.id < target.id,
+			DelayMs:   delay(rttFrom),
+		}),
+	})
+	n.sendAsync(target, proto.Envelope{
+		Type:   proto.MsgPunchSync,
+		FromID: n.id.ID,
+		Payload: proto.MustMarshal(proto.PunchSync{
+			PeerID:    p.id,
+			UserID:    p.userID,
+			Addr:      string(p.observedAddr),
+			Initiator: target.id < p.id,
+			DelayMs:   delay(rttTarget),
+		}),
+	})
+	n.Logf("punch: coordinating %s <-> %s", p.id, target.id)
+}
+
+// handlePunchSync runs on clients: dial the other side from the endpoint
+// our connection to the seed uses.
+func (n *Node) handlePunchSync(seed *peer, env proto.Envelope) {
+	if n.cfg.IsSeed || !seed.isSeed {
+		return
+	}
+	var ps proto.PunchSync
+	if err := json.Unmarshal(env.Payload, &ps); err != nil {
+		n.Logf("bad punch sync from %s: %v", seed.id, err)
+		return
+	}
+	if ps.PeerID == "" || ps.PeerID == n.id.ID || ps.Addr == "" || n.hasPeer(ps.PeerID) {
+		return
+	}
+
+	puncher, ok := n.cfg.Network.(netx.Puncher)
+	if !ok || seed.localAddr == "" {
+		n.Logf("punch: transport cannot dial from a fixed endpoint; staying on relay")
+		return
+	}
+
+	n.punch.mu.Lock()
+	if n.punch.active[ps.PeerID] {
+		n.punch.mu.Unlock()
+		return
+	}
+	n.punch.active[ps.PeerID] = true
+	n.punch.mu.Unlock()
+
+	n.spawn("hole punch", func() {
+		defer func() {
+			n.punch.mu.Lock()
+			delete(n.punch.active, ps.PeerID)
+			n.punch.mu.Unlock()
+		}()
+		if err := n.punchTo(puncher, seed.localAddr, ps); err != nil {
+			n.Logf("punch: %s at %s failed: %v", ps.PeerID, ps.Addr, err)
+		}
+	})
+}
+
+func (n *Node) punchTo(puncher netx.Puncher, local netx.Addr, ps proto.PunchSync) error {
+	t := time.NewTimer(time.Duration(ps.DelayMs) * time.Millisecond)
+	defer t.Stop()
+	select {
+	case <-n.ctx.Done():
+		return n.ctx.Err()
+	case <-t.C:
+	}
+
+	var lastErr error
+	for i := range punchAttempts {
+		if i > 0 {
+			time.Sleep(punchRetryGap)
+		}
+		if n.hasPeer(ps.PeerID) {
+			return nil
+		}
+		conn, err := puncher.DialFrom(local, netx.Addr(ps.Addr), punchDialTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		n.Logf("punch: direct connection to %s at %s (initiator=%v)", ps.PeerID, ps.Addr, ps.Initiator)
+		// Both sides dialed, so Noise roles come from the seed, not the socket.
+		n.goHandleConn(conn, !ps.Initiator, ps.PeerID)
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no attempts made")
+	}
+	return lastErr
+}
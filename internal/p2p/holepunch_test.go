@@ -0,0 +1,61 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"p2p-park/internal/netx"
+)
+
+func TestHolePunch_UpgradesRelayToDirect(t *testing.T) {
+	hub := netx.NewMemHub()
+	natA := hub.NewNAT("203.0.113.10")
+	natB := hub.NewNAT("198.51.100.20")
+
+	seed := newTestNode(t, "seed", WithSeed(true), WithNetwork(hub.NewNetwork("192.0.2.1")))
+	a := newTestNode(t, "A", WithNetwork(natA.NewNetwork("10.0.0.2")), WithBootstraps(seed.ListenAddr()))
+	b := newTestNode(t, "B", WithNetwork(natB.NewNetwork("10.0.1.2")), WithBootstraps(seed.ListenAddr()))
+
+	waitForNatRegistry(t, seed, 2)
+
+	// Without coordination the NATs drop each other's dials.
+	bOnSeed := findPeerOnSeed(t, seed, b.ID())
+	if err := a.ConnectTo(bOnSeed.observedAddr); err == nil {
+		t.Fatalf("plain dial through B's NAT should fail")
+	}
+	if err := a.ConnectTo(b.ListenAddr()); err == nil {
+		t.Fatalf("dial to B's private address should fail")
+	}
+
+	bUserID := hex.EncodeToString(b.Identity().SignPub)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// Retry until A has learned which peer is the seed from Identify.
+		if err := a.RequestPunch(bUserID); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("RequestPunch never found a seed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for !a.hasPeer(b.ID()) || !b.hasPeer(a.ID()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no direct connection after hole punch: a->b=%v b->a=%v", a.hasPeer(b.ID()), b.hasPeer(a.ID()))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The relay path is now upgraded: userID lookups resolve to the direct peer.
+	for {
+		if pid, ok := a.NetworkPeerIDForUserID(bUserID); ok && pid == b.ID() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("A never indexed B's userID on the direct connection")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
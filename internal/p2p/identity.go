@@ -3,8 +3,16 @@ package p2p
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha512"
 	"encoding/hex"
-	"io"
+	"fmt"
+	"math/big"
+	"p2p-park/internal/dht"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/crypto/curve25519"
 )
@@ -13,6 +21,7 @@ type Identity struct {
 	SignPriv ed25519.PrivateKey
 	SignPub  ed25519.PublicKey
 
+	// The network key is derived from the user key; see noiseKeyFromSignKey.
 	NoisePriv [32]byte
 	NoisePub  [32]byte
 
@@ -24,11 +33,13 @@ func PlayerIDFromPub(pub ed25519.PublicKey) string {
 	return hex.EncodeToString(pub)
 }
 
-// noiseKeypair generates an X25519 keypair.
-func noiseKeypair() (priv, pub [32]byte, err error) {
-	if _, err = io.ReadFull(rand.Reader, priv[:]); err != nil {
-		return
-	}
+// noiseKeyFromSignKey derives the X25519 key of the same secret as an
+// ed25519 key, the way ed25519 expands its seed into a scalar. Its public
+// half is networkKeyForUser(the ed25519 public key), so anyone holding a
+// user key can tell which network ID it goes with.
+func noiseKeyFromSignKey(signPriv ed25519.PrivateKey) (priv, pub [32]byte) {
+	h := sha512.Sum512(signPriv.Seed())
+	copy(priv[:], h[:32])
 	// Clamp as per X25519 spec.
 	priv[0] &= 248
 	priv[31] &= 127
@@ -37,16 +48,75 @@ func noiseKeypair() (priv, pub [32]byte, err error) {
 	return
 }
 
-func NewIdentity() (*Identity, error) {
-	signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return nil, err
+// curve25519P is the field prime 2^255 - 19.
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// networkKeyForUser maps an ed25519 public key to the X25519 public key of
+// the same secret, u = (1+y)/(1-y) on the curve's Montgomery form.
+func networkKeyForUser(userPub ed25519.PublicKey) (out [32]byte, ok bool) {
+	if len(userPub) != ed25519.PublicKeySize {
+		return out, false
+	}
+	be := slices.Clone(userPub)
+	be[31] &= 0x7f // the sign of x; both points map to the same u
+	slices.Reverse(be)
+	y := new(big.Int).SetBytes(be)
+	if y.Cmp(curve25519P) >= 0 {
+		return out, false
+	}
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return out, false
 	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+	u.FillBytes(out[:])
+	slices.Reverse(out[:])
+	return out, true
+}
+
+// userOwnsNetworkID reports whether networkID is the network key derived
+// from userPub.
+func userOwnsNetworkID(networkID string, userPub []byte) bool {
+	key, ok := networkKeyForUser(ed25519.PublicKey(userPub))
+	return ok && networkID == hex.EncodeToString(key[:])
+}
+
+func NewIdentity() (*Identity, error) {
+	return NewIdentityWithDifficulty(0, nil)
+}
+
+// maxIDDifficulty bounds the key search; each bit doubles it.
+const maxIDDifficulty = 40
 
-	nPriv, nPub, err := noiseKeypair()
+// KeygenProgress reports on a search for a network key that solves the ID
+// puzzle. Expected is the average number of keys it takes.
+type KeygenProgress struct {
+	Tried    uint64
+	Expected uint64
+	Elapsed  time.Duration
+	Done     bool
+}
+
+// keygenReportEvery is how often a key search reports progress.
+const keygenReportEvery = time.Second
+
+// NewIdentityWithDifficulty is NewIdentity for a network whose ID puzzle
+// takes bits leading zero bits: it tries network keys, on every CPU, until
+// one solves it. progress, if not nil, hears about the search now and then
+// while it runs and once when it is done.
+func NewIdentityWithDifficulty(bits int, progress func(KeygenProgress)) (*Identity, error) {
+	if bits < 0 || bits > maxIDDifficulty {
+		return nil, fmt.Errorf("id difficulty %d out of range 0..%d", bits, maxIDDifficulty)
+	}
+	signPub, signPriv, err := solveIDPuzzle(bits, progress)
 	if err != nil {
 		return nil, err
 	}
+	nPriv, nPub := noiseKeyFromSignKey(signPriv)
 
 	id := hex.EncodeToString(nPub[:])
 
@@ -58,3 +128,67 @@ func NewIdentity() (*Identity, error) {
 		ID:        id,
 	}, nil
 }
+
+// solveIDPuzzle generates user keys until the node ID of the network key
+// derived from one solves the puzzle at bits.
+func solveIDPuzzle(bits int, progress func(KeygenProgress)) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	if bits == 0 {
+		return ed25519.GenerateKey(rand.Reader)
+	}
+
+	type found struct {
+		pub  ed25519.PublicKey
+		priv ed25519.PrivateKey
+		err  error
+	}
+	var (
+		tried atomic.Uint64
+		stop  atomic.Bool
+		wg    sync.WaitGroup
+	)
+	out := make(chan found, 1)
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				pub, priv, err := ed25519.GenerateKey(rand.Reader)
+				tried.Add(1)
+				if err == nil {
+					_, nPub := noiseKeyFromSignKey(priv)
+					id, _ := dht.NodeIDFromPeerID(hex.EncodeToString(nPub[:]))
+					if !dht.SolvesPuzzle(id, bits) {
+						continue
+					}
+				}
+				select {
+				case out <- found{pub, priv, err}:
+				default:
+				}
+				stop.Store(true)
+				return
+			}
+		}()
+	}
+
+	start := time.Now()
+	report := func(done bool) {
+		if progress != nil {
+			progress(KeygenProgress{Tried: tried.Load(), Expected: 1 << bits, Elapsed: time.Since(start), Done: done})
+		}
+	}
+	tick := time.NewTicker(keygenReportEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case f := <-out:
+			wg.Wait()
+			if f.err == nil {
+				report(true)
+			}
+			return f.pub, f.priv, f.err
+		case <-tick.C:
+			report(false)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+)
+
+func TestIdentity_NetworkKeyDerivesFromUserKey(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		id, err := NewIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, ok := networkKeyForUser(id.SignPub)
+		if !ok || key != id.NoisePub {
+			t.Fatalf("network key for user = %x; want %x", key, id.NoisePub)
+		}
+		if !userOwnsNetworkID(id.ID, id.SignPub) {
+			t.Fatalf("user key does not own its own network ID")
+		}
+		other, _ := NewIdentity()
+		if userOwnsNetworkID(hex.EncodeToString(other.NoisePub[:]), id.SignPub) {
+			t.Fatalf("user key owns another identity's network ID")
+		}
+	}
+}
+
+func TestNewIdentityWithDifficulty_SolvesPuzzle(t *testing.T) {
+	var last KeygenProgress
+	id, err := NewIdentityWithDifficulty(10, func(p KeygenProgress) { last = p })
+	if err != nil {
+		t.Fatalf("NewIdentityWithDifficulty: %v", err)
+	}
+	if _, err := dht.CheckPeerID(id.ID, 10); err != nil {
+		t.Fatalf("generated key: %v", err)
+	}
+	if !last.Done || last.Tried == 0 || last.Expected != 1<<10 {
+		t.Fatalf("last progress report = %+v; want a done report", last)
+	}
+
+	if _, err := NewIdentityWithDifficulty(maxIDDifficulty+1, nil); err == nil {
+		t.Fatalf("accepted a difficulty above %d", maxIDDifficulty)
+	}
+}
+
+func TestIDDifficulty_HandshakeRefusesUnsolvedKeys(t *testing.T) {
+	a := newTestNode(t, "A", WithIDDifficulty(8))
+	b := newTestNode(t, "B")
+	for {
+		if _, err := dht.CheckPeerID(b.ID(), 8); err != nil {
+			break
+		}
+		b = newTestNode(t, "B") // 1 in 256 keys solves it by chance
+	}
+	c := newTestNode(t, "C", WithIDDifficulty(8))
+
+	connect(t, b, a)
+	connect(t, a, b)
+	connect(t, c, a)
+	waitPeers(t, a, 1, 3*time.Second)
+
+	time.Sleep(200 * time.Millisecond)
+	for _, p := range a.SnapshotPeers() {
+		if p.NetworkID == b.ID() {
+			t.Fatalf("A took B, whose key does not solve the puzzle")
+		}
+	}
+	if b.PeerCount() != 0 {
+		t.Fatalf("B has %d peers; A should have hung up", b.PeerCount())
+	}
+}
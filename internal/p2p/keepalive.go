@@ -0,0 +1,136 @@
+package p2p
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// KeepaliveConfig controls transport-level ping/pong between connected peers.
+type KeepaliveConfig struct {
+	Interval  time.Duration // how often to ping each peer
+	MaxMissed int           // consecutive unanswered pings before the peer is dropped
+}
+
+func DefaultKeepaliveConfig() KeepaliveConfig {
+	return KeepaliveConfig{
+		Interval:  15 * time.Second,
+		MaxMissed: 3,
+	}
+}
+
+// peerKeepalive is the per-peer keepalive and RTT state.
+type peerKeepalive struct {
+	mu          sync.Mutex
+	seq         uint64
+	sentAt      time.Time
+	outstanding bool
+	missed      int
+	srtt        time.Duration // smoothed RTT (RFC 6298 style, alpha = 1/8)
+}
+
+func (k *peerKeepalive) observe(sample time.Duration) {
+	if k.srtt == 0 {
+		k.srtt = sample
+		return
+	}
+	k.srtt = k.srtt - k.srtt/8 + sample/8
+}
+
+func (k *peerKeepalive) rtt() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.srtt
+}
+
+// keepaliveLoop pings p every interval and drops it after MaxMissed
+// consecutive pings go unanswered. It exits when the peer goes away.
+func (n *Node) keepaliveLoop(p *peer) {
+	cfg := n.cfg.Keepalive
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultKeepaliveConfig().Interval
+	}
+	if cfg.MaxMissed <= 0 {
+		cfg.MaxMissed = DefaultKeepaliveConfig().MaxMissed
+	}
+
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		p.ka.mu.Lock()
+		if p.ka.outstanding {
+			p.ka.missed++
+		}
+		missed := p.ka.missed
+		p.ka.seq++
+		seq := p.ka.seq
+		p.ka.sentAt = time.Now()
+		p.ka.outstanding = true
+		p.ka.mu.Unlock()
+
+		if missed >= cfg.MaxMissed {
+			n.Logf("peer %s missed %d keepalives; disconnecting", p.id, missed)
+			n.dropPeer(p)
+			return
+		}
+
+		n.sendAsync(p, proto.Envelope{
+			Type:    proto.MsgPing,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(proto.Ping{Seq: seq}),
+		})
+	}
+}
+
+func (n *Node) handlePing(p *peer, env proto.Envelope) {
+	var ping proto.Ping
+	if err := json.Unmarshal(env.Payload, &ping); err != nil {
+		n.Logf("bad ping from %s: %v", p.id, err)
+		return
+	}
+	n.sendAsync(p, proto.Envelope{
+		Type:    proto.MsgPong,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.Pong{Seq: ping.Seq}),
+	})
+}
+
+func (n *Node) handlePong(p *peer, env proto.Envelope) {
+	var pong proto.Pong
+	if err := json.Unmarshal(env.Payload, &pong); err != nil {
+		n.Logf("bad pong from %s: %v", p.id, err)
+		return
+	}
+
+	p.ka.mu.Lock()
+	defer p.ka.mu.Unlock()
+
+	// Any pong proves the peer is alive; only the current one is a clean RTT sample.
+	p.ka.missed = 0
+	if p.ka.outstanding && pong.Seq == p.ka.seq {
+		p.ka.outstanding = false
+		p.ka.observe(time.Since(p.ka.sentAt))
+	}
+}
+
+// PeerRTT returns the smoothed round-trip time to a connected peer.
+// ok is false if the peer is unknown or has not answered a keepalive yet.
+func (n *Node) PeerRTT(peerID string) (time.Duration, bool) {
+	n.mu.RLock()
+	p := n.peers[peerID]
+	n.mu.RUnlock()
+	if p == nil {
+		return 0, false
+	}
+	rtt := p.ka.rtt()
+	return rtt, rtt > 0
+}
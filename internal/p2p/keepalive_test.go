@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepalive_MeasuresRTT(t *testing.T) {
+	a := newTestNode(t, "a", WithKeepalive(20*time.Millisecond, 3))
+	b := newTestNode(t, "b", WithKeepalive(20*time.Millisecond, 3))
+
+	connect(t, a, b)
+	waitPeers(t, a, 1, 2*time.Second)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if rtt, ok := a.PeerRTT(b.ID()); ok && rtt > 0 {
+			snaps := a.SnapshotPeers()
+			if len(snaps) != 1 || snaps[0].RTT <= 0 {
+				t.Fatalf("expected RTT in snapshot, got %+v", snaps)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no RTT measured for peer b")
+}
+
+func TestKeepalive_EvictsHalfOpenPeer(t *testing.T) {
+	n := newTestNode(t, "a", WithKeepalive(20*time.Millisecond, 2))
+
+	// A pipe nobody reads from: writes block forever, pongs never arrive.
+	local, remote := net.Pipe()
+	t.Cleanup(func() { _ = remote.Close() })
+
+	ctx, cancel := context.WithCancel(n.ctx)
+	out := &countingWriter{w: local}
+	p := &peer{
+		id:      strings.Repeat("ab", 32),
+		conn:    local,
+		out:     out,
+		writer:  json.NewEncoder(out),
+		sendq:   newSendQueue(n.cfg.SendQueues),
+		traffic: newPeerTraffic(n.cfg.Bandwidth),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if kept, _ := n.addPeer(p); !kept {
+		t.Fatalf("addPeer failed")
+	}
+	go p.writeLoop(n)
+	go n.keepaliveLoop(p)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if !n.hasPeer(p.id) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("half-open peer was not evicted")
+}
@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// LoggingMiddleware logs every envelope with its type, sender and how long
+// the handler took.
+func LoggingMiddleware(logf func(format string, args ...any)) Middleware {
+	return func(t proto.MessageType, next Handler) Handler {
+		return func(in Inbound) {
+			start := time.Now()
+			next(in)
+			logf("handled %q from %s in %s", t, in.PeerID, time.Since(start))
+		}
+	}
+}
+
+// HandlerStats is what HandlerMetrics has recorded for one message type.
+type HandlerStats struct {
+	Type  proto.MessageType
+	Count uint64
+	Total time.Duration // time spent in the rest of the chain
+}
+
+// HandlerMetrics counts envelopes and handler time per message type.
+type HandlerMetrics struct {
+	mu    sync.Mutex
+	stats map[proto.MessageType]*HandlerStats
+}
+
+func NewHandlerMetrics() *HandlerMetrics {
+	return &HandlerMetrics{stats: make(map[proto.MessageType]*HandlerStats)}
+}
+
+// Middleware records into m. For queued handlers it measures the handler
+// itself, not the time spent waiting in the queue.
+func (m *HandlerMetrics) Middleware(t proto.MessageType, next Handler) Handler {
+	return func(in Inbound) {
+		start := time.Now()
+		next(in)
+		d := time.Since(start)
+
+		m.mu.Lock()
+		s := m.stats[t]
+		if s == nil {
+			s = &HandlerStats{Type: t}
+			m.stats[t] = s
+		}
+		s.Count++
+		s.Total += d
+		m.mu.Unlock()
+	}
+}
+
+// Snapshot returns the stats sorted by message type.
+func (m *HandlerMetrics) Snapshot() []HandlerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]HandlerStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+const rateLimitMaxBuckets = 4096
+
+// RateLimitMiddleware drops envelopes from a peer beyond perSecond, with
+// bursts of up to burst. Each peer and message type has its own bucket.
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+	type bucket struct {
+		tokens float64
+		last   time.Time
+	}
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+
+	return func(t proto.MessageType, next Handler) Handler {
+		return func(in Inbound) {
+			key := string(t) + "\x00" + in.PeerID
+			now := time.Now()
+
+			mu.Lock()
+			b := buckets[key]
+			if b == nil {
+				if len(buckets) >= rateLimitMaxBuckets {
+					// Buckets idle long enough to have refilled carry no state.
+					full := time.Duration(float64(burst) / perSecond * float64(time.Second))
+					for k, old := range buckets {
+						if now.Sub(old.last) > full {
+							delete(buckets, k)
+						}
+					}
+				}
+				b = &bucket{tokens: float64(burst), last: now}
+				buckets[key] = b
+			}
+			b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+			b.last = now
+			ok := b.tokens >= 1
+			if ok {
+				b.tokens--
+			}
+			mu.Unlock()
+
+			if ok {
+				next(in)
+			}
+		}
+	}
+}
+
+// RequireIdentified drops envelopes from peers that have not sent Identify
+// yet. Identify itself, keepalives and exempt types always pass.
+func RequireIdentified(exempt ...proto.MessageType) Middleware {
+	pass := map[proto.MessageType]bool{
+		proto.MsgIdentify:    true,
+		proto.MsgPing:        true,
+		proto.MsgPong:        true,
+		proto.MsgUnsupported: true,
+	}
+	for _, t := range exempt {
+		pass[t] = true
+	}
+	return func(t proto.MessageType, next Handler) Handler {
+		if pass[t] {
+			return next
+		}
+		return func(in Inbound) {
+			if in.UserID != "" {
+				next(in)
+			}
+		}
+	}
+}
@@ -3,9 +3,12 @@ package p2p
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"p2p-park/internal/proto"
 )
 
+var errNoSeeds = errors.New("no seed connected to relay through")
+
 func (n *Node) sendNatRegister(p *peer) error {
 	id := n.Identity()
 
@@ -44,7 +47,9 @@ func (n *Node) handleNatRegister(p *peer, env proto.Envelope) {
 	if n.natByUserID == nil {
 		n.natByUserID = make(map[string]*peer)
 	}
-	n.natByUserID[reg.UserID] = p
+	if n.peers[p.id] == p {
+		n.natByUserID[reg.UserID] = p
+	}
 
 	p.userID = reg.UserID
 	if p.name == "" && reg.Name != "" {
@@ -94,5 +99,40 @@ func (n *Node) handleNatRelayClient(fromPeer *peer, env proto.Envelope) {
 		n.Logf("bad NatRelay inbound: %v", err)
 		return
 	}
-	n.Logf("NatRelay from %s to %s; payload=%s", env.FromID, msg.ToUserID, string(msg.Payload))
+
+	var inner proto.Envelope
+	if err := json.Unmarshal(msg.Payload, &inner); err != nil || inner.Type == "" || inner.Type == proto.MsgNatRelay {
+		n.Logf("NatRelay from %s via %s: dropping non-envelope payload", env.FromID, fromPeer.id)
+		return
+	}
+	// The seed stamps env.FromID with the original sender's network ID.
+	inner.FromID = env.FromID
+
+	n.dispatchRelayed(fromPeer, inner)
+}
+
+// SendViaRelay asks every connected seed to forward env to userID.
+// Use it when there is no direct connection; the seed only delivers if the
+// target has registered with it, so duplicates must be tolerated by the app.
+func (n *Node) SendViaRelay(userID string, env proto.Envelope) error {
+	inner, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	relayEnv := proto.Envelope{
+		Type:    proto.MsgNatRelay,
+		FromID:  n.id.ID,
+		Payload: proto.MustMarshal(proto.NatRelay{ToUserID: userID, Payload: inner}),
+	}
+
+	seeds := n.seedPeers()
+	if len(seeds) == 0 {
+		return errNoSeeds
+	}
+	for _, p := range seeds {
+		n.sendAsync(p, relayEnv)
+	}
+	// Try to upgrade the relay path to a direct connection.
+	n.maybeRequestPunch(userID, seeds)
+	return nil
 }
@@ -127,3 +127,50 @@ func TestNATRelayRaceHarness(t *testing.T) {
 	// small delay to let any in-flight writes finish
 	time.Sleep(50 * time.Millisecond)
 }
+
+func TestSendViaRelay_DeliversInnerEnvelope(t *testing.T) {
+	seed := newTestNode(t, "seed", WithSeed(true))
+	nA := newTestNode(t, "A", WithBootstraps(seed.ListenAddr()))
+	nB := newTestNode(t, "B", WithBootstraps(seed.ListenAddr()))
+
+	waitForNatRegistry(t, seed, 2)
+
+	got := make(chan Inbound, 1)
+	if err := nB.Handle("relay_test", func(in Inbound) { got <- in }); err != nil {
+		t.Fatal(err)
+	}
+
+	// A only knows the seed as a relay once its Identify has arrived.
+	deadline := time.Now().Add(5 * time.Second)
+	bUserID := hex.EncodeToString(nB.Identity().SignPub)
+	inner := proto.Envelope{
+		Type:    proto.MessageType("relay_test"),
+		FromID:  nA.ID(),
+		Payload: json.RawMessage(`{"hello":"B"}`),
+	}
+	for {
+		err := nA.SendViaRelay(bUserID, inner)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("SendViaRelay: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case in := <-got:
+		if !in.Relayed {
+			t.Fatalf("relayed envelope not marked as relayed")
+		}
+		if in.Env.FromID != nA.ID() {
+			t.Fatalf("relayed FromID = %s, want %s", in.Env.FromID, nA.ID())
+		}
+		if string(in.Env.Payload) != string(inner.Payload) {
+			t.Fatalf("relayed payload = %s", in.Env.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("B never received the relayed envelope")
+	}
+}
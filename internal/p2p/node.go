@@ -4,16 +4,22 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"p2p-park/internal/dht"
 	"p2p-park/internal/netx"
 	"p2p-park/internal/proto"
+	"p2p-park/internal/storage/dhtbolt"
 	"p2p-park/internal/telemetry"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
+// dhtRecordsFile holds the DHT records we store and own, under DataDir.
+const dhtRecordsFile = "dht_records.bolt"
+
 type NodeConfig struct {
 	Name       string           // user-facing name
 	Network    netx.Network     // transport implementation
@@ -23,31 +29,61 @@ type NodeConfig struct {
 	Logger     telemetry.Logger // system logger
 	Debug      bool             // flag for showing hidden logs to debug
 	IsSeed     bool             // if true, this node will keep NAT registry & relay
+	DataDir    string           // directory for persistent node state; empty keeps it in memory
+
+	// IDDifficulty is the network's ID puzzle, in leading zero bits (see
+	// dht.SolvesPuzzle). NewNode searches for a network key that solves
+	// it, reporting to KeygenProgress if set, and peers whose keys do not
+	// are refused at the handshake and kept out of the DHT. All nodes in a
+	// network must agree on it; 0 turns the puzzle off.
+	IDDifficulty   int
+	KeygenProgress func(KeygenProgress)
+
+	SendQueues SendQueuesConfig // per-peer send queue sizes, weights and overflow policies
+	Keepalive  KeepaliveConfig  // transport ping interval and dead-peer threshold
+	Relay      RelayConfig      // circuit limits when IsSeed
+	PortMap    PortMapConfig    // PCP / NAT-PMP port forwarding on the home router
+	PEX        PEXConfig        // peer exchange sample sizes and dialing target
+	Bandwidth  BandwidthConfig  // global and per-peer rate limits
 }
 
 type peer struct {
 	id           string
 	addr         netx.Addr
 	observedAddr netx.Addr
+	localAddr    netx.Addr          // our end of the connection, if the transport knows it
 	conn         io.ReadWriteCloser // stream type for Noise secure encrypted
-	writer       *json.Encoder
+	out          *countingWriter    // conn, counting bytes for the write loop
+	writer       *json.Encoder      // encodes into out
 
-	sendCh chan proto.Envelope
-	ctx    context.Context
-	cancel context.CancelFunc
-	once   sync.Once
+	sendq   *sendQueue
+	traffic *peerTraffic
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
 
 	name    string
 	userPub ed25519.PublicKey
 	userID  string
+	isSeed  bool         // remote announced itself as a seed in Identify
+	relayed bool         // runs over a seed circuit rather than a direct connection
+	reach   Reachability // as the remote reported it in Identify
+
+	inbound   bool   // true if the remote dialed us
+	dialNonce string // Hello nonce sent by whichever side dialed
+	goodbye   string // reason from the remote's Goodbye, if it sent one
+
+	ka peerKeepalive
 }
 
 // PeerSnapshot is a read-only view of a connected peer.
 type PeerSnapshot struct {
-	NetworkID string // Noise hex ID (p.id)
-	Name      string // p.name from Identify
-	UserID    string // hex(ed25519 pub) if known
-	Addr      string // listen address string
+	NetworkID string        // Noise hex ID (p.id)
+	Name      string        // p.name from Identify
+	UserID    string        // hex(ed25519 pub) if known
+	Addr      string        // listen address string
+	RTT       time.Duration // smoothed keepalive RTT; 0 if not measured yet
+	Relayed   bool          // connected through a seed circuit
 }
 
 type Node struct {
@@ -58,16 +94,35 @@ type Node struct {
 	mu            sync.RWMutex
 	peers         map[string]*peer
 	peersByUserID map[string]*peer
+	banned        map[string]time.Time // network ID -> ban expiry
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	incoming    chan proto.Envelope // gossip and other messages
+	incoming    chan proto.Envelope // new gossip
 	natByUserID map[string]*peer    // only meaningful when cfg.IsSeed == true
 
-	events chan Event
+	events *eventBus
 	seen   *seenCache
 
+	sticky   *stickyPeers
+	punch    *punchState
+	circuits *circuitTable
+	reach    *reachState
+	rpc      *rpcState
+	handlers *handlerRegistry
+	book     *peerRecordBook
+	addrs    *addrBook
+	bw       *bandwidth
+	portMap  chan struct{} // closed when the port mapping is released; nil if disabled
+	tasks    *taskGroup
+
+	connsMu sync.Mutex
+	conns   map[netx.Conn]struct{} // raw connections, closed by Stop
+
+	stopOnce sync.Once
+	stopErr  error
+
 	dht *dht.DHT
 }
 
@@ -75,11 +130,7 @@ func NewNode(cfg NodeConfig) (*Node, error) {
 	if cfg.Logger == nil {
 		cfg.Logger = log.Default()
 	}
-	id, err := NewIdentity()
-	if err != nil {
-		return nil, err
-	}
-	dd, err := dht.New(id.ID, dht.WithStore(""))
+	id, err := NewIdentityWithDifficulty(cfg.IDDifficulty, cfg.KeygenProgress)
 	if err != nil {
 		return nil, err
 	}
@@ -92,10 +143,42 @@ func NewNode(cfg NodeConfig) (*Node, error) {
 		ctx:           ctx,
 		cancel:        cancel,
 		incoming:      make(chan proto.Envelope, 128),
-		events:        make(chan Event, 128),
+		handlers:      newHandlerRegistry(),
+		events:        newEventBus(),
 		seen:          newSeenCache(30 * time.Second),
-		dht:           dd,
+		sticky:        newStickyPeers(cfg.DataDir),
+		punch:         newPunchState(),
+		circuits:      newCircuitTable(),
+		reach:         newReachState(),
+		rpc:           newRPCState(),
+		book:          newPeerRecordBook(),
+		addrs:         newAddrBook(),
+		bw:            newBandwidth(cfg.Bandwidth),
+		tasks:         newTaskGroup(),
+		conns:         make(map[netx.Conn]struct{}),
+	}
+	dhtOpts := []dht.Option{dht.WithStore(""), dht.WithLatencyHint(n.PeerRTT), dht.WithPeerRecords(dhtPeerRecords{n}), dht.WithIDDifficulty(cfg.IDDifficulty)}
+	var rs *dhtbolt.Store
+	if cfg.DataDir != "" {
+		rs, err = dhtbolt.Open(filepath.Join(cfg.DataDir, dhtRecordsFile))
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("open dht records: %w", err)
+		}
+		dhtOpts = append(dhtOpts, dht.WithRecordStore(rs))
 	}
+	dd, err := dht.New(id.ID, dhtOpts...)
+	if err != nil {
+		cancel()
+		if rs != nil {
+			_ = rs.Close()
+		}
+		return nil, err
+	}
+	n.dht = dd
+	dd.Routing().SetObserver(n.routingChanged)
+	n.registerBuiltinHandlers()
+	n.HandleRequest(pexProtocol, n.servePEX)
 	if cfg.IsSeed {
 		n.natByUserID = make(map[string]*peer)
 	}
@@ -108,7 +191,6 @@ func (n *Node) Identity() *Identity             { return n.id }
 func (n *Node) ListenAddr() netx.Addr           { return n.addr }
 func (n *Node) Incoming() <-chan proto.Envelope { return n.incoming }
 func (n *Node) Name() string                    { return n.cfg.Name }
-func (n *Node) Events() <-chan Event            { return n.events }
 func (n *Node) Debug() bool                     { return n.cfg.Debug }
 
 // Start brings the node online.
@@ -120,29 +202,31 @@ func (n *Node) Start() error {
 	n.addr = addr
 	n.Logf("listening on %s, peerID=%s", n.addr, n.id.ID)
 
-	go n.acceptLoop()
+	n.spawn("accept", n.acceptLoop)
 
 	n.coldStartDHTBootstrap()
 
-	go n.discoveryLoop()
+	n.spawn("discovery", n.discoveryLoop)
 
-	n.startDHTBootstrapLoop(DefaultDHTBootstrapConfig())
+	n.spawn("sticky", n.stickyLoop)
 
-	return nil
-}
+	n.spawn("pex", n.pexLoop)
 
-// Stop shuts down the node.
-func (n *Node) Stop() error {
-	n.cancel()
-	return n.cfg.Network.Close()
-}
+	n.spawn("reachability", n.reachabilityLoop)
 
-func (n *Node) emit(e Event) {
-	select {
-	case n.events <- e:
-	default:
-		// drop to avoid deadlock; optionally log once
+	if n.cfg.PortMap.Enabled {
+		done := make(chan struct{})
+		n.portMap = done
+		n.spawn("portmap", func() { n.portMapLoop(done) })
 	}
+
+	n.startDHTBootstrapLoop(DefaultDHTBootstrapConfig())
+	n.spawn("dht maintenance", func() { n.dht.RunRecordMaintenance(n.ctx, n, dht.DefaultMaintenanceConfig()) })
+	n.spawn("dht refresh", func() { n.dht.RunBucketRefresh(n.ctx, n, dht.DefaultRefreshConfig()) })
+
+	n.spawn("record publish", n.recordPublishLoop)
+
+	return nil
 }
 
 // Broadcast sends a gossip mesage to all connected peers.
@@ -0,0 +1,207 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+const (
+	// DefaultPeerRecordTTL is how long our own record stays valid. It is
+	// re-signed at half-life and whenever our advertised address changes.
+	DefaultPeerRecordTTL = time.Hour
+
+	maxPeerRecordAddrs  = 8
+	maxPeerRecordFuture = 24 * time.Hour // refuse expiries further out than this
+	maxPeerRecords      = 4096
+)
+
+var (
+	ErrBadPeerRecord      = errors.New("p2p: malformed peer record")
+	ErrPeerRecordSig      = errors.New("p2p: bad peer record signature")
+	ErrPeerRecordExpired  = errors.New("p2p: peer record expired")
+	ErrPeerRecordStale    = errors.New("p2p: peer record older than the one we hold")
+	ErrPeerRecordConflict = errors.New("p2p: peer record signed by a different key")
+)
+
+// SignPeerRecord creates a record for id saying it can be dialed at addrs.
+func SignPeerRecord(id *Identity, addrs []string, seq uint64, expires time.Time) proto.PeerRecord {
+	r := proto.PeerRecord{
+		NetworkID:   id.ID,
+		UserPub:     slices.Clone(id.SignPub),
+		Addrs:       slices.Clone(addrs),
+		Seq:         seq,
+		ExpiresUnix: expires.Unix(),
+	}
+	r.Signature = ed25519.Sign(id.SignPriv, proto.EncodePeerRecordCanonical(r))
+	return r
+}
+
+// VerifyPeerRecord checks that r is well formed, unexpired at now and signed
+// by its UserPub, and that NetworkID is the network key derived from that
+// UserPub, so whoever signed it also holds the network key. Whether the
+// addresses reach that key is for the Noise handshake to tell.
+func VerifyPeerRecord(r *proto.PeerRecord, now time.Time) error {
+	if r == nil || len(r.UserPub) != ed25519.PublicKeySize || len(r.Signature) != ed25519.SignatureSize {
+		return ErrBadPeerRecord
+	}
+	if b, err := hex.DecodeString(r.NetworkID); err != nil || len(b) != 32 {
+		return ErrBadPeerRecord
+	}
+	if !userOwnsNetworkID(r.NetworkID, r.UserPub) {
+		return ErrPeerRecordConflict
+	}
+	if len(r.Addrs) == 0 || len(r.Addrs) > maxPeerRecordAddrs {
+		return ErrBadPeerRecord
+	}
+	for _, a := range r.Addrs {
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			return ErrBadPeerRecord
+		}
+	}
+	exp := time.Unix(r.ExpiresUnix, 0)
+	if !now.Before(exp) {
+		return ErrPeerRecordExpired
+	}
+	if exp.Sub(now) > maxPeerRecordFuture {
+		return ErrBadPeerRecord
+	}
+	if !ed25519.Verify(ed25519.PublicKey(r.UserPub), proto.EncodePeerRecordCanonical(*r), r.Signature) {
+		return ErrPeerRecordSig
+	}
+	return nil
+}
+
+type bookEntry struct {
+	rec    proto.PeerRecord
+	direct bool // came from the peer itself over an authenticated session
+}
+
+// peerRecordBook holds our own record and the verified records of others,
+// keyed by network ID.
+type peerRecordBook struct {
+	mu      sync.Mutex
+	self    proto.PeerRecord
+	records map[string]bookEntry
+}
+
+func newPeerRecordBook() *peerRecordBook {
+	return &peerRecordBook{records: make(map[string]bookEntry)}
+}
+
+// put verifies rec and stores it if it is newer than what we hold. Only the
+// user key the network ID derives from can sign a record for it, so a
+// second-hand record cannot displace the genuine one.
+func (b *peerRecordBook) put(rec proto.PeerRecord, direct bool, now time.Time) error {
+	if err := VerifyPeerRecord(&rec, now); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if old, ok := b.records[rec.NetworkID]; ok && now.Unix() < old.rec.ExpiresUnix {
+		switch {
+		case rec.Seq < old.rec.Seq:
+			return ErrPeerRecordStale
+		case rec.Seq == old.rec.Seq:
+			if direct && !old.direct {
+				old.direct = true
+				b.records[rec.NetworkID] = old
+			}
+			return nil
+		}
+		direct = direct || old.direct
+	} else if !ok && len(b.records) >= maxPeerRecords {
+		b.evictLocked(now)
+	}
+	b.records[rec.NetworkID] = bookEntry{rec: rec, direct: direct}
+	return nil
+}
+
+// evictLocked makes room: expired records first, else one learned
+// second-hand, else any.
+func (b *peerRecordBook) evictLocked(now time.Time) {
+	for id, e := range b.records {
+		if now.Unix() >= e.rec.ExpiresUnix {
+			delete(b.records, id)
+		}
+	}
+	if len(b.records) < maxPeerRecords {
+		return
+	}
+	victim := ""
+	for id, e := range b.records {
+		victim = id
+		if !e.direct {
+			break
+		}
+	}
+	delete(b.records, victim)
+}
+
+func (b *peerRecordBook) get(networkID string, now time.Time) (proto.PeerRecord, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.records[networkID]
+	if !ok {
+		return proto.PeerRecord{}, false
+	}
+	if now.Unix() >= e.rec.ExpiresUnix {
+		delete(b.records, networkID)
+		return proto.PeerRecord{}, false
+	}
+	return e.rec, true
+}
+
+// SelfRecord returns our signed address record, re-signing it if our
+// advertised address changed or it is past half its lifetime.
+func (n *Node) SelfRecord() proto.PeerRecord {
+	addrs := []string{string(n.advertisedAddr())}
+	now := time.Now()
+
+	b := n.book
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fresh := time.Unix(b.self.ExpiresUnix, 0).Sub(now) > DefaultPeerRecordTTL/2
+	if b.self.Signature != nil && fresh && slices.Equal(b.self.Addrs, addrs) {
+		return b.self
+	}
+	// Nanosecond seqs keep increasing across restarts without persisting.
+	seq := max(uint64(now.UnixNano()), b.self.Seq+1)
+	b.self = SignPeerRecord(n.id, addrs, seq, now.Add(DefaultPeerRecordTTL))
+	return b.self
+}
+
+// PeerRecordFor returns the verified record we hold for a network ID.
+func (n *Node) PeerRecordFor(networkID string) (proto.PeerRecord, bool) {
+	if networkID == n.id.ID {
+		return n.SelfRecord(), true
+	}
+	return n.book.get(networkID, time.Now())
+}
+
+// notePeerRecord stores a record a peer sent about someone else.
+func (n *Node) notePeerRecord(rec *proto.PeerRecord) error {
+	if rec == nil {
+		return ErrBadPeerRecord
+	}
+	now := time.Now()
+	if rec.NetworkID == n.id.ID {
+		// Only our own record is believable for our network ID; we never store it.
+		if !bytes.Equal(rec.UserPub, n.id.SignPub) {
+			return ErrPeerRecordConflict
+		}
+		return VerifyPeerRecord(rec, now)
+	}
+	err := n.book.put(*rec, false, now)
+	if errors.Is(err, ErrPeerRecordStale) {
+		return nil // genuine, just superseded
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestPeerRecord_SignAndVerify(t *testing.T) {
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	rec := SignPeerRecord(id, []string{"198.51.100.1:4000"}, 1, now.Add(time.Hour))
+	if err := VerifyPeerRecord(&rec, now); err != nil {
+		t.Fatalf("fresh record: %v", err)
+	}
+
+	tampered := rec
+	tampered.Addrs = []string{"203.0.113.9:4000"}
+	if err := VerifyPeerRecord(&tampered, now); !errors.Is(err, ErrPeerRecordSig) {
+		t.Fatalf("tampered address: err = %v", err)
+	}
+	if err := VerifyPeerRecord(&rec, now.Add(2*time.Hour)); !errors.Is(err, ErrPeerRecordExpired) {
+		t.Fatalf("expired record: err = %v", err)
+	}
+	bad := SignPeerRecord(id, []string{"no port"}, 1, now.Add(time.Hour))
+	if err := VerifyPeerRecord(&bad, now); !errors.Is(err, ErrBadPeerRecord) {
+		t.Fatalf("malformed address: err = %v", err)
+	}
+
+	// A key can sign for no network ID but the one derived from it.
+	other, _ := NewIdentity()
+	forged := SignPeerRecord(other, []string{"203.0.113.9:4000"}, 1, now.Add(time.Hour))
+	forged.NetworkID = id.ID
+	forged.Signature = ed25519.Sign(other.SignPriv, proto.EncodePeerRecordCanonical(forged))
+	if err := VerifyPeerRecord(&forged, now); !errors.Is(err, ErrPeerRecordConflict) {
+		t.Fatalf("forged network ID: err = %v", err)
+	}
+}
+
+func TestPeerRecord_BookKeepsNewestFromSameKey(t *testing.T) {
+	id, _ := NewIdentity()
+	other, _ := NewIdentity()
+	now := time.Now()
+	b := newPeerRecordBook()
+
+	v1 := SignPeerRecord(id, []string{"198.51.100.1:4000"}, 1, now.Add(time.Hour))
+	v2 := SignPeerRecord(id, []string{"198.51.100.2:4000"}, 2, now.Add(time.Hour))
+	if err := b.put(v2, false, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.put(v1, false, now); !errors.Is(err, ErrPeerRecordStale) {
+		t.Fatalf("older seq: err = %v", err)
+	}
+
+	// Someone else's key claiming id's network ID.
+	forged := SignPeerRecord(other, []string{"203.0.113.9:4000"}, 9, now.Add(time.Hour))
+	forged.NetworkID = id.ID
+	forged.Signature = ed25519.Sign(other.SignPriv, proto.EncodePeerRecordCanonical(forged))
+	if err := b.put(forged, false, now); !errors.Is(err, ErrPeerRecordConflict) {
+		t.Fatalf("second-hand record under another key: err = %v", err)
+	}
+	if got, _ := b.get(id.ID, now); got.Seq != 2 {
+		t.Fatalf("held seq = %d; want 2", got.Seq)
+	}
+}
+
+func TestPeerRecord_PEXNeedsSignedEntries(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	c := newTestNode(t, "C")
+
+	if got := a.learnPeers(b.ID(), []proto.PeerInfo{{ID: c.ID(), Addr: string(c.ListenAddr())}}); got != 0 {
+		t.Fatalf("filed %d unsigned entries", got)
+	}
+
+	rec := c.SelfRecord()
+	if got := a.learnPeers(b.ID(), []proto.PeerInfo{{ID: c.ID(), Addr: "203.0.113.9:1", Record: &rec}}); got != 1 {
+		t.Fatalf("filed %d signed entries; want 1", got)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if a.hasPeer(c.ID()) {
+		t.Fatalf("dialed a learned peer on arrival")
+	}
+	a.dialFromBook()
+	waitCond(t, "A to dial C from its signed record", func() bool { return a.hasPeer(c.ID()) })
+}
+
+func TestPeerRecord_DialRefusesImpostor(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	c := newTestNode(t, "C")
+	s := a.Subscribe(WithEventTypes(EventHandshakeFailed))
+
+	// A record can name C's network ID at B's address; the handshake tells.
+	if err := a.connectExpecting(b.ListenAddr(), c.ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, s, EventHandshakeFailed)
+	if a.hasPeer(b.ID()) {
+		t.Fatalf("kept a connection to the wrong peer")
+	}
+}
+
+func TestPeerRecord_IdentifyCarriesRecord(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	connect(t, b, a)
+	waitCond(t, "A to hold B's record", func() bool {
+		_, ok := a.PeerRecordFor(b.ID())
+		return ok
+	})
+	rec, _ := a.PeerRecordFor(b.ID())
+	if rec.Addrs[0] != string(b.ListenAddr()) {
+		t.Fatalf("record addrs = %v; want %s", rec.Addrs, b.ListenAddr())
+	}
+}
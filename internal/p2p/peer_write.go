@@ -2,19 +2,18 @@ package p2p
 
 func (p *peer) writeLoop(n *Node) {
 	for {
-		select {
-		case <-p.ctx.Done():
+		env, ok := p.sendq.next(p.ctx)
+		if !ok {
+			return
+		}
+		start := p.out.n
+		err := p.writer.Encode(env)
+		p.sendq.sent()
+		if err != nil {
+			n.Logf("write to %s failed: %v", p.id, err)
+			go n.dropPeer(p)
 			return
-
-		case env, ok := <-p.sendCh:
-			if !ok {
-				return
-			}
-			if err := p.writer.Encode(env); err != nil {
-				n.Logf("write to %s failed: %v", p.id, err)
-				go n.removePeer(p.id)
-				return
-			}
 		}
+		throttle(p.ctx, n.account(p, false, env, int(p.out.n-start)))
 	}
 }
@@ -1,40 +1,69 @@
 package p2p
 
 import (
+	"sort"
+	"time"
+
 	"p2p-park/internal/proto"
 )
 
-func (n *Node) addPeer(p *peer) bool {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-	if _, exists := n.peers[p.id]; exists || p.id == n.id.ID {
-		return false
+// addPeer registers p. If a connection to the same peer already exists, the
+// simultaneous-dial tie-break picks which one stays registered; the other is
+// returned as loser so the caller can drain and close it.
+// kept reports whether p is now the registered connection.
+func (n *Node) addPeer(p *peer) (kept bool, loser *peer) {
+	if p.id == n.id.ID {
+		return false, nil
 	}
+	// Outside n.mu: the routing table reports changes as events.
 	if n.dht != nil {
 		n.dht.OnPeerSeen(p.id, string(p.addr), p.name)
 	}
+
+	n.mu.Lock()
+	if old, exists := n.peers[p.id]; exists {
+		defer n.mu.Unlock()
+		if !n.connWins(p, old) {
+			return false, p
+		}
+		n.replacePeerLocked(old, p)
+		return true, old
+	}
 	n.peers[p.id] = p
+	n.mu.Unlock()
+	n.addrs.connected(p.id, time.Now())
+
 	n.emit(Event{Type: EventPeerConnected, PeerID: p.id, PeerAddr: string(p.addr), PeerName: p.name})
-	return true
+	return true, nil
 }
 
+// removePeer unregisters and closes the current connection for id.
 func (n *Node) removePeer(id string) {
-	var p *peer
-
-	n.mu.Lock()
-	p = n.peers[id]
-	if p == nil {
-		n.mu.Unlock()
-		return
+	n.mu.RLock()
+	p := n.peers[id]
+	n.mu.RUnlock()
+	if p != nil {
+		n.dropPeer(p)
 	}
-	delete(n.peers, id)
+}
 
-	if uid := p.userID; uid != "" {
-		if cur := n.natByUserID[uid]; cur == p {
-			delete(n.natByUserID, uid)
-		}
-		if cur := n.peersByUserID[uid]; cur == p {
-			delete(n.peersByUserID, uid)
+// dropPeer closes p and unregisters it if it is still the registered
+// connection for its ID. A connection that lost a duplicate tie-break is
+// closed without touching the winner or emitting a disconnect.
+func (n *Node) dropPeer(p *peer) {
+	n.mu.Lock()
+	goodbye := p.goodbye
+	registered := n.peers[p.id] == p
+	if registered {
+		delete(n.peers, p.id)
+
+		if uid := p.userID; uid != "" {
+			if cur := n.natByUserID[uid]; cur == p {
+				delete(n.natByUserID, uid)
+			}
+			if cur := n.peersByUserID[uid]; cur == p {
+				delete(n.peersByUserID, uid)
+			}
 		}
 	}
 	n.mu.Unlock()
@@ -44,15 +73,30 @@ func (n *Node) removePeer(id string) {
 			p.cancel()
 		}
 		_ = p.conn.Close()
-		n.emit(Event{Type: EventPeerDisconnected, PeerID: p.id, PeerAddr: string(p.addr), PeerName: p.name})
+		if registered {
+			n.emit(Event{Type: EventPeerDisconnected, PeerID: p.id, PeerAddr: string(p.addr), PeerName: p.name, Detail: goodbye})
+		}
 	})
 }
 
 func (n *Node) snapshotPeersInfo() []proto.PeerInfo {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
-	out := make([]proto.PeerInfo, 0, len(n.peers))
+	peers := make([]*peer, 0, len(n.peers))
 	for _, p := range n.peers {
+		// Relayed and private peers have no address anyone could dial.
+		if p != nil && !p.relayed && p.reach != ReachPrivate {
+			peers = append(peers, p)
+		}
+	}
+	// Lowest-latency peers first so receivers dial them before the rest.
+	sort.SliceStable(peers, func(i, j int) bool {
+		return rttLess(peers[i].ka.rtt(), peers[j].ka.rtt())
+	})
+
+	now := time.Now()
+	out := make([]proto.PeerInfo, 0, len(peers))
+	for _, p := range peers {
 		if p == nil {
 			continue
 		}
@@ -66,12 +110,22 @@ func (n *Node) snapshotPeersInfo() []proto.PeerInfo {
 		if p.observedAddr != "" && n.cfg.IsSeed {
 			info.PublicAddr = string(p.observedAddr)
 		}
+		if rec, ok := n.book.get(p.id, now); ok {
+			info.Record = &rec
+		}
 
 		out = append(out, info)
 	}
 	return out
 }
 
+// isRegistered reports whether p is the current connection for its peer ID.
+func (n *Node) isRegistered(p *peer) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.peers[p.id] == p
+}
+
 func (n *Node) hasPeer(id string) bool {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
@@ -141,10 +195,12 @@ func (n *Node) SnapshotPeers() []PeerSnapshot {
 			NetworkID: p.id,
 			Name:      p.name,
 			UserID:    p.userID,
+			Relayed:   p.relayed,
 		}
 		if p.addr != "" {
 			ps.Addr = string(p.addr)
 		}
+		ps.RTT = p.ka.rtt()
 		out = append(out, ps)
 	}
 	return out
@@ -158,3 +214,14 @@ func (n *Node) UserIDForPeer(peerID string) string {
 	}
 	return ""
 }
+
+// rttLess orders measured RTTs ascending, with unmeasured (0) last.
+func rttLess(a, b time.Duration) bool {
+	if a == 0 {
+		return false
+	}
+	if b == 0 {
+		return true
+	}
+	return a < b
+}
@@ -0,0 +1,216 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+const pexProtocol = "p2p/pex"
+
+// PEXConfig tunes peer exchange: how many peers we aim for and how much we
+// ask for and hand out.
+type PEXConfig struct {
+	TargetPeers  int           // stop asking and dialing once connected to this many
+	MaxResponse  int           // most peers we send in one response
+	MaxRequest   int           // most peers we ask for
+	Interval     time.Duration // how often to ask and dial while under target
+	DialsPerTick int           // most address book dials per round
+}
+
+func DefaultPEXConfig() PEXConfig {
+	return PEXConfig{
+		TargetPeers:  8,
+		MaxResponse:  16,
+		MaxRequest:   16,
+		Interval:     30 * time.Second,
+		DialsPerTick: 3,
+	}
+}
+
+func (n *Node) pexConfig() PEXConfig {
+	cfg, def := n.cfg.PEX, DefaultPEXConfig()
+	if cfg.TargetPeers <= 0 {
+		cfg.TargetPeers = def.TargetPeers
+	}
+	if cfg.MaxResponse <= 0 {
+		cfg.MaxResponse = def.MaxResponse
+	}
+	if cfg.MaxRequest <= 0 {
+		cfg.MaxRequest = def.MaxRequest
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.DialsPerTick <= 0 {
+		cfg.DialsPerTick = def.DialsPerTick
+	}
+	return cfg
+}
+
+// servePEX answers a PexRequest with a random sample of our dialable peers.
+func (n *Node) servePEX(_ context.Context, from string, payload json.RawMessage) (any, error) {
+	var req proto.PexRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &RPCError{Code: RPCBadRequest, Message: err.Error()}
+	}
+	max := n.pexConfig().MaxResponse
+	if req.Max > 0 && req.Max < max {
+		max = req.Max
+	}
+
+	var pool []proto.PeerInfo
+	for _, pi := range n.snapshotPeersInfo() {
+		if pi.ID != from && pi.Record != nil {
+			pool = append(pool, pi)
+		}
+	}
+	return proto.PexResponse{Peers: samplePeers(pool, max)}, nil
+}
+
+// samplePeers picks up to max entries at random, taking one per subnet in
+// turn so a single network cannot fill the response.
+func samplePeers(pool []proto.PeerInfo, max int) []proto.PeerInfo {
+	groups := make(map[string][]proto.PeerInfo)
+	var order []string
+	for _, pi := range pool {
+		g := subnetGroup(pi.Record.Addrs[0])
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], pi)
+	}
+	rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	for _, g := range order {
+		ps := groups[g]
+		rand.Shuffle(len(ps), func(i, j int) { ps[i], ps[j] = ps[j], ps[i] })
+	}
+
+	out := make([]proto.PeerInfo, 0, min(max, len(pool)))
+	for len(out) < max {
+		took := false
+		for _, g := range order {
+			if ps := groups[g]; len(ps) > 0 && len(out) < max {
+				out = append(out, ps[0])
+				groups[g] = ps[1:]
+				took = true
+			}
+		}
+		if !took {
+			break
+		}
+	}
+	return out
+}
+
+// requestPeers asks peerID for peers and files the signed ones in the
+// address book.
+func (n *Node) requestPeers(peerID string) {
+	cfg := n.pexConfig()
+	ctx, cancel := context.WithTimeout(n.ctx, DefaultRequestTimeout)
+	defer cancel()
+	raw, err := n.Request(ctx, peerID, pexProtocol, proto.PexRequest{Max: cfg.MaxRequest})
+	if err != nil {
+		n.Logf("pex: request to %s: %v", peerID, err)
+		return
+	}
+	var resp proto.PexResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		n.Logf("pex: bad response from %s: %v", peerID, err)
+		return
+	}
+	if len(resp.Peers) > cfg.MaxRequest {
+		resp.Peers = resp.Peers[:cfg.MaxRequest]
+	}
+	if n.learnPeers(peerID, resp.Peers) > 0 {
+		n.dialFromBook()
+	}
+}
+
+// learnPeers adds entries carrying a valid signed record to the address
+// book and returns how many were new.
+func (n *Node) learnPeers(from string, peers []proto.PeerInfo) int {
+	now := time.Now()
+	added := 0
+	for _, pi := range peers {
+		if pi.ID == n.id.ID {
+			continue
+		}
+		// Only addresses the peer signed itself are worth dialing.
+		if pi.Record == nil || pi.Record.NetworkID != pi.ID {
+			continue
+		}
+		if err := n.notePeerRecord(pi.Record); err != nil {
+			n.Logf("pex: record for %s from %s: %v", pi.ID, from, err)
+			continue
+		}
+		if n.addrs.add(pi.ID, from, now) {
+			added++
+		}
+	}
+	return added
+}
+
+// dialFromBook dials the best address book entries while we are under the
+// peer target.
+func (n *Node) dialFromBook() {
+	cfg := n.pexConfig()
+	want := min(cfg.TargetPeers-n.PeerCount(), cfg.DialsPerTick)
+	if want <= 0 {
+		return
+	}
+	now := time.Now()
+	skip := func(id string) bool { return n.hasPeer(id) || n.isBanned(id) }
+	for _, e := range n.addrs.candidates(want, now, skip) {
+		rec, ok := n.book.get(e.id, now)
+		if !ok {
+			n.addrs.remove(e.id) // record expired; wait to hear of it again
+			continue
+		}
+		addr := rec.Addrs[e.failures%len(rec.Addrs)]
+		n.addrs.attempted(e.id, now)
+		n.Logf("pex: dialing %s at %s", e.id, addr)
+		_ = n.connectExpecting(netx.Addr(addr), e.id)
+	}
+}
+
+// pexLoop keeps asking a random peer for more and dialing from the address
+// book while we are under the peer target.
+func (n *Node) pexLoop() {
+	cfg := n.pexConfig()
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+		}
+		if n.PeerCount() >= cfg.TargetPeers {
+			continue
+		}
+		n.dialFromBook()
+		if id := n.randomDirectPeer(); id != "" {
+			n.requestPeers(id)
+		}
+	}
+}
+
+func (n *Node) randomDirectPeer() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	ids := make([]string, 0, len(n.peers))
+	for id, p := range n.peers {
+		if !p.relayed {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[rand.Intn(len(ids))]
+}
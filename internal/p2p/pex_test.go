@@ -0,0 +1,90 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestSamplePeers_CapsAndSpreadsSubnets(t *testing.T) {
+	var pool []proto.PeerInfo
+	add := func(addr string) {
+		pool = append(pool, proto.PeerInfo{ID: addr, Record: &proto.PeerRecord{Addrs: []string{addr}}})
+	}
+	for i := 0; i < 20; i++ {
+		add(fmt.Sprintf("10.0.%d.1:4000", i)) // one crowded /16
+	}
+	add("192.0.2.1:4000")
+	add("198.51.100.1:4000")
+	add("[2001:db8::1]:4000")
+
+	got := samplePeers(pool, 5)
+	if len(got) != 5 {
+		t.Fatalf("got %d peers; want 5", len(got))
+	}
+	groups := make(map[string]bool)
+	for _, pi := range got {
+		groups[subnetGroup(pi.Record.Addrs[0])] = true
+	}
+	if len(groups) != 4 {
+		t.Fatalf("sample covers %d subnets; want all 4: %v", len(groups), got)
+	}
+}
+
+func TestAddrBook_BacksOffAfterFailedDial(t *testing.T) {
+	b := newAddrBook()
+	now := time.Now()
+	b.add("x", "src", now)
+	b.add("y", "src", now)
+	none := func(string) bool { return false }
+
+	b.attempted("x", now)
+	got := b.candidates(10, now.Add(time.Second), none)
+	if len(got) != 1 || got[0].id != "y" {
+		t.Fatalf("candidates right after a dial = %+v; want only y", got)
+	}
+	if got := b.candidates(10, now.Add(addrBackoffMin), none); len(got) != 2 {
+		t.Fatalf("candidates after backoff = %d; want 2", len(got))
+	}
+
+	b.connected("x", now)
+	b.attempted("y", now)
+	b.attempted("y", now)
+	if got := b.candidates(10, now.Add(3*addrBackoffMin), none); len(got) != 2 || got[0].id != "x" {
+		t.Fatalf("want the recently connected entry first, got %+v", got)
+	}
+}
+
+func TestPEX_LearnsPeersOnConnect(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	c := newTestNode(t, "C")
+	connect(t, c, b)
+	waitCond(t, "B to hold C's record", func() bool {
+		_, ok := b.PeerRecordFor(c.ID())
+		return ok
+	})
+
+	connect(t, a, b)
+	waitCond(t, "A to reach C through PEX", func() bool { return a.hasPeer(c.ID()) })
+}
+
+func TestPEX_ServeExcludesRequester(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	connect(t, a, b)
+	waitCond(t, "B to hold A's record", func() bool {
+		_, ok := b.PeerRecordFor(a.ID())
+		return ok
+	})
+
+	res, err := b.servePEX(t.Context(), a.ID(), proto.MustMarshal(proto.PexRequest{Max: 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := res.(proto.PexResponse).Peers; len(peers) != 0 {
+		t.Fatalf("requester was offered itself: %+v", peers)
+	}
+}
@@ -0,0 +1,119 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/netx/portmap"
+)
+
+// PortMapConfig controls asking the home router, over PCP or NAT-PMP, to
+// forward our listen port.
+type PortMapConfig struct {
+	Enabled  bool
+	Gateway  string        // "host[:port]"; empty uses the default route's gateway
+	Lifetime time.Duration // requested mapping lifetime; renewed half way through
+}
+
+func DefaultPortMapConfig() PortMapConfig {
+	return PortMapConfig{Lifetime: 2 * time.Hour}
+}
+
+const (
+	portMapTimeout      = 5 * time.Second
+	portMapRetry        = 5 * time.Minute
+	portMapUnmapTimeout = 2 * time.Second
+)
+
+func (n *Node) portMapConfig() PortMapConfig {
+	cfg := n.cfg.PortMap
+	if cfg.Lifetime <= 0 {
+		cfg.Lifetime = DefaultPortMapConfig().Lifetime
+	}
+	return cfg
+}
+
+// MappedAddr returns the external address the gateway forwards to us, or ""
+// if there is no port mapping.
+func (n *Node) MappedAddr() netx.Addr {
+	n.reach.mu.Lock()
+	defer n.reach.mu.Unlock()
+	return n.reach.mapped
+}
+
+// portMapLoop keeps a mapping for the listen port alive until the node stops,
+// then deletes it. done is closed once the gateway has been told.
+func (n *Node) portMapLoop(done chan struct{}) {
+	defer close(done)
+
+	cfg := n.portMapConfig()
+	client, err := portmap.NewClient(cfg.Gateway)
+	if err != nil {
+		n.Logf("portmap: %v", err)
+		return
+	}
+	port, err := portmap.PortOf(string(n.addr))
+	if err != nil {
+		n.Logf("portmap: listen address %s: %v", n.addr, err)
+		return
+	}
+
+	var m *portmap.Mapping
+	for {
+		ctx, cancel := context.WithTimeout(n.ctx, portMapTimeout)
+		var got *portmap.Mapping
+		if m == nil {
+			got, err = client.Map(ctx, port, cfg.Lifetime)
+		} else {
+			got, err = client.Renew(ctx, m, cfg.Lifetime)
+		}
+		cancel()
+
+		wait := portMapRetry
+		switch {
+		case n.ctx.Err() != nil:
+		case err != nil:
+			n.Logf("portmap: gateway %s: %v", client.Gateway(), err)
+			if m != nil && time.Now().After(m.Obtained.Add(m.Lifetime)) {
+				m = nil
+				n.setMappedAddr("")
+			}
+		default:
+			if m == nil || m.External != got.External {
+				n.Logf("portmap: %s maps %s -> :%d for %s", got.Protocol, got.External, port, got.Lifetime)
+			}
+			m = got
+			n.setMappedAddr(netx.Addr(m.External.String()))
+			wait = time.Until(m.RenewAt())
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-n.ctx.Done():
+			t.Stop()
+			if m != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), portMapUnmapTimeout)
+				if err := client.Unmap(ctx, m); err != nil {
+					n.Logf("portmap: unmap: %v", err)
+				}
+				cancel()
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// setMappedAddr records the gateway's external address for us and, if it
+// changed, re-identifies so peers advertise it.
+func (n *Node) setMappedAddr(addr netx.Addr) {
+	n.reach.mu.Lock()
+	changed := n.reach.mapped != addr
+	n.reach.mapped = addr
+	n.reach.mu.Unlock()
+	if changed {
+		n.emit(Event{Type: EventPortMapChanged, PeerAddr: string(addr)})
+		n.reidentify()
+	}
+}
@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"p2p-park/internal/netx/portmap"
+)
+
+func TestPortMap_AdvertisedAndReleasedOnStop(t *testing.T) {
+	gw, err := portmap.NewFakeGateway("203.0.113.7", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	a := newTestNode(t, "a", WithPortMap(gw.Addr()))
+	b := newTestNode(t, "b")
+
+	waitCond(t, "port mapping", func() bool { return a.MappedAddr() != "" })
+	mapped := a.MappedAddr()
+	if got := a.advertisedAddr(); got != mapped {
+		t.Fatalf("advertised = %q, want mapped %q", got, mapped)
+	}
+	if len(gw.Mappings()) != 1 {
+		t.Fatalf("gateway mappings = %v", gw.Mappings())
+	}
+
+	connect(t, b, a)
+	waitCond(t, "b to learn a's mapped address", func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		p := b.peers[a.ID()]
+		return p != nil && p.addr == mapped
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	if err := a.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if m := gw.Mappings(); len(m) != 0 {
+		t.Fatalf("mapping not removed on Stop: %v", m)
+	}
+}
+
+func TestPortMap_ExistingPeersReidentified(t *testing.T) {
+	gw, err := portmap.NewFakeGateway("203.0.113.7", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	b := newTestNode(t, "b")
+	a := newTestNode(t, "a", WithBootstraps(b.ListenAddr()), WithPortMap(gw.Addr()))
+
+	waitCond(t, "port mapping", func() bool { return a.MappedAddr() != "" })
+	waitCond(t, "b to learn a's mapped address", func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		p := b.peers[a.ID()]
+		return p != nil && p.addr == a.MappedAddr()
+	})
+}
@@ -0,0 +1,435 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"p2p-park/internal/crypto/noiseconn"
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+// Reachability says whether other nodes can dial us.
+type Reachability int
+
+const (
+	ReachUnknown Reachability = iota
+	ReachPublic               // peers in separate networks dialed our confirmed address and found us
+	ReachPrivate              // peers tried and failed; we are behind NAT or a firewall
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachPublic:
+		return "public"
+	case ReachPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+func parseReachability(s string) Reachability {
+	switch s {
+	case "public":
+		return ReachPublic
+	case "private":
+		return ReachPrivate
+	default:
+		return ReachUnknown
+	}
+}
+
+const (
+	reachFirstCheck     = 5 * time.Second
+	reachRecheck        = 10 * time.Minute
+	reachRetryUnknown   = 1 * time.Minute
+	reachProbePeers     = 3
+	reachConfirmations  = 2 // dial-backs from separate networks it takes to go public
+	reachDialTimeout    = 5 * time.Second
+	reachResultTimeout  = 10 * time.Second
+	reachMaxDialsServed = 4 // concurrent dial-backs we run for others
+)
+
+type reachState struct {
+	mu        sync.Mutex
+	observed  map[string]string // peer ID -> our host as that peer sees it
+	status    Reachability
+	confirmed netx.Addr
+	mapped    netx.Addr               // external address a PCP/NAT-PMP gateway forwards to us
+	pending   map[string]dialBackWait // dial-back nonce -> who was asked
+
+	serving chan struct{} // semaphore for dial-backs run for others
+}
+
+// dialBackWait is an outstanding dial-back request to peer.
+type dialBackWait struct {
+	peer    string
+	results chan<- dialBackOutcome
+}
+
+type dialBackOutcome struct {
+	group string // reachGroup of the peer that dialed
+	ok    bool
+}
+
+func newReachState() *reachState {
+	return &reachState{
+		observed: make(map[string]string),
+		pending:  make(map[string]dialBackWait),
+		serving:  make(chan struct{}, reachMaxDialsServed),
+	}
+}
+
+// Reachability returns whether peers can dial us and, if so, the external
+// address they confirmed.
+func (n *Node) Reachability() (Reachability, netx.Addr) {
+	n.reach.mu.Lock()
+	defer n.reach.mu.Unlock()
+	return n.reach.status, n.reach.confirmed
+}
+
+// advertisedAddr is the address we tell peers to dial: our external address
+// if we know one, else the listen address.
+func (n *Node) advertisedAddr() netx.Addr {
+	if ext := n.externalAddr(); ext != "" {
+		return ext
+	}
+	return n.addr
+}
+
+// externalAddr is the confirmed external address once dial-back found one,
+// else the gateway's port mapping, else "".
+func (n *Node) externalAddr() netx.Addr {
+	n.reach.mu.Lock()
+	defer n.reach.mu.Unlock()
+	if n.reach.status == ReachPublic && n.reach.confirmed != "" {
+		return n.reach.confirmed
+	}
+	return n.reach.mapped
+}
+
+// noteObservedAddr records the address a peer saw us connect from.
+func (n *Node) noteObservedAddr(p *peer, observed string) {
+	if p.relayed || observed == "" {
+		return
+	}
+	host, _, err := net.SplitHostPort(observed)
+	if err != nil || host == "" {
+		return
+	}
+	n.reach.mu.Lock()
+	n.reach.observed[p.id] = host
+	n.reach.mu.Unlock()
+}
+
+// reachCandidate picks the address to verify: a gateway port mapping, a
+// routable listen address as is, otherwise the host most peers observe us
+// at, on our listen port.
+func (n *Node) reachCandidate() netx.Addr {
+	if mapped := n.MappedAddr(); mapped != "" {
+		return mapped
+	}
+	host, port, err := net.SplitHostPort(string(n.addr))
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+		return n.addr
+	}
+
+	n.reach.mu.Lock()
+	votes := make(map[string]int)
+	for _, h := range n.reach.observed {
+		votes[h]++
+	}
+	n.reach.mu.Unlock()
+
+	hosts := make([]string, 0, len(votes))
+	for h := range votes {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if votes[hosts[i]] != votes[hosts[j]] {
+			return votes[hosts[i]] > votes[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+	if len(hosts) > 0 {
+		return netx.Addr(net.JoinHostPort(hosts[0], port))
+	}
+	if ip != nil && !ip.IsUnspecified() {
+		return n.addr
+	}
+	return ""
+}
+
+func (n *Node) reachabilityLoop() {
+	t := time.NewTimer(reachFirstCheck)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+		}
+		next := reachRecheck
+		if st := n.checkReachability(); st == ReachUnknown {
+			next = reachRetryUnknown
+		}
+		t.Reset(next)
+	}
+}
+
+// checkReachability asks a few peers to dial our candidate address and
+// updates the status from their answers: public once reachConfirmations of
+// them in different networks got through, private if some tried and none
+// did.
+func (n *Node) checkReachability() Reachability {
+	cand := n.reachCandidate()
+	if cand == "" {
+		st, _ := n.Reachability()
+		return st
+	}
+
+	n.mu.RLock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		if !p.relayed {
+			peers = append(peers, p)
+		}
+	}
+	n.mu.RUnlock()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	// Ask peers in different networks first: only their answers add up.
+	var first, rest []*peer
+	groups := make(map[string]bool)
+	for _, p := range peers {
+		if g := reachGroup(p); !groups[g] {
+			groups[g] = true
+			first = append(first, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	peers = append(first, rest...)
+	if len(peers) > reachProbePeers {
+		peers = peers[:reachProbePeers]
+	}
+
+	results := make(chan dialBackOutcome, len(peers))
+	nonces := make([]string, 0, len(peers))
+	for _, p := range peers {
+		nonce := NewMsgID()
+		nonces = append(nonces, nonce)
+
+		n.reach.mu.Lock()
+		n.reach.pending[nonce] = dialBackWait{peer: p.id, results: results}
+		n.reach.mu.Unlock()
+
+		n.sendAsync(p, proto.Envelope{
+			Type:    proto.MsgDialBack,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(proto.DialBack{Nonce: nonce, Addr: string(cand)}),
+		})
+	}
+	defer func() {
+		n.reach.mu.Lock()
+		for _, nonce := range nonces {
+			delete(n.reach.pending, nonce)
+		}
+		n.reach.mu.Unlock()
+	}()
+
+	// Any peer can claim it reached us, so being public takes the word of
+	// reachConfirmations of them in different networks.
+	confirmedBy := make(map[string]bool)
+	answered, failed := 0, 0
+	timeout := time.NewTimer(reachResultTimeout)
+	defer timeout.Stop()
+collect:
+	for answered < len(peers) && len(confirmedBy) < reachConfirmations {
+		select {
+		case r := <-results:
+			answered++
+			if r.ok {
+				confirmedBy[r.group] = true
+			} else {
+				failed++
+			}
+		case <-timeout.C:
+			break collect
+		case <-n.ctx.Done():
+			break collect
+		}
+	}
+
+	switch {
+	case len(confirmedBy) >= reachConfirmations:
+		n.setReachability(ReachPublic, cand)
+	case failed > 0 && len(confirmedBy) == 0:
+		n.setReachability(ReachPrivate, "")
+	}
+	st, _ := n.Reachability()
+	return st
+}
+
+// reachGroup is the network a dial-back from p vouches for: its subnet
+// group, or for peers on loopback or a private network, which share one
+// group whoever runs them, the peer itself.
+func reachGroup(p *peer) string {
+	host, _, err := net.SplitHostPort(string(p.observedAddr))
+	if err != nil {
+		return p.id
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() || ip.IsPrivate() {
+		return p.id
+	}
+	return subnetGroup(string(p.observedAddr))
+}
+
+// setReachability records a new status and, if it changed, re-identifies to
+// every peer so they advertise the right address for us.
+func (n *Node) setReachability(st Reachability, confirmed netx.Addr) {
+	n.reach.mu.Lock()
+	changed := n.reach.status != st || n.reach.confirmed != confirmed
+	n.reach.status = st
+	n.reach.confirmed = confirmed
+	n.reach.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	n.Logf("reachability: %s (external addr %q)", st, confirmed)
+	n.emit(Event{Type: EventReachabilityChanged, PeerAddr: string(confirmed), Detail: st.String()})
+	n.reidentify()
+}
+
+// reidentify re-sends Identify to every peer after our advertised address
+// or reachability changed.
+func (n *Node) reidentify() {
+	n.mu.RLock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.mu.RUnlock()
+	for _, p := range peers {
+		_ = n.sendIdentify(p)
+	}
+}
+
+func (n *Node) handleDialBack(p *peer, env proto.Envelope) {
+	var req proto.DialBack
+	if err := json.Unmarshal(env.Payload, &req); err != nil || req.Nonce == "" {
+		n.Logf("bad dial-back request from %s", p.id)
+		return
+	}
+	reply := func(err error) {
+		res := proto.DialBackResult{Nonce: req.Nonce, OK: err == nil}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		n.sendAsync(p, proto.Envelope{
+			Type:    proto.MsgDialBackResult,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(res),
+		})
+	}
+
+	select {
+	case n.reach.serving <- struct{}{}:
+	default:
+		reply(errors.New("busy"))
+		return
+	}
+	n.spawn("dial-back", func() {
+		defer func() { <-n.reach.serving }()
+		reply(n.dialBack(p, req.Addr))
+	})
+}
+
+// dialBack connects to addr and checks that p's Noise key answers there.
+// It refuses hosts other than the one p connects from, so it cannot be used
+// to make us dial arbitrary third parties.
+func (n *Node) dialBack(p *peer, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	obsHost, _, err := net.SplitHostPort(string(p.observedAddr))
+	if p.relayed || err != nil || obsHost != host {
+		return errors.New("address does not match observed host")
+	}
+
+	type dialed struct {
+		c   netx.Conn
+		err error
+	}
+	ch := make(chan dialed, 1)
+	n.spawn("dial-back dial", func() {
+		c, err := n.cfg.Network.Dial(netx.Addr(addr))
+		ch <- dialed{c, err}
+	})
+	var conn netx.Conn
+	select {
+	case d := <-ch:
+		if d.err != nil {
+			return d.err
+		}
+		conn = d.c
+	case <-time.After(reachDialTimeout):
+		n.spawn("dial-back dial", func() {
+			if d := <-ch; d.c != nil {
+				_ = d.c.Close()
+			}
+		})
+		return errors.New("dial timed out")
+	}
+	defer conn.Close()
+
+	if dc, ok := conn.(deadlineConn); ok {
+		_ = dc.SetReadDeadline(time.Now().Add(reachDialTimeout))
+	}
+	id := n.Identity()
+	payload, _ := json.Marshal(proto.NoiseIdentityPayload{Name: n.cfg.Name, UserPub: id.SignPub})
+	hs, err := noiseconn.NewSecureClient(conn, id.NoisePriv[:], id.NoisePub[:], payload)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hs.RemoteStatic) != p.id {
+		return errors.New("a different node answered")
+	}
+	return nil
+}
+
+func (n *Node) handleDialBackResult(p *peer, env proto.Envelope) {
+	var res proto.DialBackResult
+	if err := json.Unmarshal(env.Payload, &res); err != nil {
+		return
+	}
+	n.reach.mu.Lock()
+	w, ok := n.reach.pending[res.Nonce]
+	if ok && w.peer == p.id {
+		delete(n.reach.pending, res.Nonce)
+	}
+	n.reach.mu.Unlock()
+	if !ok || w.peer != p.id {
+		return // not a request we made of p
+	}
+	if !res.OK {
+		n.Logf("dial-back by %s failed: %s", p.id, res.Error)
+	}
+	select {
+	case w.results <- dialBackOutcome{group: reachGroup(p), ok: res.OK}:
+	default:
+	}
+}
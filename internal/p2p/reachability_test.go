@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"p2p-park/internal/netx"
+)
+
+func TestReachability_PublicOverLoopback(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	c := newTestNode(t, "c")
+	connect(t, a, b)
+	connect(t, a, c)
+	waitPeers(t, a, 2, 3*time.Second)
+
+	// Identify echoes back the address each peer sees us at.
+	waitCond(t, "observed addresses", func() bool {
+		a.reach.mu.Lock()
+		defer a.reach.mu.Unlock()
+		return len(a.reach.observed) == 2
+	})
+
+	if st := a.checkReachability(); st != ReachPublic {
+		t.Fatalf("reachability = %s, want public", st)
+	}
+	_, confirmed := a.Reachability()
+	if confirmed != a.ListenAddr() {
+		t.Fatalf("confirmed addr = %q, want %q", confirmed, a.ListenAddr())
+	}
+
+	// Peers pick up the confirmed address from the follow-up Identify.
+	waitCond(t, "b to learn a's confirmed address", func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		p := b.peers[a.ID()]
+		return p != nil && p.reach == ReachPublic && p.addr == confirmed
+	})
+}
+
+func TestReachability_PrivateBehindNAT(t *testing.T) {
+	hub := netx.NewMemHub()
+	b := newTestNode(t, "b", WithNetwork(hub.NewNetwork("192.0.2.1")))
+	c := newTestNode(t, "c", WithNetwork(hub.NewNetwork("192.0.2.2")))
+	a := newTestNode(t, "a", WithNetwork(hub.NewNAT("203.0.113.10").NewNetwork("10.0.0.2")))
+	connect(t, a, b)
+	connect(t, a, c)
+	waitPeers(t, a, 2, 3*time.Second)
+	waitCond(t, "observed addresses", func() bool {
+		a.reach.mu.Lock()
+		defer a.reach.mu.Unlock()
+		return len(a.reach.observed) == 2
+	})
+
+	if cand := a.reachCandidate(); cand == "" || cand == a.ListenAddr() {
+		t.Fatalf("candidate = %q, want the NAT's public host", cand)
+	}
+	if st := a.checkReachability(); st != ReachPrivate {
+		t.Fatalf("reachability = %s, want private", st)
+	}
+
+	// b must not hand a's unreachable address to others.
+	waitCond(t, "b to learn a is private", func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		p := b.peers[a.ID()]
+		return p != nil && p.reach == ReachPrivate
+	})
+	for _, pi := range b.snapshotPeersInfo() {
+		if pi.ID == a.ID() {
+			t.Fatalf("private peer advertised in PeerList: %+v", pi)
+		}
+	}
+}
+
+func TestDialBack_RefusesForeignHost(t *testing.T) {
+	a := newTestNode(t, "a")
+	b := newTestNode(t, "b")
+	connect(t, a, b)
+	waitPeers(t, b, 1, 3*time.Second)
+
+	b.mu.RLock()
+	pa := b.peers[a.ID()]
+	b.mu.RUnlock()
+
+	if err := b.dialBack(pa, "198.51.100.7:4000"); err == nil {
+		t.Fatalf("dial-back to a host other than the requester's must be refused")
+	}
+}
+
+func TestReachability_PublicNeedsPeersInSeparateNetworks(t *testing.T) {
+	hub := netx.NewMemHub()
+	a := newTestNode(t, "a", WithNetwork(hub.NewNetwork("198.51.100.10")))
+	b := newTestNode(t, "b", WithNetwork(hub.NewNetwork("192.0.2.1")))
+	c := newTestNode(t, "c", WithNetwork(hub.NewNetwork("192.0.2.2")))
+	connect(t, a, b)
+	connect(t, a, c)
+	waitPeers(t, a, 2, 3*time.Second)
+	waitCond(t, "observed addresses", func() bool {
+		a.reach.mu.Lock()
+		defer a.reach.mu.Unlock()
+		return len(a.reach.observed) == 2
+	})
+
+	// b and c share a /16: however many of them agree, it counts once.
+	if st := a.checkReachability(); st != ReachUnknown {
+		t.Fatalf("reachability = %s on the word of one network, want unknown", st)
+	}
+
+	d := newTestNode(t, "d", WithNetwork(hub.NewNetwork("203.0.113.5")))
+	connect(t, a, d)
+	waitPeers(t, a, 3, 3*time.Second)
+	if st := a.checkReachability(); st != ReachPublic {
+		t.Fatalf("reachability = %s, want public", st)
+	}
+}
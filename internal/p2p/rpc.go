@@ -0,0 +1,250 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+const (
+	DefaultRequestTimeout = 10 * time.Second // used when the caller's ctx has no deadline
+	maxRequestTimeout     = 2 * time.Minute  // cap on the deadline a caller can impose on us
+
+	maxRequestsInflightPerPeer = 8 // our requests awaiting a response from one peer
+	maxRequestsServedPerPeer   = 8 // one peer's requests we run handlers for at once
+)
+
+// Error codes the RPC layer itself replies with. Handlers may use their own.
+const (
+	RPCUnknownProtocol = "unknown_protocol"
+	RPCBusy            = "busy"
+	RPCBadRequest      = "bad_request"
+	RPCInternal        = "internal" // handler failed with a plain error
+)
+
+// RPCError is an error reply from the remote side of a Request. Handlers
+// return one to choose the code the caller sees. errors.Is matches on Code,
+// so callers can test against ErrUnknownProtocol and friends.
+type RPCError struct {
+	Code    string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	if e.Message == "" {
+		return "rpc: " + e.Code
+	}
+	return "rpc: " + e.Code + ": " + e.Message
+}
+
+func (e *RPCError) Is(target error) bool {
+	t, ok := target.(*RPCError)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrUnknownProtocol = &RPCError{Code: RPCUnknownProtocol}
+	ErrRemoteBusy      = &RPCError{Code: RPCBusy}
+	ErrBadRequest      = &RPCError{Code: RPCBadRequest}
+
+	ErrTooManyRequests = errors.New("rpc: too many requests in flight to peer")
+	ErrPeerGone        = errors.New("rpc: peer disconnected")
+)
+
+// RequestHandler serves one protocol. from is the caller's network ID and
+// ctx ends when the caller stops waiting or disconnects. The result is JSON
+// encoded into the response.
+type RequestHandler func(ctx context.Context, from string, payload json.RawMessage) (any, error)
+
+type pendingRequest struct {
+	peer *peer
+	ch   chan proto.Response
+}
+
+type rpcState struct {
+	mu       sync.Mutex
+	handlers map[string]RequestHandler
+	pending  map[string]*pendingRequest
+	inflight map[*peer]int // our requests per peer
+	served   map[*peer]int // handlers running per peer
+}
+
+func newRPCState() *rpcState {
+	return &rpcState{
+		handlers: make(map[string]RequestHandler),
+		pending:  make(map[string]*pendingRequest),
+		inflight: make(map[*peer]int),
+		served:   make(map[*peer]int),
+	}
+}
+
+// HandleRequest registers h for protocol, replacing any previous handler.
+// A nil h unregisters it.
+func (n *Node) HandleRequest(protocol string, h RequestHandler) {
+	n.rpc.mu.Lock()
+	defer n.rpc.mu.Unlock()
+	if h == nil {
+		delete(n.rpc.handlers, protocol)
+		return
+	}
+	n.rpc.handlers[protocol] = h
+}
+
+// Request sends payload to peerID's handler for protocol and waits for the
+// response. It fails with an *RPCError if the remote replied with one, with
+// ErrPeerGone if the peer disconnects first, and with the context's error
+// when ctx ends; without a deadline on ctx, DefaultRequestTimeout applies.
+func (n *Node) Request(ctx context.Context, peerID, protocol string, payload any) (json.RawMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.RLock()
+	p := n.peers[peerID]
+	n.mu.RUnlock()
+	if p == nil {
+		return nil, fmt.Errorf("unknown peer %q", peerID)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	id := NewMsgID()
+	pr := &pendingRequest{peer: p, ch: make(chan proto.Response, 1)}
+	n.rpc.mu.Lock()
+	if n.rpc.inflight[p] >= maxRequestsInflightPerPeer {
+		n.rpc.mu.Unlock()
+		return nil, ErrTooManyRequests
+	}
+	n.rpc.inflight[p]++
+	n.rpc.pending[id] = pr
+	n.rpc.mu.Unlock()
+	defer func() {
+		n.rpc.mu.Lock()
+		delete(n.rpc.pending, id)
+		if n.rpc.inflight[p]--; n.rpc.inflight[p] <= 0 {
+			delete(n.rpc.inflight, p)
+		}
+		n.rpc.mu.Unlock()
+	}()
+
+	n.sendAsync(p, proto.Envelope{
+		Type:   proto.MsgRequest,
+		FromID: n.id.ID,
+		Payload: proto.MustMarshal(proto.Request{
+			ID:        id,
+			Protocol:  protocol,
+			Payload:   body,
+			TimeoutMs: max(time.Until(deadline).Milliseconds(), 1),
+		}),
+	})
+
+	select {
+	case resp := <-pr.ch:
+		if resp.Error != nil {
+			return nil, &RPCError{Code: resp.Error.Code, Message: resp.Error.Message}
+		}
+		return resp.Payload, nil
+	case <-p.ctx.Done():
+		return nil, ErrPeerGone
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (n *Node) handleRequest(p *peer, env proto.Envelope) {
+	var req proto.Request
+	if err := json.Unmarshal(env.Payload, &req); err != nil || req.ID == "" {
+		n.Logf("bad request from %s", p.id)
+		return
+	}
+	reply := func(result any, err error) {
+		resp := proto.Response{ID: req.ID}
+		if err == nil {
+			if resp.Payload, err = json.Marshal(result); err != nil {
+				err = &RPCError{Code: RPCInternal, Message: "encode response: " + err.Error()}
+			}
+		}
+		if err != nil {
+			var re *RPCError
+			if !errors.As(err, &re) {
+				re = &RPCError{Code: RPCInternal, Message: err.Error()}
+			}
+			resp.Payload = nil
+			resp.Error = &proto.RPCError{Code: re.Code, Message: re.Message}
+		}
+		n.sendAsync(p, proto.Envelope{
+			Type:    proto.MsgResponse,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(resp),
+		})
+	}
+
+	n.rpc.mu.Lock()
+	h := n.rpc.handlers[req.Protocol]
+	busy := n.rpc.served[p] >= maxRequestsServedPerPeer
+	if h != nil && !busy {
+		n.rpc.served[p]++
+	}
+	n.rpc.mu.Unlock()
+
+	switch {
+	case h == nil:
+		reply(nil, &RPCError{Code: RPCUnknownProtocol, Message: req.Protocol})
+		return
+	case busy:
+		reply(nil, ErrRemoteBusy)
+		return
+	}
+
+	timeout := DefaultRequestTimeout
+	if req.TimeoutMs > 0 {
+		timeout = min(time.Duration(req.TimeoutMs)*time.Millisecond, maxRequestTimeout)
+	}
+	n.spawn("rpc handler", func() {
+		defer func() {
+			n.rpc.mu.Lock()
+			if n.rpc.served[p]--; n.rpc.served[p] <= 0 {
+				delete(n.rpc.served, p)
+			}
+			n.rpc.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(p.ctx, timeout)
+		defer cancel()
+
+		result, err := h(ctx, p.id, req.Payload)
+		if ctx.Err() != nil {
+			return // the caller has given up; a late reply would only be dropped
+		}
+		reply(result, err)
+	})
+}
+
+func (n *Node) handleResponse(p *peer, env proto.Envelope) {
+	var resp proto.Response
+	if err := json.Unmarshal(env.Payload, &resp); err != nil {
+		n.Logf("bad response from %s: %v", p.id, err)
+		return
+	}
+	n.rpc.mu.Lock()
+	pr := n.rpc.pending[resp.ID]
+	if pr != nil && pr.peer == p {
+		delete(n.rpc.pending, resp.ID)
+	} else {
+		pr = nil
+	}
+	n.rpc.mu.Unlock()
+	if pr == nil {
+		return
+	}
+	pr.ch <- resp
+}
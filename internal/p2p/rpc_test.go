@@ -0,0 +1,142 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func rpcPair(t *testing.T) (a, b *Node) {
+	t.Helper()
+	// A one-peer target keeps connect-time peer exchange from taking
+	// request slots the limit tests count on.
+	a = newTestNode(t, "a", WithPEX(PEXConfig{TargetPeers: 1}))
+	b = newTestNode(t, "b", WithPEX(PEXConfig{TargetPeers: 1}))
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+	waitPeers(t, b, 1, 3*time.Second)
+	return a, b
+}
+
+func TestRequest_RoundTripAndErrors(t *testing.T) {
+	a, b := rpcPair(t)
+
+	b.HandleRequest("echo", func(_ context.Context, from string, payload json.RawMessage) (any, error) {
+		var s string
+		if err := json.Unmarshal(payload, &s); err != nil {
+			return nil, &RPCError{Code: RPCBadRequest, Message: err.Error()}
+		}
+		return from + ":" + s, nil
+	})
+	b.HandleRequest("fail", func(context.Context, string, json.RawMessage) (any, error) {
+		return nil, errors.New("boom")
+	})
+	b.HandleRequest("typed", func(context.Context, string, json.RawMessage) (any, error) {
+		return nil, &RPCError{Code: "not_found", Message: "no such thing"}
+	})
+
+	ctx := context.Background()
+	raw, err := a.Request(ctx, b.ID(), "echo", "hi")
+	if err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	var got string
+	if err := json.Unmarshal(raw, &got); err != nil || got != a.ID()+":hi" {
+		t.Fatalf("echo = %s (%v)", raw, err)
+	}
+
+	if _, err := a.Request(ctx, b.ID(), "echo", 42); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("bad payload: err = %v, want bad_request", err)
+	}
+	if _, err := a.Request(ctx, b.ID(), "nope", nil); !errors.Is(err, ErrUnknownProtocol) {
+		t.Fatalf("unknown protocol: err = %v", err)
+	}
+
+	_, err = a.Request(ctx, b.ID(), "fail", nil)
+	var re *RPCError
+	if !errors.As(err, &re) || re.Code != RPCInternal || re.Message != "boom" {
+		t.Fatalf("plain handler error: err = %v", err)
+	}
+	_, err = a.Request(ctx, b.ID(), "typed", nil)
+	if !errors.As(err, &re) || re.Code != "not_found" {
+		t.Fatalf("typed handler error: err = %v", err)
+	}
+
+	if _, err := a.Request(ctx, "no-such-peer", "echo", "hi"); err == nil {
+		t.Fatalf("request to unknown peer succeeded")
+	}
+}
+
+func TestRequest_DeadlinePropagates(t *testing.T) {
+	a, b := rpcPair(t)
+
+	handlerDone := make(chan error, 1)
+	b.HandleRequest("slow", func(ctx context.Context, _ string, _ json.RawMessage) (any, error) {
+		<-ctx.Done()
+		handlerDone <- ctx.Err()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := a.Request(ctx, b.ID(), "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("request took %s despite a 150ms deadline", d)
+	}
+
+	select {
+	case err := <-handlerDone:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("handler ctx err = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler context was not cancelled at the caller's deadline")
+	}
+}
+
+func TestRequest_InflightLimitAndPeerGone(t *testing.T) {
+	a, b := rpcPair(t)
+
+	started := make(chan struct{}, maxRequestsInflightPerPeer)
+	b.HandleRequest("block", func(ctx context.Context, _ string, _ json.RawMessage) (any, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	errs := make(chan error, maxRequestsInflightPerPeer)
+	for range maxRequestsInflightPerPeer {
+		go func() {
+			_, err := a.Request(context.Background(), b.ID(), "block", nil)
+			errs <- err
+		}()
+	}
+	for range maxRequestsInflightPerPeer {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("blocked handlers did not start")
+		}
+	}
+
+	if _, err := a.Request(context.Background(), b.ID(), "block", nil); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("request over the inflight limit: err = %v", err)
+	}
+
+	_ = b.Stop(context.Background())
+	for range maxRequestsInflightPerPeer {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrPeerGone) {
+				t.Fatalf("pending request after disconnect: err = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pending requests not failed after disconnect")
+		}
+	}
+}
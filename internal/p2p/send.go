@@ -3,6 +3,7 @@ package p2p
 import (
 	"fmt"
 	"p2p-park/internal/proto"
+	"time"
 )
 
 type SendPolicy int
@@ -10,9 +11,12 @@ type SendPolicy int
 const (
 	SendDrop       SendPolicy = iota // drop this message if buffer full
 	SendDisconnect                   // drop peer if buffer full
+	SendDropOldest                   // evict the oldest queued message to make room
 )
 
-func (n *Node) sendAsyncWithPolicy(p *peer, env proto.Envelope, policy SendPolicy) {
+// sendAsyncClass queues env on p's send queue for class.
+// The queue's overflow policy decides what happens when it is full.
+func (n *Node) sendAsyncClass(p *peer, class SendClass, env proto.Envelope) {
 	select {
 	case <-p.ctx.Done():
 		// peer is closing; just drop
@@ -20,32 +24,21 @@ func (n *Node) sendAsyncWithPolicy(p *peer, env proto.Envelope, policy SendPolic
 	default:
 	}
 
-	select {
-	case p.sendCh <- env:
-		return
-	default:
-		if policy == SendDisconnect {
-			// Important: do not call removePeer synchronously here.
-			go n.removePeer(p.id)
-			n.Logf("dropping peer %s: send buffer full", p.id)
+	switch p.sendq.push(class, env) {
+	case pushDisconnect:
+		// Important: do not call removePeer synchronously here.
+		go n.dropPeer(p)
+		n.Logf("dropping peer %s: %s send queue full", p.id, class)
+	case pushDropped, pushEvicted:
+		if k := p.sendq.takeDrops(class, time.Now()); k > 0 {
+			n.Logf("send to %s: %s queue full, dropped %d envelopes", p.id, class, k)
 		}
-		// else: drop the message
 	}
 }
 
+// sendAsync queues env on the send queue matching its message type.
 func (n *Node) sendAsync(p *peer, env proto.Envelope) {
-	n.sendAsyncWithPolicy(p, env, SendDisconnect)
-}
-
-func (n *Node) sendPeerList(p *peer) error {
-	pl := proto.PeerList{Peers: n.snapshotPeersInfo()}
-	env := proto.Envelope{
-		Type:    proto.MsgPeerList,
-		FromID:  n.id.ID,
-		Payload: proto.MustMarshal(pl),
-	}
-	n.sendAsync(p, env)
-	return nil
+	n.sendAsyncClass(p, classify(env), env)
 }
 
 // SendToPeer sends an envelope to a peer by network ID.
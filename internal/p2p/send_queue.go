@@ -0,0 +1,254 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+// SendClass selects which per-peer queue an outbound envelope goes through.
+type SendClass int
+
+const (
+	ClassControl SendClass = iota // handshake follow-ups, identify, NAT registration, keepalives
+	ClassDHT                      // DHT RPC requests and replies
+	ClassSync                     // request/response RPCs (grant sync, ...) and relay circuits
+	ClassGossip                   // bulk gossip, relayed payloads
+
+	numSendClasses
+)
+
+func (c SendClass) String() string {
+	switch c {
+	case ClassControl:
+		return "control"
+	case ClassDHT:
+		return "dht"
+	case ClassSync:
+		return "sync"
+	case ClassGossip:
+		return "gossip"
+	default:
+		return "unknown"
+	}
+}
+
+// QueueConfig configures one per-peer send queue.
+type QueueConfig struct {
+	Capacity int        // max queued envelopes
+	Weight   int        // share of write turns relative to the other queues
+	Policy   SendPolicy // what to do when the queue is full
+}
+
+// SendQueuesConfig configures the per-peer send scheduler.
+type SendQueuesConfig struct {
+	Control QueueConfig
+	DHT     QueueConfig
+	Sync    QueueConfig
+	Gossip  QueueConfig
+}
+
+func DefaultSendQueuesConfig() SendQueuesConfig {
+	return SendQueuesConfig{
+		Control: QueueConfig{Capacity: 32, Weight: 8, Policy: SendDisconnect},
+		DHT:     QueueConfig{Capacity: 128, Weight: 4, Policy: SendDrop},
+		Sync:    QueueConfig{Capacity: 64, Weight: 2, Policy: SendDrop},
+		Gossip:  QueueConfig{Capacity: 256, Weight: 1, Policy: SendDropOldest},
+	}
+}
+
+func (c SendQueuesConfig) byClass() [numSendClasses]QueueConfig {
+	def := DefaultSendQueuesConfig()
+	out := [numSendClasses]QueueConfig{c.Control, c.DHT, c.Sync, c.Gossip}
+	defs := [numSendClasses]QueueConfig{def.Control, def.DHT, def.Sync, def.Gossip}
+	for i := range out {
+		if out[i] == (QueueConfig{}) {
+			out[i] = defs[i]
+			continue
+		}
+		if out[i].Capacity <= 0 {
+			out[i].Capacity = defs[i].Capacity
+		}
+		if out[i].Weight <= 0 {
+			out[i].Weight = defs[i].Weight
+		}
+	}
+	return out
+}
+
+// classify maps an envelope to the queue it should be sent through.
+func classify(env proto.Envelope) SendClass {
+	switch env.Type {
+	case proto.MsgHello, proto.MsgIdentify, proto.MsgNatRegister, proto.MsgPing, proto.MsgPong,
+		proto.MsgPunchRequest, proto.MsgPunchSync, proto.MsgDialBack, proto.MsgDialBackResult,
+		proto.MsgUnsupported, proto.MsgGoodbye:
+		return ClassControl
+	case proto.MsgDHT:
+		return ClassDHT
+	case proto.MsgRequest, proto.MsgResponse, proto.MsgCircuitOpen, proto.MsgCircuitData, proto.MsgCircuitClose:
+		return ClassSync
+	default:
+		return ClassGossip
+	}
+}
+
+// sendQueue is a weighted round-robin scheduler over one FIFO per SendClass.
+// Each class gets up to Weight envelopes per round, so a gossip burst can
+// delay but never starve control or DHT traffic.
+type sendQueue struct {
+	mu      sync.Mutex
+	cfg     [numSendClasses]QueueConfig
+	queues  [numSendClasses][]proto.Envelope
+	credits [numSendClasses]int
+	writing bool // the writer holds an envelope it has not finished sending
+
+	dropped    [numSendClasses]int       // envelopes dropped or evicted since the last report
+	reportedAt [numSendClasses]time.Time // when drops were last reported
+
+	ready chan struct{} // signalled (non-blocking) on every push
+}
+
+func newSendQueue(cfg SendQueuesConfig) *sendQueue {
+	q := &sendQueue{
+		cfg:   cfg.byClass(),
+		ready: make(chan struct{}, 1),
+	}
+	q.refill()
+	return q
+}
+
+// pushResult reports what push did with the envelope.
+type pushResult int
+
+const (
+	pushQueued     pushResult = iota // enqueued normally
+	pushDropped                      // queue full, envelope dropped
+	pushEvicted                      // queue full, oldest envelope evicted to make room
+	pushDisconnect                   // queue full and policy says drop the peer
+)
+
+func (q *sendQueue) push(class SendClass, env proto.Envelope) pushResult {
+	if class < 0 || class >= numSendClasses {
+		class = ClassGossip
+	}
+
+	q.mu.Lock()
+	res := pushQueued
+	cfg := q.cfg[class]
+	if len(q.queues[class]) >= cfg.Capacity {
+		switch cfg.Policy {
+		case SendDisconnect:
+			q.mu.Unlock()
+			return pushDisconnect
+		case SendDropOldest:
+			q.queues[class] = q.queues[class][1:]
+			q.dropped[class]++
+			res = pushEvicted
+		default:
+			q.dropped[class]++
+			q.mu.Unlock()
+			return pushDropped
+		}
+	}
+	q.queues[class] = append(q.queues[class], env)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return res
+}
+
+// next blocks until an envelope is available or ctx is done. The writer
+// calls sent once the envelope is on the wire.
+func (q *sendQueue) next(ctx context.Context) (proto.Envelope, bool) {
+	for {
+		if env, ok := q.pop(); ok {
+			return env, true
+		}
+		select {
+		case <-ctx.Done():
+			return proto.Envelope{}, false
+		case <-q.ready:
+		}
+	}
+}
+
+func (q *sendQueue) pop() (proto.Envelope, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for pass := 0; pass < 2; pass++ {
+		for c := SendClass(0); c < numSendClasses; c++ {
+			if len(q.queues[c]) == 0 || q.credits[c] <= 0 {
+				continue
+			}
+			env := q.queues[c][0]
+			q.queues[c][0] = proto.Envelope{}
+			q.queues[c] = q.queues[c][1:]
+			q.credits[c]--
+			q.writing = true
+			return env, true
+		}
+		// Every non-empty class spent its credits this round.
+		q.refill()
+	}
+	return proto.Envelope{}, false
+}
+
+func (q *sendQueue) refill() {
+	for c := range q.credits {
+		q.credits[c] = q.cfg[c].Weight
+	}
+}
+
+func (q *sendQueue) sent() {
+	q.mu.Lock()
+	q.writing = false
+	q.mu.Unlock()
+}
+
+// sendDropReportEvery bounds how often a full queue is reported, so a burst
+// costs one log line rather than one per envelope.
+const sendDropReportEvery = 10 * time.Second
+
+// takeDrops returns how many envelopes class dropped since it was last
+// reported, and resets the count, unless it was reported within
+// sendDropReportEvery; then it returns 0 and keeps counting.
+func (q *sendQueue) takeDrops(class SendClass, now time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if class < 0 || class >= numSendClasses || now.Sub(q.reportedAt[class]) < sendDropReportEvery {
+		return 0
+	}
+	k := q.dropped[class]
+	q.dropped[class] = 0
+	if k > 0 {
+		q.reportedAt[class] = now
+	}
+	return k
+}
+
+// pending returns how many envelopes are queued or being written.
+func (q *sendQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k := 0
+	for _, queue := range q.queues {
+		k += len(queue)
+	}
+	if q.writing {
+		k++
+	}
+	return k
+}
+
+// queued returns the number of envelopes waiting in class.
+func (q *sendQueue) queued(class SendClass) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[class])
+}
@@ -0,0 +1,146 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestSendQueue_GossipBurstDoesNotStarveDHT(t *testing.T) {
+	q := newSendQueue(DefaultSendQueuesConfig())
+
+	for i := 0; i < 1000; i++ {
+		q.push(ClassGossip, proto.Envelope{Type: proto.MsgGossip})
+	}
+	if got := q.queued(ClassGossip); got != DefaultSendQueuesConfig().Gossip.Capacity {
+		t.Fatalf("gossip queue should be capped at capacity, got %d", got)
+	}
+
+	if res := q.push(ClassDHT, proto.Envelope{Type: proto.MsgDHT}); res != pushQueued {
+		t.Fatalf("DHT push should be queued despite gossip burst, got %v", res)
+	}
+
+	env, ok := q.pop()
+	if !ok || env.Type != proto.MsgDHT {
+		t.Fatalf("expected DHT envelope first, got %q ok=%v", env.Type, ok)
+	}
+}
+
+func TestSendQueue_WeightedRoundRobin(t *testing.T) {
+	q := newSendQueue(SendQueuesConfig{
+		Control: QueueConfig{Capacity: 16, Weight: 2, Policy: SendDrop},
+		DHT:     QueueConfig{Capacity: 16, Weight: 1, Policy: SendDrop},
+		Sync:    QueueConfig{Capacity: 16, Weight: 1, Policy: SendDrop},
+		Gossip:  QueueConfig{Capacity: 16, Weight: 1, Policy: SendDrop},
+	})
+	for i := 0; i < 4; i++ {
+		q.push(ClassControl, proto.Envelope{Type: proto.MsgIdentify})
+		q.push(ClassGossip, proto.Envelope{Type: proto.MsgGossip})
+	}
+
+	want := []proto.MessageType{
+		proto.MsgIdentify, proto.MsgIdentify, proto.MsgGossip,
+		proto.MsgIdentify, proto.MsgIdentify, proto.MsgGossip,
+		proto.MsgGossip, proto.MsgGossip,
+	}
+	for i, w := range want {
+		env, ok := q.pop()
+		if !ok {
+			t.Fatalf("pop %d: queue unexpectedly empty", i)
+		}
+		if env.Type != w {
+			t.Fatalf("pop %d: got %q want %q", i, env.Type, w)
+		}
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatalf("expected empty queue")
+	}
+}
+
+func TestSendQueue_OverflowPolicies(t *testing.T) {
+	q := newSendQueue(SendQueuesConfig{
+		Control: QueueConfig{Capacity: 1, Weight: 1, Policy: SendDisconnect},
+		DHT:     QueueConfig{Capacity: 1, Weight: 1, Policy: SendDrop},
+		Gossip:  QueueConfig{Capacity: 1, Weight: 1, Policy: SendDropOldest},
+	})
+
+	q.push(ClassControl, proto.Envelope{})
+	if res := q.push(ClassControl, proto.Envelope{}); res != pushDisconnect {
+		t.Fatalf("control overflow: got %v want disconnect", res)
+	}
+
+	q.push(ClassDHT, proto.Envelope{FromID: "first"})
+	if res := q.push(ClassDHT, proto.Envelope{FromID: "second"}); res != pushDropped {
+		t.Fatalf("dht overflow: got %v want dropped", res)
+	}
+
+	q.push(ClassGossip, proto.Envelope{FromID: "old"})
+	if res := q.push(ClassGossip, proto.Envelope{FromID: "new"}); res != pushEvicted {
+		t.Fatalf("gossip overflow: got %v want evicted", res)
+	}
+	q.mu.Lock()
+	got := q.queues[ClassGossip][0].FromID
+	q.mu.Unlock()
+	if got != "new" {
+		t.Fatalf("drop-oldest should keep newest, got %q", got)
+	}
+}
+
+func TestSendQueue_NextUnblocksOnPushAndCancel(t *testing.T) {
+	q := newSendQueue(DefaultSendQueuesConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	got := make(chan proto.Envelope, 1)
+	go func() {
+		env, ok := q.next(ctx)
+		if ok {
+			got <- env
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.push(ClassSync, proto.Envelope{Type: proto.MsgRequest})
+
+	select {
+	case env := <-got:
+		if env.Type != proto.MsgRequest {
+			t.Fatalf("unexpected envelope %q", env.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("next did not return after push")
+	}
+
+	cancel()
+	if _, ok := q.next(ctx); ok {
+		t.Fatalf("next should report false after cancel on empty queue")
+	}
+}
+
+func TestSendQueue_DropsAreReportedInBatches(t *testing.T) {
+	q := newSendQueue(SendQueuesConfig{
+		DHT:    QueueConfig{Capacity: 1, Weight: 1, Policy: SendDrop},
+		Gossip: QueueConfig{Capacity: 1, Weight: 1, Policy: SendDropOldest},
+	})
+	now := time.Now()
+
+	q.push(ClassDHT, proto.Envelope{})
+	q.push(ClassDHT, proto.Envelope{})
+	if got := q.takeDrops(ClassDHT, now); got != 1 {
+		t.Fatalf("first report: %d drops; want 1", got)
+	}
+	for i := 0; i < 100; i++ {
+		q.push(ClassDHT, proto.Envelope{})
+		q.push(ClassGossip, proto.Envelope{})
+	}
+	if got := q.takeDrops(ClassDHT, now.Add(time.Second)); got != 0 {
+		t.Fatalf("reported %d drops within the interval", got)
+	}
+	if got := q.takeDrops(ClassDHT, now.Add(sendDropReportEvery)); got != 100 {
+		t.Fatalf("next report: %d drops; want 100", got)
+	}
+	if got := q.takeDrops(ClassGossip, now); got != 99 {
+		t.Fatalf("gossip evictions: %d; want 99", got)
+	}
+}
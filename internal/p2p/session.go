@@ -7,8 +7,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"p2p-park/internal/crypto/noiseconn"
+	"p2p-park/internal/dht"
 	"p2p-park/internal/netx"
 	"p2p-park/internal/proto"
 	"time"
@@ -18,7 +20,9 @@ type deadlineConn interface {
 	SetReadDeadline(t time.Time) error
 }
 
-func (n *Node) establishPeer(rawConn netx.Conn, inbound bool) (*peer, io.Closer, error) {
+// establishPeer secures rawConn and registers the peer. want, if set, is
+// the network ID the connection must turn out to belong to.
+func (n *Node) establishPeer(rawConn netx.Conn, inbound bool, want string) (*peer, io.Closer, error) {
 	id := n.Identity()
 
 	ip := proto.NoiseIdentityPayload{
@@ -42,6 +46,12 @@ func (n *Node) establishPeer(rawConn netx.Conn, inbound bool) (*peer, io.Closer,
 
 	secure := hs.Conn
 
+	// A key that does not solve the network's ID puzzle is not one of us.
+	if _, err := dht.CheckPeerID(hex.EncodeToString(hs.RemoteStatic), n.cfg.IDDifficulty); err != nil {
+		_ = secure.Close()
+		return nil, nil, err
+	}
+
 	// decode remote payload
 	var remoteName string
 	var remoteUserPub ed25519.PublicKey
@@ -53,16 +63,22 @@ func (n *Node) establishPeer(rawConn netx.Conn, inbound bool) (*peer, io.Closer,
 			_ = secure.Close()
 			return nil, nil, err
 		}
+		if len(rip.UserPub) > 0 && !userOwnsNetworkID(hex.EncodeToString(hs.RemoteStatic), rip.UserPub) {
+			_ = secure.Close()
+			return nil, nil, errors.New("user key does not own the network key")
+		}
 		remoteName = rip.Name
 		remoteUserPub = ed25519.PublicKey(rip.UserPub)
 		remoteUserID = hex.EncodeToString(remoteUserPub)
 	}
 
 	dec := json.NewDecoder(bufio.NewReader(secure))
-	enc := json.NewEncoder(secure)
+	out := &countingWriter{w: secure}
+	enc := json.NewEncoder(out)
 
 	// hello handshake
-	if err := n.sendHello(enc); err != nil {
+	localNonce := NewMsgID()
+	if err := n.sendHello(enc, localNonce); err != nil {
 		_ = secure.Close()
 		return nil, nil, err
 	}
@@ -84,27 +100,59 @@ func (n *Node) establishPeer(rawConn netx.Conn, inbound bool) (*peer, io.Closer,
 	}
 
 	peerID := env.FromID
+	if peerID != hex.EncodeToString(hs.RemoteStatic) {
+		_ = secure.Close()
+		return nil, nil, errors.New("hello from a network ID the handshake did not prove")
+	}
+	if want != "" && peerID != want {
+		_ = secure.Close()
+		return nil, nil, fmt.Errorf("dialed %s but reached %s", want, peerID)
+	}
+	if n.isBanned(peerID) {
+		_ = secure.Close()
+		return nil, nil, errPeerBanned
+	}
+	dialNonce := localNonce
+	if inbound {
+		dialNonce = hello.Nonce
+	}
+	_, relayed := rawConn.(*circuitConn)
+	var localAddr netx.Addr
+	if lc, ok := rawConn.(netx.LocalAddrConn); ok {
+		localAddr = lc.LocalAddr()
+	}
 	pctx, cancel := context.WithCancel(n.ctx)
 	p := &peer{
 		id:           peerID,
 		name:         remoteName,
 		addr:         netx.Addr(hello.Listen),
 		observedAddr: rawConn.RemoteAddr(),
+		localAddr:    localAddr,
 		conn:         secure,
+		out:          out,
 		writer:       enc,
-		sendCh:       make(chan proto.Envelope, 128), // TODO: make configurable
+		sendq:        newSendQueue(n.cfg.SendQueues),
+		traffic:      newPeerTraffic(n.cfg.Bandwidth),
 		ctx:          pctx,
 		cancel:       cancel,
 		userPub:      remoteUserPub,
 		userID:       remoteUserID,
+		inbound:      inbound,
+		relayed:      relayed,
+		dialNonce:    dialNonce,
 	}
 
-	if !n.addPeer(p) {
+	kept, loser := n.addPeer(p)
+	if !kept && loser == nil {
+		cancel()
 		_ = secure.Close()
 		return nil, nil, nil
 	}
+	if loser != nil {
+		n.retirePeer(loser)
+	}
 
-	go p.writeLoop(n)
+	n.spawn("write", func() { p.writeLoop(n) })
 	return p, secure, nil
 }
 
@@ -118,11 +166,13 @@ func (n *Node) runPeerReadLoop(p *peer) {
 		default:
 		}
 		var env proto.Envelope
+		start := dec.InputOffset()
 		if err := dec.Decode(&env); err != nil {
 			n.Logf("read from %s failed: %v", p.id, err)
 			return
 		}
-		n.handleEnvelope(p, env)
+		n.dispatch(p, env)
+		throttle(p.ctx, n.account(p, true, env, int(dec.InputOffset()-start)))
 	}
 }
 
@@ -157,11 +207,12 @@ func (n *Node) readEnvelopeWithTimeout(rawConn netx.Conn, dec *json.Decoder, tim
 	}
 }
 
-func (n *Node) sendHello(enc *json.Encoder) error {
+func (n *Node) sendHello(enc *json.Encoder, nonce string) error {
 	h := proto.Hello{
 		Name:     n.cfg.Name,
-		Listen:   string(n.addr),
+		Listen:   string(n.advertisedAddr()),
 		Protocol: n.cfg.Protocol,
+		Nonce:    nonce,
 	}
 	env := proto.Envelope{
 		Type:    proto.MsgHello,
@@ -0,0 +1,208 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2p-park/internal/netx"
+	"p2p-park/internal/proto"
+)
+
+const (
+	// DefaultStopTimeout bounds Stop for callers that have no deadline of
+	// their own, such as test cleanup.
+	DefaultStopTimeout = 5 * time.Second
+
+	shutdownPollGap = 10 * time.Millisecond
+	goodbyeReason   = "shutting down"
+)
+
+// ShutdownError reports what Stop could not finish before its deadline.
+type ShutdownError struct {
+	Unflushed  map[string]int // peer ID -> envelopes never sent
+	Goroutines []string       // still running, e.g. "conn x2"
+}
+
+func (e *ShutdownError) Error() string {
+	var parts []string
+	if len(e.Unflushed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d peer queue(s) not flushed", len(e.Unflushed)))
+	}
+	if len(e.Goroutines) > 0 {
+		parts = append(parts, "still running: "+strings.Join(e.Goroutines, ", "))
+	}
+	return "p2p: stop incomplete: " + strings.Join(parts, "; ")
+}
+
+// taskGroup counts the node's goroutines by name so Stop can
+// wait for them and say which ones did not exit.
+type taskGroup struct {
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func newTaskGroup() *taskGroup {
+	return &taskGroup{running: make(map[string]int)}
+}
+
+func (g *taskGroup) add(name string, delta int) {
+	g.mu.Lock()
+	g.running[name] += delta
+	if g.running[name] <= 0 {
+		delete(g.running, name)
+	}
+	g.mu.Unlock()
+}
+
+// snapshot returns the running goroutines as sorted "name" or "name xN".
+func (g *taskGroup) snapshot() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]string, 0, len(g.running))
+	for name, k := range g.running {
+		if k > 1 {
+			name = fmt.Sprintf("%s x%d", name, k)
+		}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// spawn runs fn on a goroutine that Stop waits for.
+func (n *Node) spawn(name string, fn func()) {
+	n.tasks.add(name, 1)
+	go func() {
+		defer n.tasks.add(name, -1)
+		fn()
+	}()
+}
+
+// trackConn registers a raw connection for Stop to close. It reports false,
+// and the caller must close conn, once the node is stopping.
+func (n *Node) trackConn(conn netx.Conn) bool {
+	n.connsMu.Lock()
+	defer n.connsMu.Unlock()
+	if n.ctx.Err() != nil {
+		return false
+	}
+	n.conns[conn] = struct{}{}
+	return true
+}
+
+func (n *Node) untrackConn(conn netx.Conn) {
+	n.connsMu.Lock()
+	delete(n.conns, conn)
+	n.connsMu.Unlock()
+}
+
+// Stop shuts the node down. Every peer gets what is already in its send
+// queue and then a Goodbye, for as long as ctx allows; after that the node
+// closes its connections and waits, again until ctx ends, for its accept,
+// read, write, discovery and DHT goroutines. Whatever is left is reported
+// as a *ShutdownError. Only the first call does the work; later calls
+// return its result.
+func (n *Node) Stop(ctx context.Context) error {
+	n.stopOnce.Do(func() { n.stopErr = n.shutdown(ctx) })
+	return n.stopErr
+}
+
+func (n *Node) shutdown(ctx context.Context) error {
+	peers := n.snapshotPeers()
+	n.flushPeers(ctx, peers)
+	for _, p := range peers {
+		n.sendAsync(p, proto.Envelope{
+			Type:    proto.MsgGoodbye,
+			FromID:  n.id.ID,
+			Payload: proto.MustMarshal(proto.Goodbye{Reason: goodbyeReason}),
+		})
+	}
+	unflushed := n.flushPeers(ctx, peers)
+
+	n.cancel()
+	_ = n.cfg.Network.Close()
+	for _, p := range n.snapshotPeers() {
+		n.dropPeer(p)
+	}
+	n.connsMu.Lock()
+	for conn := range n.conns {
+		_ = conn.Close()
+	}
+	n.connsMu.Unlock()
+
+	if n.portMap != nil {
+		select {
+		case <-n.portMap:
+		case <-ctx.Done():
+		}
+	}
+
+	running := n.tasks.snapshot()
+	for len(running) > 0 && ctx.Err() == nil {
+		time.Sleep(shutdownPollGap)
+		running = n.tasks.snapshot()
+	}
+	if err := n.dht.Close(); err != nil {
+		n.Logf("close dht records: %v", err)
+	}
+	n.events.close()
+
+	if len(unflushed) == 0 && len(running) == 0 {
+		return nil
+	}
+	err := &ShutdownError{Unflushed: unflushed, Goroutines: running}
+	n.Logf("%v", err)
+	return err
+}
+
+func (n *Node) snapshotPeers() []*peer {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// flushPeers waits until every peer's send queue is empty, the peer is
+// gone, or ctx ends. It returns the envelopes still pending per peer.
+func (n *Node) flushPeers(ctx context.Context, peers []*peer) map[string]int {
+	for {
+		left := make(map[string]int)
+		for _, p := range peers {
+			if p.ctx.Err() != nil {
+				continue
+			}
+			if k := p.sendq.pending(); k > 0 {
+				left[p.id] = k
+			}
+		}
+		if len(left) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return left
+		case <-time.After(shutdownPollGap):
+		}
+	}
+}
+
+func (n *Node) handleGoodbye(p *peer, env proto.Envelope) {
+	var g proto.Goodbye
+	_ = json.Unmarshal(env.Payload, &g)
+	if g.Reason == "" {
+		g.Reason = "goodbye"
+	}
+	n.Logf("peer %s said goodbye: %s", p.id, g.Reason)
+	n.mu.Lock()
+	p.goodbye = g.Reason
+	n.mu.Unlock()
+	n.dropPeer(p)
+}
@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"p2p-park/internal/proto"
+)
+
+func TestStop_FlushesQueuesThenSaysGoodbye(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	s := b.Subscribe(WithEventTypes(EventGossipReceived, EventPeerDisconnected))
+	connect(t, a, b)
+	waitPeers(t, a, 1, 3*time.Second)
+
+	const k = 50
+	for i := range k {
+		a.Broadcast(proto.Gossip{ID: NewMsgID(), Channel: fmt.Sprint(i), Body: json.RawMessage(`{}`)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	if err := a.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	for i := range k {
+		if ev := waitEvent(t, s, EventGossipReceived); ev.Detail != fmt.Sprint(i) {
+			t.Fatalf("gossip %d arrived as %q", i, ev.Detail)
+		}
+	}
+	if ev := waitEvent(t, s, EventPeerDisconnected); ev.Detail != goodbyeReason {
+		t.Fatalf("disconnect reason = %q; want %q", ev.Detail, goodbyeReason)
+	}
+	if err := a.Stop(ctx); err != nil {
+		t.Fatalf("second Stop: %v", err)
+	}
+}
+
+func TestStop_ReportsGoroutinesLeftOver(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	const stuck proto.MessageType = "test_stuck"
+	release, entered := make(chan struct{}), make(chan struct{}, 1)
+	defer close(release)
+	if err := a.Handle(stuck, func(Inbound) {
+		entered <- struct{}{}
+		<-release
+	}, WithQueue(1)); err != nil {
+		t.Fatal(err)
+	}
+	connect(t, b, a)
+	waitPeers(t, b, 1, 3*time.Second)
+	if err := b.SendToPeer(a.ID(), proto.Envelope{Type: stuck, FromID: b.ID()}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-entered:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("handler did not run")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var se *ShutdownError
+	if err := a.Stop(ctx); !errors.As(err, &se) {
+		t.Fatalf("Stop = %v; want a *ShutdownError", err)
+	}
+	if len(se.Goroutines) != 1 || se.Goroutines[0] != "handler "+string(stuck) {
+		t.Fatalf("left over = %v; want only the stuck handler", se.Goroutines)
+	}
+}
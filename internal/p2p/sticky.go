@@ -0,0 +1,367 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2p-park/internal/netx"
+)
+
+const (
+	stickyFile       = "sticky.json"
+	stickyTick       = 1 * time.Second
+	stickyBackoffMin = 1 * time.Second
+	stickyBackoffMax = 2 * time.Minute
+
+	// A userID target with no address, or whose last address failed this
+	// many times in a row, is looked up with FindUser instead.
+	stickyFindAfter   = 3
+	stickyFindTimeout = 30 * time.Second
+)
+
+// StickyStatus is a read-only view of one sticky peer.
+type StickyStatus struct {
+	Target      string    // addr (host:port) or userID as entered
+	Addr        string    // address we dial; last known for userID targets
+	PeerID      string    // network ID once connected
+	Connected   bool      // currently connected
+	Failures    int       // consecutive failed reconnect attempts
+	NextAttempt time.Time // zero while connected
+	LastErr     string
+}
+
+type stickyEntry struct {
+	Target string `json:"target"`
+	Addr   string `json:"addr,omitempty"`
+	PeerID string `json:"peer_id,omitempty"`
+
+	failures    int
+	nextAttempt time.Time
+	connected   bool
+	finding     bool // a FindUser for it is running
+	lastErr     string
+}
+
+type stickyPeers struct {
+	path string // empty => not persisted
+
+	mu      sync.Mutex
+	entries map[string]*stickyEntry
+}
+
+func newStickyPeers(dataDir string) *stickyPeers {
+	s := &stickyPeers{entries: make(map[string]*stickyEntry)}
+	if dataDir != "" {
+		s.path = filepath.Join(dataDir, stickyFile)
+		_ = s.load()
+	}
+	return s
+}
+
+func (s *stickyPeers) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil
+	}
+	var recs []stickyEntry
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return fmt.Errorf("sticky decode: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range recs {
+		r := recs[i]
+		if r.Target == "" {
+			continue
+		}
+		s.entries[r.Target] = &r
+	}
+	return nil
+}
+
+func (s *stickyPeers) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	recs := make([]stickyEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		recs = append(recs, stickyEntry{Target: e.Target, Addr: e.Addr, PeerID: e.PeerID})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Target < recs[j].Target })
+
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("sticky encode: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// isUserIDTarget reports whether target is a userID (hex ed25519 pubkey)
+// rather than a host:port address.
+func isUserIDTarget(target string) bool {
+	b, err := hex.DecodeString(target)
+	return err == nil && len(b) == 32
+}
+
+// AddSticky marks target (host:port or userID) as a peer to stay connected to.
+// A userID is redialed at its last known address, and found with FindUser
+// when there is none or that keeps failing. The list is persisted in the
+// node's data directory.
+func (n *Node) AddSticky(target string) error {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return errors.New("empty sticky target")
+	}
+	e := &stickyEntry{Target: target}
+	if !isUserIDTarget(target) {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("sticky target must be host:port or userID: %w", err)
+		}
+		e.Addr = target
+	} else if pid, ok := n.NetworkPeerIDForUserID(target); ok {
+		e.PeerID = pid
+		e.Addr = n.dialableAddr(pid)
+	}
+
+	n.sticky.mu.Lock()
+	if _, ok := n.sticky.entries[target]; ok {
+		n.sticky.mu.Unlock()
+		return nil
+	}
+	n.sticky.entries[target] = e
+	n.sticky.mu.Unlock()
+
+	return n.sticky.save()
+}
+
+// RemoveSticky drops target from the sticky list. It does not disconnect.
+func (n *Node) RemoveSticky(target string) bool {
+	n.sticky.mu.Lock()
+	_, ok := n.sticky.entries[target]
+	delete(n.sticky.entries, target)
+	n.sticky.mu.Unlock()
+	if ok {
+		_ = n.sticky.save()
+	}
+	return ok
+}
+
+// StickyPeers returns the status of every sticky peer, sorted by target.
+func (n *Node) StickyPeers() []StickyStatus {
+	n.sticky.mu.Lock()
+	defer n.sticky.mu.Unlock()
+
+	out := make([]StickyStatus, 0, len(n.sticky.entries))
+	for _, e := range n.sticky.entries {
+		st := StickyStatus{
+			Target:    e.Target,
+			Addr:      e.Addr,
+			PeerID:    e.PeerID,
+			Connected: e.connected,
+			Failures:  e.failures,
+			LastErr:   e.lastErr,
+		}
+		if !e.connected {
+			st.NextAttempt = e.nextAttempt
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+// stickyLoop keeps sticky peers connected, redialing with exponential backoff.
+func (n *Node) stickyLoop() {
+	t := time.NewTicker(stickyTick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+			n.stickyTick(time.Now())
+		}
+	}
+}
+
+func (n *Node) stickyTick(now time.Time) {
+	type dial struct {
+		target string
+		addr   string // empty: find the user instead
+	}
+	var dials []dial
+	changed := false
+
+	n.sticky.mu.Lock()
+	for _, e := range n.sticky.entries {
+		pid, connected := n.stickyPeerID(e)
+		if connected {
+			if !e.connected || e.PeerID != pid {
+				changed = true
+			}
+			e.connected = true
+			e.PeerID = pid
+			e.failures = 0
+			e.lastErr = ""
+			e.nextAttempt = time.Time{}
+			if isUserIDTarget(e.Target) {
+				if addr := n.dialableAddr(pid); addr != "" && addr != e.Addr {
+					e.Addr = addr
+					changed = true
+				}
+			}
+			continue
+		}
+
+		if e.connected {
+			// Just dropped: retry right away, then back off.
+			e.connected = false
+			e.nextAttempt = now
+		}
+		if e.finding || now.Before(e.nextAttempt) {
+			continue
+		}
+		addr := e.Addr
+		if isUserIDTarget(e.Target) && e.failures >= stickyFindAfter {
+			addr = ""
+		}
+		// Assume failure until the peer shows up; a success resets this.
+		e.failures++
+		e.nextAttempt = now.Add(stickyBackoff(e.failures))
+		e.finding = addr == ""
+		dials = append(dials, dial{target: e.Target, addr: addr})
+	}
+	n.sticky.mu.Unlock()
+
+	if changed {
+		_ = n.sticky.save()
+	}
+
+	for _, d := range dials {
+		if d.addr == "" {
+			n.spawn("sticky find", func() { n.stickyFind(d.target) })
+			continue
+		}
+		n.spawn("sticky dial", func() {
+			n.Logf("sticky: reconnecting to %s at %s", d.target, d.addr)
+			if err := n.ConnectTo(netx.Addr(d.addr)); err != nil {
+				n.stickyFailed(d.target, err)
+			}
+		})
+	}
+}
+
+// stickyFind reconnects to a userID target wherever it is now: through its
+// DHT record, or a relay.
+func (n *Node) stickyFind(userID string) {
+	n.Logf("sticky: looking up %s", userID)
+	ctx, cancel := context.WithTimeout(n.ctx, stickyFindTimeout)
+	defer cancel()
+	_, err := n.FindUser(ctx, userID)
+
+	n.sticky.mu.Lock()
+	if e := n.sticky.entries[userID]; e != nil {
+		e.finding = false
+	}
+	n.sticky.mu.Unlock()
+	if err != nil {
+		n.stickyFailed(userID, err)
+	}
+}
+
+func (n *Node) stickyFailed(target string, err error) {
+	n.sticky.mu.Lock()
+	if e := n.sticky.entries[target]; e != nil {
+		e.lastErr = err.Error()
+	}
+	n.sticky.mu.Unlock()
+}
+
+// stickyPeerID returns the connected network ID matching e, if any.
+func (n *Node) stickyPeerID(e *stickyEntry) (string, bool) {
+	if isUserIDTarget(e.Target) {
+		return n.NetworkPeerIDForUserID(e.Target)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if e.PeerID != "" {
+		if _, ok := n.peers[e.PeerID]; ok {
+			return e.PeerID, true
+		}
+	}
+	for id, p := range n.peers {
+		if string(p.addr) == e.Addr || (!p.inbound && string(p.observedAddr) == e.Addr) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// dialableAddr returns an address we could dial peerID on later.
+// Hello.Listen is often a wildcard ("[::]:port"); in that case the host
+// is taken from the observed remote address.
+func (n *Node) dialableAddr(peerID string) string {
+	n.mu.RLock()
+	p := n.peers[peerID]
+	if p == nil || p.relayed {
+		n.mu.RUnlock()
+		return ""
+	}
+	addr, observed, inbound := p.addr, p.observedAddr, p.inbound
+	n.mu.RUnlock()
+
+	if !inbound && observed != "" {
+		return string(observed)
+	}
+
+	host, port, err := net.SplitHostPort(string(addr))
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return string(addr)
+	}
+	obsHost, _, err := net.SplitHostPort(string(observed))
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(obsHost, port)
+}
+
+// stickyBackoff returns the delay after the given number of consecutive
+// failures: exponential from stickyBackoffMin, capped, with ±20% jitter.
+func stickyBackoff(failures int) time.Duration {
+	d := stickyBackoffMin
+	for i := 1; i < failures && d < stickyBackoffMax; i++ {
+		d *= 2
+	}
+	if d > stickyBackoffMax {
+		d = stickyBackoffMax
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
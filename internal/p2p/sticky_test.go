@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestSticky_ReconnectsAfterDrop(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestNode(t, "a", WithDataDir(dir))
+	b := newTestNode(t, "b")
+
+	if err := a.AddSticky(string(b.ListenAddr())); err != nil {
+		t.Fatalf("AddSticky: %v", err)
+	}
+	waitPeers(t, a, 1, 3*time.Second)
+
+	waitSticky := func(connected bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			st := a.StickyPeers()
+			if len(st) == 1 && st[0].Connected == connected && (!connected || st[0].PeerID == b.ID()) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("sticky status never became connected=%v: %+v", connected, a.StickyPeers())
+	}
+	waitSticky(true)
+
+	a.removePeer(b.ID())
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.hasPeer(b.ID()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a to reconnect to b: %+v", a.StickyPeers())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitSticky(true)
+
+	// The list survives a restart.
+	reloaded := newStickyPeers(dir)
+	if _, ok := reloaded.entries[string(b.ListenAddr())]; !ok {
+		t.Fatalf("sticky entry not persisted")
+	}
+}
+
+func TestStickyBackoff_GrowsAndCaps(t *testing.T) {
+	prev := time.Duration(0)
+	for f := 1; f <= 6; f++ {
+		d := stickyBackoff(f)
+		base := stickyBackoffMin << (f - 1)
+		if d < base*8/10 || d > base*12/10 {
+			t.Fatalf("failures=%d: backoff %v outside ±20%% of %v", f, d, base)
+		}
+		if d <= prev*8/10 {
+			t.Fatalf("failures=%d: backoff did not grow (%v after %v)", f, d, prev)
+		}
+		prev = d
+	}
+	if d := stickyBackoff(100); d > stickyBackoffMax*12/10 {
+		t.Fatalf("backoff not capped: %v", d)
+	}
+}
+
+func TestAddSticky_RejectsBadTarget(t *testing.T) {
+	a := newTestNode(t, "a")
+	if err := a.AddSticky("not-an-address"); err == nil {
+		t.Fatalf("expected error for bad target")
+	}
+}
+
+func TestSticky_UserIDNeverSeenIsFound(t *testing.T) {
+	a := newTestNode(t, "A")
+	b := newTestNode(t, "B")
+	c := newTestNode(t, "C")
+	connect(t, a, b)
+	connect(t, c, b)
+	waitPeers(t, b, 2, 3*time.Second)
+	waitCond(t, "B to hold C's record", func() bool {
+		_, ok := b.PeerRecordFor(c.ID())
+		return ok
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.PublishSelfRecord(ctx); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	// A has never been connected to C, so knows no address for it.
+	if err := a.AddSticky(hex.EncodeToString(c.Identity().SignPub)); err != nil {
+		t.Fatalf("AddSticky: %v", err)
+	}
+	waitCond(t, "A to find and connect to C", func() bool {
+		st := a.StickyPeers()
+		return len(st) == 1 && st[0].Connected && st[0].PeerID == c.ID()
+	})
+}
@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"context"
 	"io"
 	"log"
 	"testing"
@@ -36,6 +37,48 @@ func WithBootstraps(addrs ...netx.Addr) nodeTestOpt {
 	return func(cfg *NodeConfig) { cfg.Bootstraps = addrs }
 }
 
+// WithKeepalive overrides the keepalive interval and dead-peer threshold.
+func WithKeepalive(interval time.Duration, maxMissed int) nodeTestOpt {
+	return func(cfg *NodeConfig) {
+		cfg.Keepalive = KeepaliveConfig{Interval: interval, MaxMissed: maxMissed}
+	}
+}
+
+// WithDataDir sets the directory for persistent node state.
+func WithDataDir(dir string) nodeTestOpt {
+	return func(cfg *NodeConfig) { cfg.DataDir = dir }
+}
+
+// WithNetwork swaps the TCP transport, e.g. for an in-process netx.MemHub network.
+func WithNetwork(nw netx.Network) nodeTestOpt {
+	return func(cfg *NodeConfig) { cfg.Network = nw }
+}
+
+// WithRelay sets the circuit limits a seed enforces.
+func WithRelay(cfg RelayConfig) nodeTestOpt {
+	return func(c *NodeConfig) { c.Relay = cfg }
+}
+
+// WithPortMap enables port mapping against the given gateway.
+func WithPortMap(gateway string) nodeTestOpt {
+	return func(c *NodeConfig) { c.PortMap = PortMapConfig{Enabled: true, Gateway: gateway} }
+}
+
+// WithPEX overrides the peer exchange settings.
+func WithPEX(cfg PEXConfig) nodeTestOpt {
+	return func(c *NodeConfig) { c.PEX = cfg }
+}
+
+// WithBandwidth sets the rate limits.
+func WithBandwidth(cfg BandwidthConfig) nodeTestOpt {
+	return func(c *NodeConfig) { c.Bandwidth = cfg }
+}
+
+// WithIDDifficulty sets the network's ID puzzle.
+func WithIDDifficulty(bits int) nodeTestOpt {
+	return func(c *NodeConfig) { c.IDDifficulty = bits }
+}
+
 // newTestNode spins up a node bound to an ephemeral localhost port and auto-stops it.
 func newTestNode(t *testing.T, name string, opts ...nodeTestOpt) *Node {
 	t.Helper()
@@ -63,7 +106,11 @@ func newTestNode(t *testing.T, name string, opts ...nodeTestOpt) *Node {
 		t.Fatalf("Start(%s) error: %v", name, err)
 	}
 
-	t.Cleanup(func() { _ = n.Stop() })
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+		defer cancel()
+		_ = n.Stop(ctx)
+	})
 	return n
 }
 
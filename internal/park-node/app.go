@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"p2p-park/internal/app/dm"
 	"p2p-park/internal/app/grants"
 	"p2p-park/internal/app/points"
 	"p2p-park/internal/app/quiz"
@@ -19,6 +20,7 @@ import (
 	"p2p-park/internal/p2p"
 	"p2p-park/internal/paths"
 	"p2p-park/internal/proto"
+	"p2p-park/internal/storage/dmbolt"
 	"p2p-park/internal/storage/grantsbolt"
 	"p2p-park/internal/telemetry"
 )
@@ -33,6 +35,10 @@ type App struct {
 	// Discovery lifecycle
 	stopLAN chan struct{}
 
+	// Closed by /quit; Run returns
+	quit     chan struct{}
+	quitOnce sync.Once
+
 	// Points engine
 	Points *points.Engine
 
@@ -43,6 +49,10 @@ type App struct {
 	// Quiz engine
 	Quiz *quiz.Engine
 
+	// End-to-end encrypted direct messages
+	DMs     *dm.Engine
+	DMStore dm.Store
+
 	// Encrypted channels
 	encMu       sync.RWMutex
 	encChannels map[string]channel.ChannelKey
@@ -53,6 +63,14 @@ type App struct {
 }
 
 func New(cfg Config, logger *log.Logger) (*App, error) {
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = paths.DefaultDataDir()
+	}
+	if dir, err := paths.EnsureDir(dataDir); err == nil {
+		dataDir = dir
+	}
+
 	n, err := p2p.NewNode(p2p.NodeConfig{
 		Name:       cfg.Name,
 		Network:    netx.NewTCPNetwork(),
@@ -62,6 +80,23 @@ func New(cfg Config, logger *log.Logger) (*App, error) {
 		Logger:     logger,
 		Debug:      cfg.Debug,
 		IsSeed:     cfg.IsSeed,
+		DataDir:    dataDir,
+		PortMap:    p2p.PortMapConfig{Enabled: cfg.PortMap, Gateway: cfg.Gateway},
+		Bandwidth: p2p.BandwidthConfig{
+			GlobalIn:  cfg.LimitIn,
+			GlobalOut: cfg.LimitOut,
+			PeerIn:    cfg.PeerLimit,
+			PeerOut:   cfg.PeerLimit,
+		},
+		IDDifficulty: cfg.IDDifficulty,
+		KeygenProgress: func(p p2p.KeygenProgress) {
+			if p.Done {
+				logger.Printf("node key found after %d tries in %s", p.Tried, p.Elapsed.Round(time.Millisecond))
+				return
+			}
+			logger.Printf("searching for a node key with %d leading zero bits: %d tries, ~%d expected (%s)",
+				cfg.IDDifficulty, p.Tried, p.Expected, p.Elapsed.Round(time.Second))
+		},
 	})
 	if err != nil {
 		return nil, err
@@ -73,13 +108,6 @@ func New(cfg Config, logger *log.Logger) (*App, error) {
 	ld := grants.NewLedger()
 
 	// Persistent grant store (BoltDB)
-	dataDir := cfg.DataDir
-	if dataDir == "" {
-		dataDir = paths.DefaultDataDir()
-	}
-	if dir, err := paths.EnsureDir(dataDir); err == nil {
-		dataDir = dir
-	}
 	dbPath := filepath.Join(dataDir, "grants.bolt")
 	gs, err := grantsbolt.Open(dbPath)
 	if err != nil {
@@ -92,19 +120,33 @@ func New(cfg Config, logger *log.Logger) (*App, error) {
 	})
 	ld.NoteName(hex.EncodeToString(id.SignPub), cfg.Name)
 
-	return &App{
+	// Persistent direct message store (BoltDB)
+	ds, err := dmbolt.Open(filepath.Join(dataDir, "dms.bolt"))
+	if err != nil {
+		_ = gs.Close()
+		return nil, err
+	}
+
+	a := &App{
 		cfg:         cfg,
 		logger:      logger,
 		ui:          NewStdPrinter(os.Stdout),
 		Node:        n,
 		stopLAN:     make(chan struct{}),
+		quit:        make(chan struct{}),
 		Points:      pe,
 		Quiz:        qe,
 		Ledger:      ld,
 		GrantStore:  gs,
+		DMs:         dm.NewEngine(cfg.Name, id.SignPriv, id.SignPub),
+		DMStore:     ds,
 		encChannels: make(map[string]channel.ChannelKey),
 		otherPoints: make(map[string]proto.PointsSnapshot),
-	}, nil
+	}
+	n.HandleRequest(grantSyncProtocol, a.serveGrantSync)
+	n.HandleRequest(quizResultProtocol, a.serveQuizResult)
+	a.registerHandlers()
+	return a, nil
 }
 
 func (a *App) Start() error {
@@ -137,14 +179,31 @@ func (a *App) Run(ctx context.Context) error {
 	go a.readStdin(ctx)
 
 	// Event emitter
+	events := a.Node.Subscribe(p2p.WithEventTypes(
+		p2p.EventPeerConnected,
+		p2p.EventPeerDisconnected,
+		p2p.EventPeerBanned,
+		p2p.EventReachabilityChanged,
+		p2p.EventPortMapChanged,
+	))
 	go func() {
-		for ev := range a.Node.Events() {
+		for ev := range events.C {
 			switch ev.Type {
 			case p2p.EventPeerConnected:
 				a.ui.Printf("[NET] peer connected: %s (%s)\n", ev.PeerName, ev.PeerAddr)
 				go a.initiateGrantSync(ev.PeerID)
 			case p2p.EventPeerDisconnected:
 				a.ui.Printf("[NET] peer disconnected: %s\n", ev.PeerID)
+			case p2p.EventPeerBanned:
+				a.ui.Printf("[NET] peer banned: %s (%s)\n", ev.PeerID, ev.Detail)
+			case p2p.EventReachabilityChanged:
+				a.ui.Printf("[NET] reachability: %s\n", ev.Detail)
+			case p2p.EventPortMapChanged:
+				if ev.PeerAddr == "" {
+					a.ui.Printf("[NET] port mapping lost\n")
+				} else {
+					a.ui.Printf("[NET] port mapped: %s\n", ev.PeerAddr)
+				}
 			}
 		}
 	}()
@@ -154,26 +213,39 @@ func (a *App) Run(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-a.quit:
+			return nil
 		case env, ok := <-a.Node.Incoming():
 			if !ok {
 				return nil
 			}
-			a.handleEnvelope(env)
+			a.handleGossip(env)
 		}
 	}
 }
 
-func (a *App) StopAll() {
+// Quit makes Run return so the caller can shut down.
+func (a *App) Quit() {
+	a.quitOnce.Do(func() { close(a.quit) })
+}
+
+// StopAll says goodbye to peers and shuts everything down, giving the node
+// until ctx ends to flush and wind down. The stores are closed either way.
+func (a *App) StopAll(ctx context.Context) error {
 	select {
 	case <-a.stopLAN:
 		// already closed by someone
 	default:
 		close(a.stopLAN)
 	}
-	a.Node.Stop()
+	err := a.Node.Stop(ctx)
 	if a.GrantStore != nil {
 		_ = a.GrantStore.Close()
 	}
+	if a.DMStore != nil {
+		_ = a.DMStore.Close()
+	}
+	return err
 }
 
 func (a *App) logf(format string, args ...any) {
@@ -194,6 +266,3 @@ func (a *App) userIDHex() string {
 	id := a.Node.Identity()
 	return hex.EncodeToString(id.SignPub)
 }
-
-// For “settle” waits after quit
-func sleepBrief() { time.Sleep(100 * time.Millisecond) }
@@ -2,9 +2,12 @@ package parknode
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +17,9 @@ import (
 	"p2p-park/internal/proto"
 )
 
+// connectTimeout bounds a /connect lookup, including the relay fallback.
+const connectTimeout = 30 * time.Second
+
 func (a *App) readStdin(_ any) {
 	sc := bufio.NewScanner(os.Stdin)
 	for sc.Scan() {
@@ -29,9 +35,7 @@ func (a *App) handleCommand(line string) {
 	switch {
 	case strings.HasPrefix(line, "/quit"), strings.HasPrefix(line, "/exit"):
 		a.ui.Println("quitting...")
-		a.StopAll()
-		sleepBrief()
-		os.Exit(0)
+		a.Quit()
 
 	case line == "/me":
 		id := a.Node.Identity()
@@ -44,6 +48,14 @@ func (a *App) handleCommand(line string) {
 		a.ui.Printf("  UserID:     %s\n", userID)
 		a.ui.Printf("  NetworkID:  %s\n", networkID)
 		a.ui.Printf("  Listen on:  %s\n", a.Node.ListenAddr())
+		if mapped := a.Node.MappedAddr(); mapped != "" {
+			a.ui.Printf("  Port map:   %s\n", mapped)
+		}
+		if reach, ext := a.Node.Reachability(); ext != "" {
+			a.ui.Printf("  Reachable:  %s (%s)\n", reach, ext)
+		} else {
+			a.ui.Printf("  Reachable:  %s\n", reach)
+		}
 		a.ui.Printf("  Peers:      %d\n", a.Node.PeerCount())
 		a.ui.Println()
 
@@ -51,13 +63,14 @@ func (a *App) handleCommand(line string) {
 		peers := a.Node.SnapshotPeers()
 		if len(peers) == 0 {
 			a.ui.Println("no peers connected")
+			a.printSticky()
 			return
 		}
 
 		a.ui.Println()
 		a.ui.Println("Connected peers:")
-		a.ui.Printf("%-16s  %-10s  %-10s  %s\n", "NAME", "USERID", "NETID", "ADDR")
-		a.ui.Printf("%-16s  %-10s  %-10s  %s\n", "----", "------", "-----", "----")
+		a.ui.Printf("%-16s  %-10s  %-10s  %-8s  %s\n", "NAME", "USERID", "NETID", "RTT", "ADDR")
+		a.ui.Printf("%-16s  %-10s  %-10s  %-8s  %s\n", "----", "------", "-----", "---", "----")
 
 		for _, p := range peers {
 			name := p.Name
@@ -72,9 +85,77 @@ func (a *App) handleCommand(line string) {
 			}
 			shortNet := shortID(p.NetworkID)
 
-			a.ui.Printf("%-16s  %-10s  %-10s  %s\n", coloredName, shortUser, shortNet, p.Addr)
+			rtt := "-"
+			if p.RTT > 0 {
+				rtt = p.RTT.Round(time.Millisecond).String()
+			}
+			addr := p.Addr
+			if p.Relayed {
+				addr += " (relayed)"
+			}
+
+			a.ui.Printf("%-16s  %-10s  %-10s  %-8s  %s\n", coloredName, shortUser, shortNet, rtt, addr)
 		}
 		a.ui.Println()
+		a.printSticky()
+
+	case line == "/dht", strings.HasPrefix(line, "/dht "):
+		a.handleDHTCommand(strings.TrimPrefix(line, "/dht"))
+
+	case line == "/stats":
+		a.printStats()
+
+	case strings.HasPrefix(line, "/stick "):
+		target := strings.TrimSpace(strings.TrimPrefix(line, "/stick"))
+		if target == "" {
+			a.ui.Println("usage: /stick <addr|userID>")
+			return
+		}
+		if err := a.Node.AddSticky(target); err != nil {
+			a.ui.Printf("stick: %v\n", err)
+			return
+		}
+		a.ui.Printf("[NET] sticky peer added: %s\n", target)
+
+	case strings.HasPrefix(line, "/unstick "):
+		target := strings.TrimSpace(strings.TrimPrefix(line, "/unstick"))
+		if !a.Node.RemoveSticky(target) {
+			a.ui.Printf("not a sticky peer: %s\n", target)
+			return
+		}
+		a.ui.Printf("[NET] sticky peer removed: %s\n", target)
+
+	case strings.HasPrefix(line, "/connect "):
+		who := strings.TrimSpace(strings.TrimPrefix(line, "/connect"))
+		userID, err := a.resolveUser(who)
+		if err != nil {
+			a.ui.Printf("connect: %v\n", err)
+			return
+		}
+		a.ui.Printf("[NET] looking up %s...\n", shortID(userID))
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+			defer cancel()
+			peerID, err := a.Node.FindUser(ctx, userID)
+			if err != nil {
+				a.ui.Printf("connect: %v\n", err)
+				return
+			}
+			a.ui.Printf("[NET] connected to %s (peer %s)\n", shortID(userID), shortID(peerID))
+		}()
+
+	case strings.HasPrefix(line, "/relay "):
+		who := strings.TrimSpace(strings.TrimPrefix(line, "/relay"))
+		userID, err := a.resolveUser(who)
+		if err != nil {
+			a.ui.Printf("relay: %v\n", err)
+			return
+		}
+		if err := a.Node.ConnectViaRelay(userID); err != nil {
+			a.ui.Printf("relay: %v\n", err)
+			return
+		}
+		a.ui.Printf("[NET] opening relayed connection to %s\n", shortID(userID))
 
 	case strings.HasPrefix(line, "/say "):
 		msg := strings.TrimSpace(strings.TrimPrefix(line, "/say"))
@@ -90,6 +171,18 @@ func (a *App) handleCommand(line string) {
 			Body:    body,
 		})
 
+	case strings.HasPrefix(line, "/msg "):
+		fields := strings.Fields(strings.TrimPrefix(line, "/msg"))
+		if len(fields) < 2 {
+			a.ui.Println("usage: /msg <user> <text>")
+			return
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, "/msg")), fields[0]))
+		a.sendDirect(fields[0], text)
+
+	case line == "/dms", strings.HasPrefix(line, "/dms "):
+		a.printDirect(strings.TrimSpace(strings.TrimPrefix(line, "/dms")))
+
 	case strings.HasPrefix(line, "/add "):
 		arg := strings.TrimSpace(strings.TrimPrefix(line, "/add"))
 		if arg == "" {
@@ -314,3 +407,100 @@ func (a *App) sendEncrypted(chName, msg string) {
 		Body:    body,
 	})
 }
+
+func (a *App) printSticky() {
+	sticky := a.Node.StickyPeers()
+	if len(sticky) == 0 {
+		return
+	}
+	a.ui.Println("Sticky peers:")
+	a.ui.Printf("%-24s  %-10s  %s\n", "TARGET", "STATUS", "DETAIL")
+	a.ui.Printf("%-24s  %-10s  %s\n", "------", "------", "------")
+	for _, s := range sticky {
+		target := s.Target
+		if len(target) > 24 {
+			target = shortID(target)
+		}
+		if s.Connected {
+			a.ui.Printf("%-24s  %-10s  %s\n", target, "connected", shortID(s.PeerID))
+			continue
+		}
+		detail := "no known address"
+		if s.Addr != "" {
+			detail = fmt.Sprintf("retry in %s (%d failures)", time.Until(s.NextAttempt).Round(time.Second), s.Failures)
+			if s.LastErr != "" {
+				detail += ": " + s.LastErr
+			}
+		}
+		a.ui.Printf("%-24s  %-10s  %s\n", target, "down", detail)
+	}
+	a.ui.Println()
+}
+
+// printStats shows traffic totals, then per peer and per protocol with the
+// busiest first.
+func (a *App) printStats() {
+	st := a.Node.BandwidthStats()
+	row := "%-20s  %10s  %10s  %10s  %10s  %7s\n"
+	line := func(label string, t p2p.TrafficStats) {
+		a.ui.Printf(row, label,
+			formatBytes(float64(t.BytesIn)), formatBytes(t.RateIn)+"/s",
+			formatBytes(float64(t.BytesOut)), formatBytes(t.RateOut)+"/s",
+			strconv.FormatUint(t.MsgsIn+t.MsgsOut, 10))
+	}
+	header := func(label string) {
+		a.ui.Printf(row, label, "IN", "IN RATE", "OUT", "OUT RATE", "MSGS")
+		a.ui.Printf(row, strings.Repeat("-", len(label)), "--", "-------", "---", "--------", "----")
+	}
+	busiest := func(m map[string]p2p.TrafficStats) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			ti, tj := m[keys[i]], m[keys[j]]
+			return ti.BytesIn+ti.BytesOut > tj.BytesIn+tj.BytesOut
+		})
+		return keys
+	}
+
+	names := make(map[string]string)
+	for _, p := range a.Node.SnapshotPeers() {
+		names[p.NetworkID] = p.Name
+	}
+
+	a.ui.Println()
+	header("TRAFFIC")
+	line("total", st.Total)
+	a.ui.Println()
+	if len(st.Peers) > 0 {
+		header("PEER")
+		for _, id := range busiest(st.Peers) {
+			label := shortID(id)
+			if name := names[id]; name != "" {
+				label = name + " " + label
+			}
+			line(label, st.Peers[id])
+		}
+		a.ui.Println()
+	}
+	header("PROTOCOL")
+	for _, k := range busiest(st.Protocols) {
+		line(k, st.Protocols[k])
+	}
+	a.ui.Println()
+}
+
+// formatBytes renders a byte count or rate with a binary unit.
+func formatBytes(b float64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%.0f B", b)
+	}
+	exp := 0
+	for b >= unit*unit && exp < 3 {
+		b /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", b/unit, "KMGT"[exp])
+}
@@ -9,4 +9,11 @@ type Config struct {
 	IsSeed     bool
 	Bootstraps []netx.Addr
 	Debug      bool
+	PortMap    bool   // request a PCP / NAT-PMP port forward for the listen port
+	Gateway    string // router for PortMap; empty means the default gateway
+	LimitIn    int    // bytes/s across all peers; 0 is unlimited
+	LimitOut   int
+	PeerLimit  int // bytes/s each way for any one peer; 0 is unlimited
+
+	IDDifficulty int // network ID puzzle in leading zero bits; 0 is off
 }
@@ -0,0 +1,285 @@
+package parknode
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
+
+// dhtTimeout bounds one /dht network operation.
+const dhtTimeout = 15 * time.Second
+
+func (a *App) printDHTUsage() {
+	a.ui.Println("usage:")
+	a.ui.Println("  /dht put <value>              - store an immutable value; prints its key")
+	a.ui.Println("  /dht get <key>                - fetch a value by key")
+	a.ui.Println("  /dht putm <name> <value>      - store a value signed by you under <name>")
+	a.ui.Println("  /dht getm <userID> <name>     - fetch a user's value stored under <name>")
+	a.ui.Println("  /dht provide <key>            - announce that you provide <key>")
+	a.ui.Println("  /dht providers <key>          - list peers providing <key>")
+	a.ui.Println("  /dht buckets                  - show routing table occupancy")
+	a.ui.Println("  /dht lookup <nodeID> [paths]  - trace a FIND_NODE lookup hop by hop, optionally over disjoint paths")
+}
+
+// handleDHTCommand runs a /dht subcommand. Anything that goes to the
+// network runs in the background so the prompt stays responsive.
+func (a *App) handleDHTCommand(args string) {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+
+	switch sub {
+	case "put":
+		if rest == "" {
+			a.printDHTUsage()
+			return
+		}
+		a.inBackground("dht put", func(ctx context.Context) error {
+			key, err := a.Node.DHTPut(ctx, []byte(rest))
+			if err != nil {
+				return err
+			}
+			a.ui.Printf("[DHT] stored under %s\n", dht.KeyHex(key))
+			return nil
+		})
+
+	case "get":
+		key, err := dht.ParseKeyHex(rest)
+		if err != nil {
+			a.ui.Println("dht get: key must be 64 hex digits")
+			return
+		}
+		a.inBackground("dht get", func(ctx context.Context) error {
+			return a.printDHTValue(ctx, key)
+		})
+
+	case "putm":
+		name, value, ok := strings.Cut(rest, " ")
+		if !ok || name == "" {
+			a.printDHTUsage()
+			return
+		}
+		a.inBackground("dht putm", func(ctx context.Context) error {
+			key, seq, err := a.Node.DHTPutMutable(ctx, name, []byte(strings.TrimSpace(value)))
+			if err != nil {
+				return err
+			}
+			a.ui.Printf("[DHT] stored %q seq=%d under %s\n", name, seq, dht.KeyHex(key))
+			return nil
+		})
+
+	case "getm":
+		who, name, ok := strings.Cut(rest, " ")
+		if !ok || strings.TrimSpace(name) == "" {
+			a.printDHTUsage()
+			return
+		}
+		userID, err := a.resolveUser(who)
+		if err != nil {
+			a.ui.Printf("dht getm: %v\n", err)
+			return
+		}
+		pub, err := hex.DecodeString(userID)
+		if err != nil || len(pub) != 32 {
+			a.ui.Printf("dht getm: bad userID %q\n", userID)
+			return
+		}
+		key := dht.KeyFromMutable(pub, strings.TrimSpace(name))
+		a.inBackground("dht getm", func(ctx context.Context) error {
+			return a.printDHTValue(ctx, key)
+		})
+
+	case "provide":
+		key, err := dht.ParseKeyHex(rest)
+		if err != nil {
+			a.ui.Println("dht provide: key must be 64 hex digits")
+			return
+		}
+		a.inBackground("dht provide", func(ctx context.Context) error {
+			if err := a.Node.DHTProvide(ctx, key); err != nil {
+				return err
+			}
+			a.ui.Printf("[DHT] providing %s\n", shortID(dht.KeyHex(key)))
+			return nil
+		})
+
+	case "providers":
+		key, err := dht.ParseKeyHex(rest)
+		if err != nil {
+			a.ui.Println("dht providers: key must be 64 hex digits")
+			return
+		}
+		a.inBackground("dht providers", func(ctx context.Context) error {
+			provs, err := a.Node.DHTFindProviders(ctx, key)
+			if err != nil {
+				return err
+			}
+			a.ui.Printf("[DHT] %d providers of %s\n", len(provs), shortID(dht.KeyHex(key)))
+			for _, p := range provs {
+				a.ui.Printf("  %-10s  %-21s  %s\n", shortID(p.PeerID), p.Addr, p.Name)
+			}
+			return nil
+		})
+
+	case "buckets":
+		a.printBuckets()
+
+	case "lookup":
+		target, pathsArg, _ := strings.Cut(rest, " ")
+		if _, err := dht.ParseNodeIDHex(target); err != nil {
+			a.ui.Println("dht lookup: target must be a 64 hex digit node ID")
+			return
+		}
+		paths := 1
+		if pathsArg = strings.TrimSpace(pathsArg); pathsArg != "" {
+			p, err := strconv.Atoi(pathsArg)
+			if err != nil || p < 1 || p > 16 {
+				a.ui.Println("dht lookup: paths must be between 1 and 16")
+				return
+			}
+			paths = p
+		}
+		go a.traceLookup(target, paths)
+
+	default:
+		a.printDHTUsage()
+	}
+}
+
+// inBackground runs fn with a dhtTimeout context on its own goroutine and
+// prints its error, if any, prefixed with what.
+func (a *App) inBackground(what string, fn func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), dhtTimeout)
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			a.ui.Printf("%s: %v\n", what, err)
+		}
+	}()
+}
+
+func (a *App) printDHTValue(ctx context.Context, key [32]byte) error {
+	rec, ok, err := a.Node.DHTGet(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok || rec == nil {
+		a.ui.Printf("[DHT] no value under %s\n", shortID(dht.KeyHex(key)))
+		return nil
+	}
+
+	a.ui.Println()
+	a.ui.Printf("  Key:      %s\n", dht.KeyHex(key))
+	a.ui.Printf("  Type:     %s\n", strings.ToLower(rec.Type))
+	if rec.Type == dht.RecordMutable {
+		a.ui.Printf("  Owner:    %s\n", hex.EncodeToString(rec.PubKey))
+		a.ui.Printf("  Seq:      %d\n", rec.Seq)
+	}
+	if rec.ExpiresUnix != 0 {
+		a.ui.Printf("  Expires:  in %s\n", time.Until(time.Unix(rec.ExpiresUnix, 0)).Round(time.Second))
+	}
+	if dht.IsCached(rec) {
+		a.ui.Printf("  Cached:   for %s more\n", time.Until(time.Unix(rec.CachedUntilUnix, 0)).Round(time.Second))
+	}
+	a.ui.Printf("  Value:    %s\n", displayValue(rec.Value))
+	a.ui.Println()
+	return nil
+}
+
+// displayValue shows text as text and anything else as hex.
+func displayValue(v []byte) string {
+	if utf8.Valid(v) && !strings.ContainsFunc(string(v), func(r rune) bool { return r < 0x20 && r != '\t' }) {
+		return fmt.Sprintf("%q", v)
+	}
+	return "0x" + hex.EncodeToString(v)
+}
+
+func (a *App) printBuckets() {
+	buckets := a.Node.DHTBuckets()
+	if len(buckets) == 0 {
+		a.ui.Println("routing table is empty")
+		return
+	}
+	now := time.Now()
+	a.ui.Println()
+	a.ui.Printf("%-6s  %-5s  %-4s  %-8s  %-8s  %s\n", "BUCKET", "NODES", "REPL", "NEWEST", "OLDEST", "PEERS (most recent first)")
+	a.ui.Printf("%-6s  %-5s  %-4s  %-8s  %-8s  %s\n", "------", "-----", "----", "------", "------", "-----")
+	for _, b := range buckets {
+		newest, oldest := "-", "-"
+		names := make([]string, 0, len(b.Nodes))
+		if len(b.Nodes) > 0 {
+			newest = now.Sub(b.Nodes[0].LastSeen).Round(time.Second).String()
+			oldest = now.Sub(b.Nodes[len(b.Nodes)-1].LastSeen).Round(time.Second).String()
+		}
+		for _, ni := range b.Nodes {
+			label := shortID(ni.PeerID)
+			if ni.Name != "" {
+				label = ni.Name
+			}
+			names = append(names, label)
+		}
+		a.ui.Printf("%-6d  %-5d  %-4d  %-8s  %-8s  %s\n", b.Index, len(b.Nodes), b.Replacements, newest, oldest, strings.Join(names, ", "))
+	}
+	a.ui.Println()
+}
+
+// traceLookup prints each query of a lookup as it completes, then the
+// closest nodes it found. With more than one path it also says which path
+// made each query and how much the paths agreed.
+func (a *App) traceLookup(target string, paths int) {
+	a.ui.Printf("[DHT] lookup %s\n", shortID(target))
+	hops := 0
+	trace := func(h dht.LookupHop) {
+		hops++
+		status := fmt.Sprintf("%d nodes, %d new", h.Returned, h.New)
+		if h.Err != nil {
+			status = "failed: " + h.Err.Error()
+		}
+		via := ""
+		if paths > 1 {
+			via = fmt.Sprintf("path %d  ", h.Path)
+		}
+		a.ui.Printf("  %sround %-2d  %-10s  %-21s  dist 2^%-3d  %6s  %s\n",
+			via, h.Round, shortID(h.PeerID), h.Addr, distanceLog2(h.Distance), h.RTT.Round(time.Millisecond), status)
+	}
+
+	var nodes []proto.DHTNode
+	var err error
+	if paths > 1 {
+		var res dht.DisjointLookup
+		res, err = a.Node.DHTLookupDisjoint(target, paths, trace)
+		nodes = res.Nodes
+		if err == nil {
+			a.ui.Printf("[DHT] %d of %d paths reached the closest node\n", int(res.Agreement*float64(res.Paths)+0.5), res.Paths)
+		}
+	} else {
+		nodes, err = a.Node.DHTLookup(target, trace)
+	}
+	if err != nil {
+		a.ui.Printf("dht lookup: %v\n", err)
+		return
+	}
+	a.ui.Printf("[DHT] %d queries; closest nodes:\n", hops)
+	for _, nd := range nodes {
+		a.ui.Printf("  %-10s  %-21s  %s\n", shortID(nd.PeerID), nd.Addr, nd.Name)
+	}
+	if len(nodes) == 0 {
+		a.ui.Println("  (none)")
+	}
+}
+
+// distanceLog2 is the position of the highest set bit of an XOR distance,
+// i.e. d lies in [2^k, 2^(k+1)). A zero distance gives -1.
+func distanceLog2(d dht.NodeID) int {
+	var zero dht.NodeID
+	if bi := dht.BucketIndex(zero, d); bi >= 0 {
+		return 255 - bi
+	}
+	return -1
+}
@@ -0,0 +1,191 @@
+package parknode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"p2p-park/internal/app/dm"
+	"p2p-park/internal/proto"
+)
+
+const dmHistoryLimit = 20
+
+// resolveUser turns a full userID, a userID prefix or a connected peer's
+// name into a full userID.
+func (a *App) resolveUser(who string) (string, error) {
+	if len(who) == 64 {
+		return strings.ToLower(who), nil
+	}
+
+	var match string
+	for _, p := range a.Node.SnapshotPeers() {
+		if p.UserID == "" {
+			continue
+		}
+		if strings.EqualFold(p.Name, who) || strings.HasPrefix(p.UserID, strings.ToLower(who)) {
+			if match != "" && match != p.UserID {
+				return "", fmt.Errorf("ambiguous user %q", who)
+			}
+			match = p.UserID
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("unknown user %q (use a full userID if not connected)", who)
+	}
+	return match, nil
+}
+
+// sendToUser delivers env directly if connected to userID, else via a seed.
+func (a *App) sendToUser(userID string, env proto.Envelope) (relayed bool, err error) {
+	if err := a.Node.SendToUserID(userID, env); err == nil {
+		return false, nil
+	}
+	return true, a.Node.SendViaRelay(userID, env)
+}
+
+func (a *App) sendDirect(who, text string) {
+	userID, err := a.resolveUser(who)
+	if err != nil {
+		a.ui.Printf("msg: %v\n", err)
+		return
+	}
+	if userID == a.userIDHex() {
+		a.ui.Println("msg: cannot message yourself")
+		return
+	}
+
+	wire, m, err := a.DMs.Compose(userID, text)
+	if err != nil {
+		a.ui.Printf("msg: %v\n", err)
+		return
+	}
+	m.PeerName = a.Node.PeerDisplayName(a.peerIDForUser(userID))
+
+	// Stored before it goes out: the ack can beat sendToUser back.
+	if a.DMStore != nil {
+		_, _ = a.DMStore.Put(m)
+	}
+	relayed, err := a.sendToUser(userID, proto.Envelope{
+		Type:    proto.MsgDirect,
+		FromID:  a.Node.ID(),
+		Payload: proto.MustMarshal(wire),
+	})
+	if err != nil {
+		if a.DMStore != nil {
+			_ = a.DMStore.Delete(m.ID)
+		}
+		a.ui.Printf("msg: %v\n", err)
+		return
+	}
+
+	via := "direct"
+	if relayed {
+		via = "via seed"
+	}
+	a.ui.Printf("[DM] -> %s (%s, %s)\n", shortID(userID), shortID(m.ID), via)
+}
+
+func (a *App) handleDirect(env proto.Envelope) {
+	var wire proto.DirectMessage
+	if err := json.Unmarshal(env.Payload, &wire); err != nil {
+		return
+	}
+	m, err := a.DMs.Open(wire)
+	if err != nil {
+		a.logf("dm from %s rejected: %v", shortID(env.FromID), err)
+		return
+	}
+
+	fresh := true
+	if a.DMStore != nil {
+		fresh, _ = a.DMStore.Put(m)
+	}
+
+	// Always ack, even duplicates: the first ack may have been lost.
+	_, _ = a.sendToUser(m.PeerUserID, proto.Envelope{
+		Type:    proto.MsgDirectAck,
+		FromID:  a.Node.ID(),
+		Payload: proto.MustMarshal(a.DMs.Ack(m.ID)),
+	})
+
+	if !fresh {
+		return
+	}
+	name := m.PeerName
+	if name == "" {
+		name = shortID(m.PeerUserID)
+	}
+	ts := time.Unix(m.Timestamp, 0).Format("15:04:05")
+	a.ui.Printf("%s[%s]%s [DM] %s: %s\n", ansiDim, ts, ansiReset, formatName(name, m.PeerUserID), m.Text)
+}
+
+func (a *App) handleDirectAck(env proto.Envelope) {
+	var ack proto.DirectAck
+	if err := json.Unmarshal(env.Payload, &ack); err != nil {
+		return
+	}
+	from, err := dm.VerifyAck(ack)
+	if err != nil {
+		a.logf("dm ack from %s rejected: %v", shortID(env.FromID), err)
+		return
+	}
+	if a.DMStore == nil {
+		return
+	}
+	// Only the recipient of one of our messages may ack it.
+	m, ok, err := a.DMStore.Get(ack.ID)
+	if err != nil || !ok || !m.Outgoing || m.PeerUserID != from {
+		return
+	}
+	if ok, _ := a.DMStore.MarkDelivered(ack.ID, time.Now().Unix()); ok {
+		a.ui.Printf("[DM] delivered to %s (%s)\n", shortID(from), shortID(ack.ID))
+	}
+}
+
+func (a *App) printDirect(who string) {
+	if a.DMStore == nil {
+		return
+	}
+	userID := ""
+	if who != "" {
+		id, err := a.resolveUser(who)
+		if err != nil {
+			a.ui.Printf("dms: %v\n", err)
+			return
+		}
+		userID = id
+	}
+	msgs, err := a.DMStore.List(userID, dmHistoryLimit)
+	if err != nil {
+		a.ui.Printf("dms: %v\n", err)
+		return
+	}
+	if len(msgs) == 0 {
+		a.ui.Println("no direct messages")
+		return
+	}
+
+	a.ui.Println("== Direct messages ==")
+	for _, m := range msgs {
+		ts := time.Unix(m.Timestamp, 0).Format("01-02 15:04")
+		dir := "<-"
+		if m.Outgoing {
+			dir = "->"
+		}
+		status := ""
+		if m.Outgoing {
+			status = "  (pending)"
+			if m.DeliveredAt != 0 {
+				status = "  (delivered)"
+			}
+		}
+		a.ui.Printf("%s %s %s: %s%s\n", ts, dir, shortID(m.PeerUserID), m.Text, status)
+	}
+}
+
+func (a *App) peerIDForUser(userID string) string {
+	pid, _ := a.Node.NetworkPeerIDForUserID(userID)
+	return pid
+}
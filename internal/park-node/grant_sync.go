@@ -1,19 +1,25 @@
 package parknode
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
 	"p2p-park/internal/app/grants"
+	"p2p-park/internal/p2p"
 	"p2p-park/internal/proto"
 )
 
 const (
+	grantSyncProtocol  = "grants/sync"
 	grantSyncRecentIDs = 64
 	grantSyncLimit     = 1000
 	grantSyncOverlap   = 5 * time.Minute
+	grantSyncTimeout   = 15 * time.Second
 )
 
+// initiateGrantSync pulls the grants peerID has and we lack. The peer does
+// the same towards us when it sees the connection.
 func (a *App) initiateGrantSync(peerID string) {
 	if a.GrantStore == nil {
 		return
@@ -34,76 +40,53 @@ func (a *App) initiateGrantSync(peerID string) {
 		RecentGrantIDs: recent,
 	}
 
-	body, _ := json.Marshal(sum)
-	_ = a.Node.SendToPeer(peerID, proto.Envelope{
-		Type:    proto.MsgGrantSyncSummary,
-		FromID:  a.Node.ID(),
-		Payload: body,
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), grantSyncTimeout)
+	defer cancel()
+	raw, err := a.Node.Request(ctx, peerID, grantSyncProtocol, sum)
+	if err != nil {
+		a.logf("grant sync with %s: %v", shortID(peerID), err)
+		return
+	}
+	var resp proto.GrantSyncResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		a.logf("grant sync with %s: bad response: %v", shortID(peerID), err)
+		return
+	}
+	a.applySyncedGrants(resp.Grants)
 }
 
-func (a *App) handleGrantSyncSummary(env proto.Envelope) {
+// serveGrantSync answers a peer's summary with the grants it is missing, if
+// we are ahead of it.
+func (a *App) serveGrantSync(_ context.Context, _ string, payload json.RawMessage) (any, error) {
 	if a.GrantStore == nil {
-		return
+		return proto.GrantSyncResponse{}, nil
 	}
 	var sum proto.GrantSyncSummary
-	if err := json.Unmarshal(env.Payload, &sum); err != nil {
-		return
+	if err := json.Unmarshal(payload, &sum); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCBadRequest, Message: err.Error()}
 	}
 
 	localMax, err := a.GrantStore.MaxTimestamp()
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	// If remote is ahead of us, request missing grants.
-	if sum.MaxTimestamp > localMax {
-		since := localMax - int64(grantSyncOverlap.Seconds())
-		if since < 0 {
-			since = 0
-		}
-		req := proto.GrantSyncRequest{SinceTimestamp: since, Limit: grantSyncLimit}
-		body, _ := json.Marshal(req)
-		_ = a.Node.SendToPeer(env.FromID, proto.Envelope{
-			Type:    proto.MsgGrantSyncRequest,
-			FromID:  a.Node.ID(),
-			Payload: body,
-		})
+	if localMax <= sum.MaxTimestamp {
+		return proto.GrantSyncResponse{}, nil
 	}
 
-}
-
-func (a *App) handleGrantSyncRequest(env proto.Envelope) {
-	if a.GrantStore == nil {
-		return
-	}
-	var req proto.GrantSyncRequest
-	if err := json.Unmarshal(env.Payload, &req); err != nil {
-		return
+	since := sum.MaxTimestamp - int64(grantSyncOverlap.Seconds())
+	if since < 0 {
+		since = 0
 	}
-	grs, err := a.GrantStore.ListSince(req.SinceTimestamp, req.Limit)
+	grs, err := a.GrantStore.ListSince(since, grantSyncLimit)
 	if err != nil {
-		return
+		return nil, err
 	}
-	resp := proto.GrantSyncResponse{Grants: grs}
-	body, _ := json.Marshal(resp)
-	_ = a.Node.SendToPeer(env.FromID, proto.Envelope{
-		Type:    proto.MsgGrantSyncResponse,
-		FromID:  a.Node.ID(),
-		Payload: body,
-	})
+	return proto.GrantSyncResponse{Grants: grs}, nil
 }
 
-func (a *App) handleGrantSyncResponse(env proto.Envelope) {
-	if a.GrantStore == nil {
-		return
-	}
-	var resp proto.GrantSyncResponse
-	if err := json.Unmarshal(env.Payload, &resp); err != nil {
-		return
-	}
-
-	for _, g := range resp.Grants {
+func (a *App) applySyncedGrants(grs []proto.QuizGrant) {
+	for _, g := range grs {
 		// verify first to avoid persisting garbage
 		if err := grants.VerifyGrant(g); err != nil {
 			continue
@@ -10,22 +10,21 @@ import (
 	"p2p-park/internal/proto"
 )
 
-func (a *App) handleEnvelope(env proto.Envelope) {
-	switch env.Type {
-	case proto.MsgGossip:
-	case proto.MsgGrantSyncSummary:
-		a.handleGrantSyncSummary(env)
-		return
-	case proto.MsgGrantSyncRequest:
-		a.handleGrantSyncRequest(env)
-		return
-	case proto.MsgGrantSyncResponse:
-		a.handleGrantSyncResponse(env)
-		return
-	default:
-		return
+const appHandlerQueue = 64
+
+// registerHandlers routes the app's own message types to it. Gossip still
+// arrives through Node.Incoming, after the node has deduplicated it.
+func (a *App) registerHandlers() {
+	if a.cfg.Debug {
+		a.Node.Use(p2p.LoggingMiddleware(a.Node.Logf))
 	}
+	// Direct messages are only accepted once the connection has identified.
+	auth := p2p.WithMiddleware(p2p.RequireIdentified())
+	_ = a.Node.Handle(proto.MsgDirect, func(in p2p.Inbound) { a.handleDirect(in.Env) }, p2p.WithQueue(appHandlerQueue), auth)
+	_ = a.Node.Handle(proto.MsgDirectAck, func(in p2p.Inbound) { a.handleDirectAck(in.Env) }, p2p.WithQueue(appHandlerQueue), auth)
+}
 
+func (a *App) handleGossip(env proto.Envelope) {
 	var g proto.Gossip
 	if err := json.Unmarshal(env.Payload, &g); err != nil {
 		return
@@ -121,15 +120,7 @@ func (a *App) handleQuiz(env proto.Envelope, g proto.Gossip) {
 		if correct {
 			res.Delta = grant.Points
 		}
-		resWire := proto.QuizWire{Kind: "result", Result: &res}
-		resBody, _ := json.Marshal(resWire)
-		resG := proto.Gossip{ID: p2p.NewMsgID(), Channel: "quiz", Body: resBody}
-
-		_ = a.Node.SendToPeer(env.FromID, proto.Envelope{
-			Type:    proto.MsgGossip,
-			FromID:  a.Node.ID(),
-			Payload: proto.MustMarshal(resG),
-		})
+		go a.deliverQuizResult(env.FromID, res)
 
 		if correct {
 			grantWire := proto.QuizWire{Kind: "grant", Grant: &grant}
@@ -159,19 +150,6 @@ func (a *App) handleQuiz(env proto.Envelope, g proto.Gossip) {
 				a.ui.Printf("[POINTS] +%d (quiz grant) => total %d\n", g.Points, a.Ledger.Total(g.RecipientID))
 			}
 		}
-
-	case "result":
-		if qw.Result == nil {
-			return
-		}
-		if qw.Result.PlayerID != a.userIDHex() {
-			return
-		}
-		if qw.Result.Correct {
-			a.ui.Printf("[QUIZ] correct! +%d points (quiz %s)\n", qw.Result.Delta, shortID(qw.Result.QuizID))
-		} else {
-			a.ui.Printf("[QUIZ] incorrect (quiz %s)\n", shortID(qw.Result.QuizID))
-		}
 	}
 }
 
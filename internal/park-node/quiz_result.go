@@ -0,0 +1,40 @@
+package parknode
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"p2p-park/internal/p2p"
+	"p2p-park/internal/proto"
+)
+
+const (
+	quizResultProtocol = "quiz/result"
+	quizResultTimeout  = 10 * time.Second
+)
+
+// deliverQuizResult tells the answerer how their answer was graded.
+func (a *App) deliverQuizResult(peerID string, res proto.QuizResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), quizResultTimeout)
+	defer cancel()
+	if _, err := a.Node.Request(ctx, peerID, quizResultProtocol, res); err != nil {
+		a.logf("quiz result to %s: %v", shortID(peerID), err)
+	}
+}
+
+func (a *App) serveQuizResult(_ context.Context, from string, payload json.RawMessage) (any, error) {
+	var res proto.QuizResult
+	if err := json.Unmarshal(payload, &res); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCBadRequest, Message: err.Error()}
+	}
+	if res.PlayerID != a.userIDHex() {
+		return nil, &p2p.RPCError{Code: p2p.RPCBadRequest, Message: "result is for another player"}
+	}
+	if res.Correct {
+		a.ui.Printf("[QUIZ] correct! +%d points (quiz %s)\n", res.Delta, shortID(res.QuizID))
+	} else {
+		a.ui.Printf("[QUIZ] incorrect (quiz %s)\n", shortID(res.QuizID))
+	}
+	return struct{}{}, nil
+}
@@ -27,6 +27,8 @@ func PrintBanner(p Printer, n *p2p.Node) {
 func PrintCommands(p Printer) {
 	p.Println("Commands:")
 	p.Println("    /say <message>               - broadcast a chat-like message")
+	p.Println("    /msg <user> <text>           - encrypted direct message (name, userID or prefix)")
+	p.Println("    /dms [user]                  - show direct message history")
 	p.Println("    /add <delta>                 - (dev) add points to yourself")
 	p.Println("    /me                          - prints your info")
 	p.Println("    /points                      - show current scores")
@@ -34,7 +36,13 @@ func PrintCommands(p Printer) {
 	p.Println("    /quizask <pts> <ttl_s> <question> | <answer>  - create a quiz (answer not broadcast)")
 	p.Println("    /quizzes                     - list open quizzes")
 	p.Println("    /quizanswer <quiz_id> <answer>              - answer a quiz")
-	p.Println("    /peers                       - show connected peers")
+	p.Println("    /peers                       - show connected and sticky peers")
+	p.Println("    /stats                       - show bandwidth per peer and protocol")
+	p.Println("    /dht <command> ...           - use the DHT (/dht lists its commands)")
+	p.Println("    /stick <addr|userID>         - keep reconnecting to a peer")
+	p.Println("    /unstick <addr|userID>       - stop reconnecting to a peer")
+	p.Println("    /connect <userID>            - find a user via the DHT and connect")
+	p.Println("    /relay <userID>              - connect to a user through a seed circuit")
 	p.Println("    /mkchan <name>               - make an encrypted channel")
 	p.Println("    /joinchan <name> <hexkey>    - join an encrypted channel")
 	p.Println("    /encsay <chan> <message>     - encrypted broadcast to channel")
@@ -0,0 +1,29 @@
+package proto
+
+const (
+	MsgCircuitOpen  MessageType = "circuit_open"
+	MsgCircuitData  MessageType = "circuit_data"
+	MsgCircuitClose MessageType = "circuit_close"
+)
+
+// CircuitOpen asks a seed for a relayed stream to a registered user. The
+// seed forwards it to that user with FromUserID filled in instead.
+// The circuit then carries an ordinary end-to-end Noise session.
+type CircuitOpen struct {
+	ID         string `json:"id"`
+	ToUserID   string `json:"to_user_id,omitempty"`   // client -> seed
+	FromUserID string `json:"from_user_id,omitempty"` // seed -> target
+}
+
+// CircuitData is one chunk of the relayed byte stream.
+type CircuitData struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// CircuitClose tears a circuit down. The seed sends it to both ends when a
+// limit is hit or either side goes away.
+type CircuitClose struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
@@ -7,7 +7,8 @@ type DHTWire struct {
 	// Kind is one of:
 	// "PING", "PONG", "FIND_NODE", "NODES",
 	// "STORE", "STORE_RESULT",
-	// "FIND_VALUE", "VALUE"
+	// "FIND_VALUE", "VALUE",
+	// "ADD_PROVIDER" (answered with STORE_RESULT), "GET_PROVIDERS", "PROVIDERS"
 	Kind string `json:"kind"`
 
 	// RPC correlation
@@ -16,15 +17,18 @@ type DHTWire struct {
 	// The lookup target for FIND_NODE (32-byte node id, hex string)
 	Target string `json:"target,omitempty"`
 
-	// Returned nodes for NODES (and sometimes VALUE fallback)
+	// Returned nodes for NODES, VALUE (with or without a record) and PROVIDERS
 	Nodes []DHTNode `json:"nodes,omitempty"`
 
-	// Key for STORE/FIND_VALUE/VALUE (32-byte key, hex string)
+	// Key for STORE/FIND_VALUE/VALUE and the provider kinds (32-byte key, hex string)
 	Key string `json:"key,omitempty"`
 
 	// Record for STORE/VALUE
 	Record *DHTRecord `json:"record,omitempty"`
 
+	// Providers for ADD_PROVIDER (exactly the sender) and PROVIDERS
+	Providers []DHTNode `json:"providers,omitempty"`
+
 	// STORE_RESULT
 	OK    bool   `json:"ok,omitempty"`
 	Error string `json:"error,omitempty"`
@@ -35,6 +39,8 @@ type DHTNode struct {
 	PeerID string `json:"peer_id"` // 64 hex chars; hex(pubkey)
 	Addr   string `json:"addr"`    // host:port
 	Name   string `json:"name,omitempty"`
+
+	Record *PeerRecord `json:"record,omitempty"` // the node's signed address record
 }
 
 // DHTRecord supports immutable + mutable records.
@@ -57,4 +63,8 @@ type DHTRecord struct {
 
 	// Optional “name/namespace” for mutable keys (helps debugging, optional)
 	Name string `json:"name,omitempty"`
+
+	// Set on path-cached copies: when the copy is dropped, at the latest.
+	// Not signed; a cached copy is never republished.
+	CachedUntilUnix int64 `json:"cached_until_unix,omitempty"`
 }
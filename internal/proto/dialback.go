@@ -0,0 +1,21 @@
+package proto
+
+const (
+	MsgDialBack       MessageType = "dial_back"
+	MsgDialBackResult MessageType = "dial_back_result"
+)
+
+// DialBack asks a peer to open a fresh connection to Addr and check that the
+// requester answers there. The peer only dials hosts matching the address it
+// observes the requester connecting from.
+type DialBack struct {
+	Nonce string `json:"nonce"`
+	Addr  string `json:"addr"`
+}
+
+// DialBackResult reports whether the dial-back reached the requester.
+type DialBackResult struct {
+	Nonce string `json:"nonce"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,71 @@
+package proto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const (
+	MsgDirect    MessageType = "direct"
+	MsgDirectAck MessageType = "direct_ack"
+)
+
+// DirectMessage is an end-to-end encrypted message to a single user.
+// The body is sealed to the recipient's ed25519 identity key (converted to
+// X25519) with a fresh ephemeral key, and the whole message is signed by
+// the sender's identity key so relays can neither read nor forge it.
+type DirectMessage struct {
+	ID           string `json:"id"`
+	FromPub      []byte `json:"from_pub"` // sender ed25519 pub
+	ToPub        []byte `json:"to_pub"`   // recipient ed25519 pub
+	EphemeralPub []byte `json:"eph_pub"`  // sender's one-time X25519 pub
+	Nonce        []byte `json:"nonce"`
+	Ciphertext   []byte `json:"ct"`
+	Timestamp    int64  `json:"ts"`
+	Signature    []byte `json:"sig"`
+}
+
+// DirectBody is the plaintext sealed inside DirectMessage.Ciphertext.
+type DirectBody struct {
+	Name string `json:"name"` // sender display name
+	Text string `json:"text"`
+}
+
+// DirectAck confirms receipt of a DirectMessage. It is signed by the recipient.
+type DirectAck struct {
+	ID        string `json:"id"`       // DirectMessage.ID being acknowledged
+	FromPub   []byte `json:"from_pub"` // acknowledging user (the DM recipient)
+	Timestamp int64  `json:"ts"`
+	Signature []byte `json:"sig"`
+}
+
+// EncodeDirectMessageCanonical returns the bytes signed for a DirectMessage:
+// sha256( id || from_pub || to_pub || eph_pub || nonce || ct || ts ),
+// with each variable-length field length-prefixed.
+func EncodeDirectMessageCanonical(m DirectMessage) []byte {
+	h := sha256.New()
+	for _, f := range [][]byte{[]byte(m.ID), m.FromPub, m.ToPub, m.EphemeralPub, m.Nonce, m.Ciphertext} {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(f)))
+		h.Write(l[:])
+		h.Write(f)
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(m.Timestamp))
+	h.Write(ts[:])
+	return h.Sum(nil)
+}
+
+// EncodeDirectAckCanonical returns the bytes signed for a DirectAck.
+func EncodeDirectAckCanonical(a DirectAck) []byte {
+	h := sha256.New()
+	h.Write([]byte("ack"))
+	h.Write([]byte{0})
+	h.Write([]byte(a.ID))
+	h.Write([]byte{0})
+	h.Write(a.FromPub)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(a.Timestamp))
+	h.Write(ts[:])
+	return h.Sum(nil)
+}
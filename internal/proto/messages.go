@@ -5,15 +5,15 @@ import "encoding/json"
 type MessageType string
 
 const (
-	MsgHello             MessageType = "hello"
-	MsgPeerList          MessageType = "peer_list"
-	MsgGossip            MessageType = "gossip"
-	MsgIdentify          MessageType = "identify"
-	MsgNatRegister       MessageType = "nat_register"
-	MsgNatRelay          MessageType = "nat_relay"
-	MsgGrantSyncSummary  MessageType = "grant_sync_summary"
-	MsgGrantSyncRequest  MessageType = "grant_sync_request"
-	MsgGrantSyncResponse MessageType = "grant_sync_response"
+	MsgHello       MessageType = "hello"
+	MsgGossip      MessageType = "gossip"
+	MsgIdentify    MessageType = "identify"
+	MsgNatRegister MessageType = "nat_register"
+	MsgNatRelay    MessageType = "nat_relay"
+	MsgPing        MessageType = "ping"
+	MsgPong        MessageType = "pong"
+	MsgUnsupported MessageType = "unsupported"
+	MsgGoodbye     MessageType = "goodbye"
 )
 
 type Envelope struct {
@@ -27,6 +27,7 @@ type Hello struct {
 	Name     string `json:"name"`
 	Listen   string `json:"listen"`
 	Protocol string `json:"procol"`
+	Nonce    string `json:"nonce,omitempty"` // random per connection; breaks duplicate-connection ties
 }
 
 // PeerInfo describes another peer we know about.
@@ -35,11 +36,8 @@ type PeerInfo struct {
 	Name       string `json:"name"`
 	Addr       string `json:"addr"`
 	PublicAddr string `json:"public_addr"`
-}
 
-// PeerList is exchanged through gossip to populate other peers' Peerlist.
-type PeerList struct {
-	Peers []PeerInfo `json:"peers"`
+	Record *PeerRecord `json:"record,omitempty"` // required before Addr is dialed
 }
 
 // Gossip is our generics "app-level broadcast" payload.
@@ -59,8 +57,15 @@ type ChatMessage struct {
 // Identify is sent by each peer after the transport is secured.
 // It tells the remote side "who I am" at the app level.
 type Identify struct {
-	Name    string `json:"name"`     // display name
-	UserPub []byte `json:"user_pub"` // ed25519 public key bytes
+	Name    string `json:"name"`           // display name
+	UserPub []byte `json:"user_pub"`       // ed25519 public key bytes
+	IsSeed  bool   `json:"seed,omitempty"` // sender keeps a NAT registry and relays
+
+	ObservedAddr string `json:"observed_addr,omitempty"` // receiver's address as the sender sees it
+	Addr         string `json:"addr,omitempty"`          // sender's confirmed or port-mapped external address
+	Reachability string `json:"reach,omitempty"`         // sender's "public", "private" or "unknown"
+
+	Record *PeerRecord `json:"record,omitempty"` // sender's own signed address record
 }
 
 // PointsSnapshot represents "here is my current score".
@@ -97,7 +102,28 @@ type NatRegister struct {
 // to the target user if they're registered and connected.
 type NatRelay struct {
 	ToUserID string          `json:"to_user_id"` // target user
-	Payload  json.RawMessage `json:"payload"`    // opaque; app defines
+	Payload  json.RawMessage `json:"payload"`    // inner Envelope JSON
+}
+
+// Ping is a transport-level keepalive. The receiver echoes Seq back in a Pong.
+// It is independent of the DHT's PING/PONG RPCs.
+type Ping struct {
+	Seq uint64 `json:"seq"`
+}
+
+// Pong answers a Ping with the same Seq.
+type Pong struct {
+	Seq uint64 `json:"seq"`
+}
+
+// Unsupported tells the sender we have no handler for a message type.
+type Unsupported struct {
+	Type MessageType `json:"type"`
+}
+
+// Goodbye is the last envelope on a connection the sender is closing.
+type Goodbye struct {
+	Reason string `json:"reason,omitempty"`
 }
 
 type GrantSyncSummary struct {
@@ -105,11 +131,6 @@ type GrantSyncSummary struct {
 	RecentGrantIDs []string `json:"recent_ids,omitempty"`
 }
 
-type GrantSyncRequest struct {
-	SinceTimestamp int64 `json:"since_ts"`
-	Limit          int   `json:"limit"`
-}
-
 type GrantSyncResponse struct {
 	Grants []QuizGrant `json:"grants"`
 }
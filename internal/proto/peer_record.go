@@ -0,0 +1,47 @@
+package proto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// PeerRecord is a node's self-signed statement of where it can be dialed.
+// It is signed with the user key in UserPub, so whoever relays it through
+// PEX or the DHT cannot change the addresses. A higher Seq from the same
+// key replaces an older record.
+type PeerRecord struct {
+	NetworkID   string   `json:"network_id"` // Noise static key, hex
+	UserPub     []byte   `json:"user_pub"`   // ed25519 key that signed the record
+	Addrs       []string `json:"addrs"`      // host:port, most preferred first
+	Seq         uint64   `json:"seq"`
+	ExpiresUnix int64    `json:"expires_unix"`
+	Signature   []byte   `json:"sig"`
+}
+
+// EncodePeerRecordCanonical returns the bytes signed for a PeerRecord:
+// sha256( "peer-record" || network_id || user_pub || addrs... || seq || expires ),
+// with each variable-length field length-prefixed.
+func EncodePeerRecordCanonical(r PeerRecord) []byte {
+	h := sha256.New()
+	field := func(b []byte) {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+		h.Write(l[:])
+		h.Write(b)
+	}
+	field([]byte("peer-record"))
+	field([]byte(r.NetworkID))
+	field(r.UserPub)
+	var k [4]byte
+	binary.BigEndian.PutUint32(k[:], uint32(len(r.Addrs)))
+	h.Write(k[:])
+	for _, a := range r.Addrs {
+		field([]byte(a))
+	}
+	var u [8]byte
+	binary.BigEndian.PutUint64(u[:], r.Seq)
+	h.Write(u[:])
+	binary.BigEndian.PutUint64(u[:], uint64(r.ExpiresUnix))
+	h.Write(u[:])
+	return h.Sum(nil)
+}
@@ -0,0 +1,13 @@
+package proto
+
+// PexRequest asks a peer for others we could connect to. It is served over
+// the request/response layer as protocol "p2p/pex".
+type PexRequest struct {
+	Max int `json:"max"` // most entries wanted; the server applies its own cap too
+}
+
+// PexResponse is a random sample of the server's peers. Only entries with a
+// signed Record are of any use to the receiver.
+type PexResponse struct {
+	Peers []PeerInfo `json:"peers"`
+}
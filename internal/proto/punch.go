@@ -0,0 +1,23 @@
+package proto
+
+const (
+	MsgPunchRequest MessageType = "punch_request"
+	MsgPunchSync    MessageType = "punch_sync"
+)
+
+// PunchRequest asks a seed to coordinate a TCP hole punch with a user that
+// is registered with it.
+type PunchRequest struct {
+	ToUserID string `json:"to_user_id"`
+}
+
+// PunchSync is sent by the seed to both sides of a hole punch. Each side
+// waits DelayMs, then dials Addr from the local endpoint of its connection
+// to the seed so both SYNs cross and open each NAT's mapping.
+type PunchSync struct {
+	PeerID    string `json:"peer_id"`   // network ID of the other side
+	UserID    string `json:"user_id"`   // userID of the other side
+	Addr      string `json:"addr"`      // other side's address as observed by the seed
+	Initiator bool   `json:"initiator"` // run the Noise handshake as the dialer
+	DelayMs   int64  `json:"delay_ms"`  // evens out the seed's RTT to each side
+}
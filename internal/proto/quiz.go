@@ -10,11 +10,10 @@ import (
 // QuizWire is carried inside Gossip.Body for channel "quiz".
 // It is intentionally simple and explicit.
 type QuizWire struct {
-	Kind   string          `json:"kind"` // "open" | "answer" | "grant" | "result"
+	Kind   string          `json:"kind"` // "open" | "answer" | "grant"
 	Open   *QuizOpenSigned `json:"open,omitempty"`
 	Answer *QuizAnswer     `json:"answer,omitempty"`
 	Grant  *QuizGrant      `json:"grant,omitempty"`
-	Result *QuizResult     `json:"result,omitempty"`
 }
 
 // QuizOpen is the public announcement of a quiz.
@@ -52,6 +51,8 @@ type QuizGrant struct {
 	Signature   []byte `json:"sig"`
 }
 
+// QuizResult tells an answerer how the creator graded their answer. It is
+// delivered as a "quiz/result" request rather than gossiped.
 type QuizResult struct {
 	QuizID   string `json:"quiz_id"`
 	PlayerID string `json:"player_id"` // UserID (ed25519 hex)
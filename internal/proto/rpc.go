@@ -0,0 +1,31 @@
+package proto
+
+import "encoding/json"
+
+const (
+	MsgRequest  MessageType = "request"
+	MsgResponse MessageType = "response"
+)
+
+// Request is one call of Node.Request. Protocol names the handler on the
+// remote side, e.g. "grants/sync".
+type Request struct {
+	ID        string          `json:"id"`
+	Protocol  string          `json:"protocol"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	TimeoutMs int64           `json:"timeout_ms,omitempty"` // how long the caller will wait
+}
+
+// Response answers the Request with the same ID: either Payload or Error.
+type Response struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is an error reply. Code is machine-readable (see the p2p package
+// for the codes it produces); Message is for humans.
+type RPCError struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,265 @@
+package dhtbolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
+
+const (
+	bRecords = "records"   // key -> JSON DHTRecord
+	bExpiry  = "by_expiry" // dht.RecordExpiry || key -> nil, for records that expire
+	bOwned   = "owned"     // key -> next republish, unix nanoseconds
+
+	keyLen    = 32
+	defaultTO = 2 * time.Second
+)
+
+// Store is a BoltDB-backed implementation of dht.RecordStore. It also
+// persists the set of records we own.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) a BoltDB database at path.
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("empty db path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: defaultTO})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db}
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range []string{bRecords, bExpiry, bOwned} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error { return s.db.Close() }
+
+func (s *Store) Get(key [32]byte, now time.Time) (*proto.DHTRecord, bool) {
+	var rec *proto.DHTRecord
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		rec = decodeRecord(tx.Bucket([]byte(bRecords)).Get(key[:]))
+		return nil
+	})
+	if rec == nil {
+		return nil, false
+	}
+	if exp := dht.RecordExpiry(rec); exp != 0 && now.Unix() > exp {
+		return nil, false
+	}
+	return rec, true
+}
+
+func (s *Store) Put(key [32]byte, rec *proto.DHTRecord, now time.Time) error {
+	if rec == nil {
+		return dht.ErrBadRecord
+	}
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		recs := tx.Bucket([]byte(bRecords))
+		byExp := tx.Bucket([]byte(bExpiry))
+
+		old := decodeRecord(recs.Get(key[:]))
+		if err := dht.CheckReplace(old, rec); err != nil {
+			return err
+		}
+		if old != nil && dht.RecordExpiry(old) != 0 {
+			if err := byExp.Delete(expiryKey(dht.RecordExpiry(old), key)); err != nil {
+				return err
+			}
+		}
+
+		if err := recs.Put(key[:], val); err != nil {
+			return err
+		}
+		if exp := dht.RecordExpiry(rec); exp != 0 {
+			return byExp.Put(expiryKey(exp, key), nil)
+		}
+		return nil
+	})
+}
+
+func (s *Store) Delete(key [32]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		recs := tx.Bucket([]byte(bRecords))
+		if old := decodeRecord(recs.Get(key[:])); old != nil && dht.RecordExpiry(old) != 0 {
+			if err := tx.Bucket([]byte(bExpiry)).Delete(expiryKey(dht.RecordExpiry(old), key)); err != nil {
+				return err
+			}
+		}
+		return recs.Delete(key[:])
+	})
+}
+
+// SweepExpired walks the expiry index up to now, so it only touches
+// records that are actually due.
+func (s *Store) SweepExpired(now time.Time) int {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	n := 0
+	_ = s.db.Update(func(tx *bolt.Tx) error {
+		recs := tx.Bucket([]byte(bRecords))
+		byExp := tx.Bucket([]byte(bExpiry))
+
+		// Records expire once now is past dht.RecordExpiry.
+		var due [][]byte
+		c := byExp.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			exp, _, ok := splitExpiryKey(k)
+			if !ok {
+				continue
+			}
+			if exp >= now.Unix() {
+				break
+			}
+			due = append(due, append([]byte(nil), k...))
+		}
+
+		for _, k := range due {
+			_, key, _ := splitExpiryKey(k)
+			if err := byExp.Delete(k); err != nil {
+				return err
+			}
+			if err := recs.Delete(key[:]); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// ForEach copies the records out first so fn may call back into the store.
+func (s *Store) ForEach(fn func(key [32]byte, rec *proto.DHTRecord) bool) {
+	type entry struct {
+		key [32]byte
+		rec *proto.DHTRecord
+	}
+	var all []entry
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bRecords)).ForEach(func(k, v []byte) error {
+			if len(k) != keyLen {
+				return nil
+			}
+			rec := decodeRecord(v)
+			if rec == nil {
+				// Corruption: keep going, don't brick the DHT.
+				return nil
+			}
+			var key [32]byte
+			copy(key[:], k)
+			all = append(all, entry{key: key, rec: rec})
+			return nil
+		})
+	})
+
+	for _, e := range all {
+		if !fn(e.key, e.rec) {
+			return
+		}
+	}
+}
+
+func (s *Store) Len() int {
+	n := 0
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte(bRecords)).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (s *Store) LoadOwned() (map[[32]byte]time.Time, error) {
+	out := make(map[[32]byte]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bOwned)).ForEach(func(k, v []byte) error {
+			if len(k) != keyLen || len(v) != 8 {
+				return nil
+			}
+			var key [32]byte
+			copy(key[:], k)
+			out[key] = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Store) PutOwned(key [32]byte, nextRepublish time.Time) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(nextRepublish.UnixNano()))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bOwned)).Put(key[:], v)
+	})
+}
+
+func (s *Store) DeleteOwned(key [32]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bOwned)).Delete(key[:])
+	})
+}
+
+func decodeRecord(raw []byte) *proto.DHTRecord {
+	if raw == nil {
+		return nil
+	}
+	var rec proto.DHTRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil
+	}
+	return &rec
+}
+
+func expiryKey(expiresUnix int64, key [32]byte) []byte {
+	// big-endian expiry for correct ordering, then the record key.
+	b := make([]byte, 8+keyLen)
+	binary.BigEndian.PutUint64(b[:8], uint64(expiresUnix))
+	copy(b[8:], key[:])
+	return b
+}
+
+func splitExpiryKey(k []byte) (int64, [32]byte, bool) {
+	var key [32]byte
+	if len(k) != 8+keyLen {
+		return 0, key, false
+	}
+	copy(key[:], k[8:])
+	return int64(binary.BigEndian.Uint64(k[:8])), key, true
+}
+
+// Compile-time checks that Store satisfies the interfaces.
+var (
+	_ dht.RecordStore = (*Store)(nil)
+	_ dht.OwnedStore  = (*Store)(nil)
+)
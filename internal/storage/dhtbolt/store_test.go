@@ -0,0 +1,137 @@
+package dhtbolt
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"p2p-park/internal/dht"
+	"p2p-park/internal/proto"
+)
+
+func openTemp(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dht.bolt")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestStore_MutableSeqAndReopen(t *testing.T) {
+	s, path := openTemp(t)
+	now := time.Now()
+	key := [32]byte{1}
+
+	if err := s.Put(key, &proto.DHTRecord{Type: dht.RecordMutable, Value: []byte("v2"), Seq: 2}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(key, &proto.DHTRecord{Type: dht.RecordMutable, Value: []byte("v1"), Seq: 1}, now); !errors.Is(err, dht.ErrSeqTooLow) {
+		t.Fatalf("older seq: err = %v", err)
+	}
+	if err := s.PutOwned(key, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec, ok := s.Get(key, now)
+	if !ok || string(rec.Value) != "v2" {
+		t.Fatalf("after reopen: %+v, %v", rec, ok)
+	}
+	owned, err := s.LoadOwned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := owned[key]; !ok || !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("owned after reopen = %v", owned)
+	}
+}
+
+func TestStore_SweepUsesExpiryIndex(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	now := time.Now()
+
+	put := func(b byte, expires int64) {
+		t.Helper()
+		if err := s.Put([32]byte{b}, &proto.DHTRecord{Type: dht.RecordImmutable, Value: []byte{b}, ExpiresUnix: expires}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(1, now.Add(-time.Minute).Unix())
+	put(2, now.Add(time.Hour).Unix())
+	put(3, 0) // never expires
+	put(4, now.Add(-time.Hour).Unix())
+	// Refreshing a record moves its index entry.
+	put(4, now.Add(time.Hour).Unix())
+
+	if got := s.SweepExpired(now); got != 1 {
+		t.Fatalf("swept %d; want 1", got)
+	}
+	if s.Len() != 3 {
+		t.Fatalf("len = %d; want 3", s.Len())
+	}
+	if _, ok := s.Get([32]byte{4}, now); !ok {
+		t.Fatalf("refreshed record was swept")
+	}
+
+	if err := s.Delete([32]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.SweepExpired(now.Add(2 * time.Hour)); got != 1 {
+		t.Fatalf("second sweep removed %d; want only record 4", got)
+	}
+}
+
+func TestStore_CachedCopyExpiresFirst(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	now := time.Now()
+
+	rec := &proto.DHTRecord{
+		Type:            dht.RecordImmutable,
+		Value:           []byte("v"),
+		ExpiresUnix:     now.Add(24 * time.Hour).Unix(),
+		CachedUntilUnix: now.Add(-time.Minute).Unix(),
+	}
+	if err := s.Put([32]byte{1}, rec, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get([32]byte{1}, now); ok {
+		t.Fatalf("cached copy served past CachedUntilUnix")
+	}
+	if got := s.SweepExpired(now); got != 1 {
+		t.Fatalf("swept %d; want the cached copy", got)
+	}
+}
+
+func TestStore_ReplicaReplacesCachedCopyOfSameSeq(t *testing.T) {
+	s, _ := openTemp(t)
+	defer s.Close()
+	now := time.Now()
+	key := [32]byte{1}
+
+	cached := &proto.DHTRecord{Type: dht.RecordMutable, Value: []byte("v"), Seq: 3, CachedUntilUnix: now.Add(time.Hour).Unix()}
+	if err := s.Put(key, cached, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(key, &proto.DHTRecord{Type: dht.RecordMutable, Value: []byte("v"), Seq: 3}, now); err != nil {
+		t.Fatalf("replica over a cached copy of the same seq: %v", err)
+	}
+	if rec, ok := s.Get(key, now); !ok || dht.IsCached(rec) {
+		t.Fatalf("held %+v; want the replica", rec)
+	}
+	// The cached copy's expiry index entry went with it.
+	if got := s.SweepExpired(now.Add(2 * time.Hour)); got != 0 {
+		t.Fatalf("swept %d; want the replica kept", got)
+	}
+}
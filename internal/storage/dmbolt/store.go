@@ -0,0 +1,235 @@
+package dmbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"p2p-park/internal/app/dm"
+)
+
+const (
+	bByID   = "dms_by_id"
+	bByPeer = "dms_by_peer"
+
+	defaultTO = 2 * time.Second
+)
+
+// Store is a BoltDB-backed implementation of dm.Store.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) a BoltDB database at path.
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("empty db path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: defaultTO})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db}
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bByID)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(bByPeer)); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error { return s.db.Close() }
+
+func (s *Store) Put(m dm.Message) (bool, error) {
+	if m.ID == "" || m.PeerUserID == "" {
+		return false, errors.New("missing message id or peer")
+	}
+
+	val, err := json.Marshal(m)
+	if err != nil {
+		return false, err
+	}
+
+	var inserted bool
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		byID := tx.Bucket([]byte(bByID))
+		byPeer := tx.Bucket([]byte(bByPeer))
+
+		if byID.Get([]byte(m.ID)) != nil {
+			return nil
+		}
+		if err := byID.Put([]byte(m.ID), val); err != nil {
+			return err
+		}
+		if err := byPeer.Put(peerKey(m.PeerUserID, m.Timestamp, m.ID), nil); err != nil {
+			return err
+		}
+		inserted = true
+		return nil
+	})
+	return inserted, err
+}
+
+func (s *Store) Get(id string) (dm.Message, bool, error) {
+	var m dm.Message
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket([]byte(bByID)).Get([]byte(id))
+		if raw == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(raw, &m)
+	})
+	return m, found, err
+}
+
+func (s *Store) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		byID := tx.Bucket([]byte(bByID))
+		raw := byID.Get([]byte(id))
+		if raw == nil {
+			return nil
+		}
+		var m dm.Message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(bByPeer)).Delete(peerKey(m.PeerUserID, m.Timestamp, m.ID)); err != nil {
+			return err
+		}
+		return byID.Delete([]byte(id))
+	})
+}
+
+func (s *Store) MarkDelivered(id string, at int64) (bool, error) {
+	var updated bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		byID := tx.Bucket([]byte(bByID))
+		raw := byID.Get([]byte(id))
+		if raw == nil {
+			return nil
+		}
+		var m dm.Message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+		if m.DeliveredAt != 0 {
+			return nil
+		}
+		m.DeliveredAt = at
+		val, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		updated = true
+		return byID.Put([]byte(id), val)
+	})
+	return updated, err
+}
+
+func (s *Store) List(peerUserID string, limit int) ([]dm.Message, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if peerUserID == "" {
+		return s.listAll(limit)
+	}
+	out := make([]dm.Message, 0, min(limit, 64))
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		byID := tx.Bucket([]byte(bByID))
+		c := tx.Bucket([]byte(bByPeer)).Cursor()
+
+		prefix := append([]byte(peerUserID), 0)
+
+		// peer || 0x01 sorts right after every peer || 0x00 || ... key;
+		// walk back from there newest-first.
+		k, _ := c.Seek(append([]byte(peerUserID), 1))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && len(out) < limit; k, _ = c.Prev() {
+			if !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			raw := byID.Get([]byte(idFromPeerKey(k)))
+			if raw == nil {
+				continue
+			}
+			var m dm.Message
+			if err := json.Unmarshal(raw, &m); err != nil {
+				continue
+			}
+			out = append(out, m)
+		}
+		return nil
+	})
+
+	slices.Reverse(out)
+	return out, err
+}
+
+// listAll returns the newest limit messages across all peers, oldest first.
+func (s *Store) listAll(limit int) ([]dm.Message, error) {
+	var out []dm.Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bByID)).ForEach(func(_, v []byte) error {
+			var m dm.Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return nil
+			}
+			out = append(out, m)
+			return nil
+		})
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, err
+}
+
+// peerKey orders messages by peer, then timestamp: peer || 0x00 || ts(8) || id.
+func peerKey(peerUserID string, ts int64, id string) []byte {
+	b := make([]byte, 0, len(peerUserID)+1+8+len(id))
+	b = append(b, peerUserID...)
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint64(b, uint64(ts))
+	b = append(b, id...)
+	return b
+}
+
+func idFromPeerKey(k []byte) string {
+	for i, c := range k {
+		if c == 0 && len(k) >= i+1+8 {
+			return string(k[i+1+8:])
+		}
+	}
+	return ""
+}
+
+// Compile-time check that Store satisfies the interface.
+var _ dm.Store = (*Store)(nil)